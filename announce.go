@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xoltia/mdk3/queue"
+	"github.com/xoltia/mpv"
+)
+
+// Placeholders replaced in the arguments of the text-to-speech command.
+const (
+	ttsTextPlaceholder   = "{text}"
+	ttsOutputPlaceholder = "{output}"
+)
+
+// ttsTimeout limits how long the text-to-speech command may run, as the
+// song waits for it to be loaded.
+const ttsTimeout = 30 * time.Second
+
+// announcer generates the spoken announcement of a song.
+type announcer interface {
+	// speak returns the path of a clip speaking the text.
+	speak(ctx context.Context, text string) (path string, err error)
+}
+
+// ttsAnnouncer speaks through a local text-to-speech command, such as
+// espeak-ng or piper. Clips are cached in dir by text, so that a singer
+// coming back with the same song is announced without running it again.
+type ttsAnnouncer struct {
+	command []string
+	dir     string
+	// mu keeps the same clip from being generated twice at once, by
+	// rooms sharing the announcer.
+	mu sync.Mutex
+}
+
+func newTTSAnnouncer(command []string, dir string) *ttsAnnouncer {
+	return &ttsAnnouncer{command: command, dir: dir}
+}
+
+// withAnnouncer announces each song before it starts, unless the singer
+// opted out.
+func withAnnouncer(a announcer) playerOption {
+	return func(p *Player) {
+		p.announcer = a
+	}
+}
+
+func (a *ttsAnnouncer) speak(ctx context.Context, text string) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(strings.Join(a.command, "\x00") + "\x00" + text))
+	path := filepath.Join(a.dir, hex.EncodeToString(sum[:16])+".wav")
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	if err := os.MkdirAll(a.dir, 0o755); err != nil {
+		return "", err
+	}
+
+	// The clip is written under another name so that a failed run does
+	// not leave a partial clip in the cache.
+	tmp := strings.TrimSuffix(path, ".wav") + ".tmp.wav"
+	defer os.Remove(tmp)
+	ctx, cancel := context.WithTimeout(ctx, ttsTimeout)
+	defer cancel()
+	if err := a.run(ctx, text, tmp); err != nil {
+		return "", err
+	}
+	return path, os.Rename(tmp, path)
+}
+
+// run runs the command, giving it the text on stdin if it does not take
+// it as an argument.
+func (a *ttsAnnouncer) run(ctx context.Context, text, output string) error {
+	args := make([]string, 0, len(a.command)-1)
+	for _, arg := range a.command[1:] {
+		arg = strings.ReplaceAll(arg, ttsTextPlaceholder, text)
+		args = append(args, strings.ReplaceAll(arg, ttsOutputPlaceholder, output))
+	}
+	cmd := exec.CommandContext(ctx, a.command[0], args...)
+	if !slices.ContainsFunc(a.command, func(arg string) bool { return strings.Contains(arg, ttsTextPlaceholder) }) {
+		cmd.Stdin = strings.NewReader(text)
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if message := lastLine(out); message != "" {
+			return fmt.Errorf("text-to-speech command failed: %s", message)
+		}
+		return fmt.Errorf("text-to-speech command failed: %w", err)
+	}
+	if _, err := os.Stat(output); err != nil {
+		return errors.New("text-to-speech command did not write a clip")
+	}
+	return nil
+}
+
+// announcementText is what is said before a song starts.
+func announcementText(song queue.QueuedSong, username string) string {
+	if username == "" {
+		return fmt.Sprintf("Next up: %s", song.Title)
+	}
+	return fmt.Sprintf("Next up: %s with %s", username, song.Title)
+}
+
+// loadAnnouncement appends the announcement of a song to the playlist,
+// with the poster at posterPath kept on screen while it is spoken. Songs
+// of singers who opted out are not announced.
+func (p *Player) loadAnnouncement(ctx context.Context, song queue.QueuedSong, username, posterPath string) {
+	if p.announcer == nil {
+		return
+	}
+	tx := p.q.BeginTxn(false)
+	settings, err := tx.GetUserSettings(song.UserID)
+	tx.Discard()
+	if err != nil {
+		slog.WarnContext(ctx, "Unable to read user settings", slog.String("err", err.Error()))
+	}
+	if settings.NoAnnouncement {
+		return
+	}
+
+	path, err := p.announcer.speak(ctx, announcementText(song, username))
+	if err != nil {
+		slog.ErrorContext(ctx, "Unable to generate announcement", slog.String("err", err.Error()))
+		return
+	}
+	options := map[string]string{"cover-art-files": posterPath}
+	if _, err := loadFileWithOptions(ctx, p.media, path, mpv.LoadFileModeAppend, options); err != nil {
+		slog.ErrorContext(ctx, "Unable to load announcement", slog.String("err", err.Error()))
+	}
+}
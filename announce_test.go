@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTTSAnnouncerCachesClips(t *testing.T) {
+	dir := t.TempDir()
+	runs := filepath.Join(dir, "runs")
+	// The text is read from stdin, as no argument takes it.
+	a := newTTSAnnouncer([]string{"sh", "-c", `cat > "$0"; echo run >> "$1"`, "{output}", runs}, filepath.Join(dir, "clips"))
+
+	path, err := a.speak(context.Background(), "Next up: Alice with Song")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(path); err != nil || string(b) != "Next up: Alice with Song" {
+		t.Errorf("expected clip with spoken text, got %q (%v)", b, err)
+	}
+
+	cached, err := a.speak(context.Background(), "Next up: Alice with Song")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached != path {
+		t.Errorf("expected cached clip %q, got %q", path, cached)
+	}
+	if b, _ := os.ReadFile(runs); strings.Count(string(b), "run") != 1 {
+		t.Errorf("expected command to run once, got %q", b)
+	}
+
+	failing := newTTSAnnouncer([]string{"sh", "-c", "echo no voice >&2; exit 1"}, filepath.Join(dir, "clips"))
+	if _, err := failing.speak(context.Background(), "Hello"); err == nil || !strings.Contains(err.Error(), "no voice") {
+		t.Errorf("expected command error, got %v", err)
+	}
+}
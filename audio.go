@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/xoltia/mdk3/queue"
+)
+
+// Limits of the adjustments users can make to a song.
+const (
+	maxPitchShift = 12
+	minTempo      = 0.5
+	maxTempo      = 2.0
+)
+
+// maxVolumeGain is the highest volume-gain in dB applied by mpv, the
+// default of its --volume-gain-max option.
+const maxVolumeGain = 12.0
+
+// vocalReductionFilter cancels the center channel, where the vocals of
+// most mixes are, by subtracting the channels from each other.
+const vocalReductionFilter = "lavfi=[pan=stereo|c0=c0-c1|c1=c1-c0]"
+
+// audioSettings are the per-song settings applied through mpv options.
+type audioSettings struct {
+	gain        float64
+	adjustments queue.Adjustments
+}
+
+// songAudioSettings returns the settings of a song, with the gain limited
+// to what mpv applies.
+func songAudioSettings(song queue.QueuedSong) audioSettings {
+	gain := min(song.TotalGain(), maxVolumeGain)
+	return audioSettings{gain: gain, adjustments: song.Adjustments}
+}
+
+// filters returns the value of the mpv af option for the settings.
+func (s audioSettings) filters() string {
+	var filters []string
+	if s.adjustments.Pitch != 0 {
+		scale := math.Pow(2, float64(s.adjustments.Pitch)/12)
+		filters = append(filters, "rubberband=pitch-scale="+strconv.FormatFloat(scale, 'f', 6, 64))
+	}
+	if s.adjustments.VocalReduction {
+		filters = append(filters, vocalReductionFilter)
+	}
+	return strings.Join(filters, ",")
+}
+
+// options returns the per-file options differing from the defaults.
+func (s audioSettings) options() map[string]string {
+	options := make(map[string]string)
+	if s.gain != 0 {
+		options["volume-gain"] = strconv.FormatFloat(s.gain, 'f', 2, 64)
+	}
+	if speed := s.adjustments.Speed(); speed != 1 {
+		options["speed"] = strconv.FormatFloat(speed, 'f', 2, 64)
+	}
+	if filters := s.filters(); filters != "" {
+		options["af"] = filters
+	}
+	return options
+}
+
+// changedProperties returns the properties to set to go from the
+// settings in old to s while a song is playing.
+func (s audioSettings) changedProperties(old audioSettings) map[string]any {
+	properties := make(map[string]any)
+	if s.gain != old.gain {
+		properties["volume-gain"] = s.gain
+	}
+	if s.adjustments.Speed() != old.adjustments.Speed() {
+		properties["speed"] = s.adjustments.Speed()
+	}
+	if s.filters() != old.filters() {
+		properties["af"] = s.filters()
+	}
+	return properties
+}
+
+// formatAdjustments describes the adjustments made to a song, or returns
+// an empty string if there are none.
+func formatAdjustments(a queue.Adjustments) string {
+	var parts []string
+	if a.Pitch != 0 {
+		parts = append(parts, fmt.Sprintf("Key %+d", a.Pitch))
+	}
+	if speed := a.Speed(); speed != 1 {
+		parts = append(parts, fmt.Sprintf("Tempo %d%%", int(math.Round(speed*100))))
+	}
+	if a.VocalReduction {
+		parts = append(parts, "Vocals reduced")
+	}
+	return strings.Join(parts, " · ")
+}
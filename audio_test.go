@@ -0,0 +1,67 @@
+package main
+
+import (
+	"maps"
+	"testing"
+
+	"github.com/xoltia/mdk3/queue"
+)
+
+func TestAudioSettingsOptions(t *testing.T) {
+	settings := audioSettings{
+		gain: -3,
+		adjustments: queue.Adjustments{
+			Pitch:          -12,
+			Tempo:          0.9,
+			VocalReduction: true,
+		},
+	}
+	expected := map[string]string{
+		"volume-gain": "-3.00",
+		"speed":       "0.90",
+		"af":          "rubberband=pitch-scale=0.500000," + vocalReductionFilter,
+	}
+	if options := settings.options(); !maps.Equal(options, expected) {
+		t.Errorf("expected options %v, got %v", expected, options)
+	}
+	if options := (audioSettings{}).options(); len(options) != 0 {
+		t.Errorf("expected no options for unadjusted song, got %v", options)
+	}
+
+	changed := settings.changedProperties(audioSettings{gain: -3, adjustments: queue.Adjustments{Tempo: 1}})
+	expectedChanges := map[string]any{
+		"speed": 0.9,
+		"af":    settings.filters(),
+	}
+	if !maps.Equal(changed, expectedChanges) {
+		t.Errorf("expected changed properties %v, got %v", expectedChanges, changed)
+	}
+}
+
+func TestSongAudioSettingsLimitsGain(t *testing.T) {
+	song := queue.QueuedSong{Gain: 10, GainMeasured: true, GainAdjust: 8}
+	if gain := songAudioSettings(song).gain; gain != maxVolumeGain {
+		t.Errorf("expected gain to be limited to %v, got %v", maxVolumeGain, gain)
+	}
+	song.GainAdjust = -20
+	if gain := songAudioSettings(song).gain; gain != -10 {
+		t.Errorf("expected gain of -10, got %v", gain)
+	}
+}
+
+func TestFormatAdjustments(t *testing.T) {
+	tests := []struct {
+		adjustments queue.Adjustments
+		expected    string
+	}{
+		{queue.Adjustments{}, ""},
+		{queue.Adjustments{Tempo: 1}, ""},
+		{queue.Adjustments{Pitch: 2}, "Key +2"},
+		{queue.Adjustments{Pitch: -3, Tempo: 0.85, VocalReduction: true}, "Key -3 · Tempo 85% · Vocals reduced"},
+	}
+	for _, test := range tests {
+		if s := formatAdjustments(test.adjustments); s != test.expected {
+			t.Errorf("%+v: expected %q, got %q", test.adjustments, test.expected, s)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"image"
+	"log/slog"
+
+	"github.com/xoltia/mdk3/queue"
+)
+
+// audioOnlyFormat is the format requested from yt-dlp for songs played
+// without video, which saves the bandwidth of the video stream.
+const audioOnlyFormat = "bestaudio/best"
+
+// Overlay slots of the poster and progress bar shown while a song plays
+// without video. Overlays with higher IDs are drawn on top.
+const (
+	audioPosterOverlayID = 1
+	progressOverlayID    = 2
+)
+
+// isAudioOnly reports whether a song is played without video, either
+// because it was requested that way or because every song is.
+func (p *Player) isAudioOnly(song queue.QueuedSong) bool {
+	return p.audioOnly || song.AudioOnly
+}
+
+// audioOnlyOptions adds the per-file options playing a song without
+// video.
+func audioOnlyOptions(options map[string]string) {
+	options["vid"] = "no"
+	options["ytdl-format"] = audioOnlyFormat
+}
+
+// renderAudioPoster renders the poster kept on screen while the song
+// plays without video, sized to the current screen.
+func (p *Player) renderAudioPoster(ctx context.Context, song queue.QueuedSong, username string, next []queue.QueuedSong, thumbnail image.Image) {
+	width, height := p.osdSize(ctx)
+	poster, err := p.posters.renderAudioPoster(song, username, next, thumbnail, width, height)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error rendering audio poster", slog.String("err", err.Error()))
+		return
+	}
+	p.current.setPoster(poster)
+}
+
+// showAudioPoster shows the poster of the current song along with its
+// progress. The backend loses the overlays when it restarts, so they are
+// shown again each time the song is waited for.
+func (p *Player) showAudioPoster(ctx context.Context, song queue.QueuedSong) {
+	poster := p.current.poster()
+	if poster.path != "" {
+		_, err := p.media.Command(ctx, "overlay-add", audioPosterOverlayID, 0, 0, poster.path, 0, "bgra", poster.width, poster.height, poster.width*4)
+		if err != nil && ctx.Err() == nil {
+			slog.ErrorContext(ctx, "Unable to show audio poster", slog.String("err", err.Error()))
+		}
+	}
+	p.showProgress(ctx, song, 0)
+}
+
+// showProgress shows a frame of the progress bar, centered near the
+// bottom of the screen.
+func (p *Player) showProgress(ctx context.Context, song queue.QueuedSong, frame int) {
+	width, height := p.osdSize(ctx)
+	elapsed := song.Adjustments.PlayTime(max(p.current.position()-song.Start, 0))
+	length := song.Adjustments.PlayTime(song.Length())
+	overlay, err := p.posters.renderProgress(elapsed, length, width*3/5, frame)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error rendering progress bar", slog.String("err", err.Error()))
+		return
+	}
+	x := max(width-overlay.width, 0) / 2
+	y := max(int(float64(height)*0.9)-overlay.height, 0)
+	_, err = p.media.Command(ctx, "overlay-add", progressOverlayID, x, y, overlay.path, 0, "bgra", overlay.width, overlay.height, overlay.width*4)
+	if err != nil && ctx.Err() == nil {
+		slog.ErrorContext(ctx, "Unable to show progress bar", slog.String("err", err.Error()))
+	}
+}
+
+func (p *Player) hideAudioPoster(ctx context.Context) {
+	for _, id := range []int{progressOverlayID, audioPosterOverlayID} {
+		if _, err := p.media.Command(ctx, "overlay-remove", id); err != nil {
+			slog.DebugContext(ctx, "Unable to remove audio poster", slog.String("err", err.Error()))
+		}
+	}
+}
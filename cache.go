@@ -0,0 +1,426 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xoltia/mdk3/queue"
+)
+
+// cacheState is the download state of a song in the media cache.
+type cacheState int
+
+const (
+	cacheMissing cacheState = iota
+	cacheDownloading
+	cacheReady
+	cacheFailed
+)
+
+type cacheEntry struct {
+	key      string
+	path     string
+	size     int64
+	state    cacheState
+	err      string
+	lastUsed time.Time
+
+	// measured is set once the loudness of the file has been measured,
+	// successfully or not.
+	measured    bool
+	loudness    float64
+	hasLoudness bool
+}
+
+// mediaCache downloads upcoming songs into a directory so that they can
+// be played from disk. The directory is limited in size, evicting the
+// least recently used files first.
+type mediaCache struct {
+	q        *queue.Queue
+	dir      string
+	maxBytes int64
+	prefetch int
+	interval time.Duration
+	download func(ctx context.Context, url, dir, key string) (path string, err error)
+	measure  func(ctx context.Context, path string) (float64, error)
+	// normalize enables measuring the loudness of downloaded songs to
+	// store the gain bringing them to the target loudness.
+	normalize      bool
+	targetLoudness float64
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+	pinned  []string
+	wake    chan struct{}
+}
+
+type mediaCacheOption func(*mediaCache)
+
+// withLoudnessTarget enables loudness normalization of downloaded songs
+// to the given integrated loudness in LUFS.
+func withLoudnessTarget(lufs float64) mediaCacheOption {
+	return func(c *mediaCache) {
+		c.normalize = true
+		c.targetLoudness = lufs
+	}
+}
+
+func newMediaCache(q *queue.Queue, dir string, maxBytes int64, prefetch int, options ...mediaCacheOption) *mediaCache {
+	c := &mediaCache{
+		q:        q,
+		dir:      dir,
+		maxBytes: maxBytes,
+		prefetch: prefetch,
+		interval: 10 * time.Second,
+		download: downloadWithYTDLP,
+		measure:  measureLoudness,
+		entries:  make(map[string]*cacheEntry),
+		wake:     make(chan struct{}, 1),
+	}
+	for _, opt := range options {
+		opt(c)
+	}
+	return c
+}
+
+// cacheKey returns the name under which the file for a URL is stored.
+func cacheKey(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return hex.EncodeToString(sum[:16])
+}
+
+// Load creates the cache directory and registers the files that were
+// downloaded by a previous run. The file of a song that is resumed after
+// a restart is kept.
+func (c *mediaCache) Load() error {
+	if err := os.MkdirAll(c.dir, 0o755); err != nil {
+		return err
+	}
+	files, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+	tx := c.q.BeginTxn(false)
+	playing, err := playingKey(tx)
+	tx.Discard()
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if playing != "" {
+		c.pinned = append(c.pinned, playing)
+	}
+	for _, file := range files {
+		name := file.Name()
+		// Partial downloads have more than one extension.
+		key, ext, _ := strings.Cut(name, ".")
+		if file.IsDir() || len(key) != 32 || ext == "" || strings.Contains(ext, ".") {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+		c.entries[key] = &cacheEntry{
+			key:      key,
+			path:     filepath.Join(c.dir, name),
+			size:     info.Size(),
+			state:    cacheReady,
+			lastUsed: info.ModTime(),
+		}
+	}
+	c.evictLocked()
+	return nil
+}
+
+// Wake makes the cache check the queue for songs to download.
+func (c *mediaCache) Wake() {
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run downloads and measures the next songs in the queue one at a time
+// until the context is cancelled.
+func (c *mediaCache) Run(ctx context.Context) {
+	for {
+		if song, ok := c.nextSong(ctx); ok {
+			c.process(ctx, song)
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.wake:
+		case <-time.After(c.interval):
+		}
+	}
+}
+
+// nextSong returns the first upcoming song that still has to be
+// downloaded or measured. The song being played and the upcoming songs
+// are kept from being evicted.
+func (c *mediaCache) nextSong(ctx context.Context) (next queue.QueuedSong, ok bool) {
+	if ctx.Err() != nil {
+		return next, false
+	}
+	tx := c.q.BeginTxn(false)
+	songs, err := tx.List(0, c.prefetch)
+	var playing string
+	if err == nil {
+		playing, err = playingKey(tx)
+	}
+	tx.Discard()
+	if err != nil {
+		slog.ErrorContext(ctx, "Unable to list songs to prefetch", slog.String("err", err.Error()))
+		return next, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pinned = c.pinned[:0]
+	if playing != "" {
+		c.pinned = append(c.pinned, playing)
+	}
+	for _, song := range songs {
+		key := cacheKey(song.SongURL)
+		c.pinned = append(c.pinned, key)
+		if !ok && c.pendingLocked(song) {
+			next, ok = song, true
+		}
+	}
+	return next, ok
+}
+
+// playingKey returns the key of the song being played, or an empty string
+// if none is.
+func playingKey(tx *queue.QueueTx) (string, error) {
+	np, err := tx.NowPlaying()
+	if errors.Is(err, queue.ErrNothingPlaying) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	song, err := tx.GetByID(np.SongID)
+	if errors.Is(err, queue.ErrSongNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return cacheKey(song.SongURL), nil
+}
+
+// pendingLocked reports whether there is work left for a song.
+func (c *mediaCache) pendingLocked(song queue.QueuedSong) bool {
+	entry, found := c.entries[cacheKey(song.SongURL)]
+	switch {
+	case !found:
+		return true
+	case !c.normalize || entry.state != cacheReady:
+		return false
+	case !entry.measured:
+		return true
+	default:
+		return entry.hasLoudness && !song.GainMeasured
+	}
+}
+
+// process does the next step for a song: downloading it, measuring its
+// loudness and storing its gain.
+func (c *mediaCache) process(ctx context.Context, song queue.QueuedSong) {
+	c.mu.Lock()
+	entry, found := c.entries[cacheKey(song.SongURL)]
+	var measured, hasLoudness bool
+	var path string
+	var loudness float64
+	if found {
+		path = entry.path
+		measured, hasLoudness, loudness = entry.measured, entry.hasLoudness, entry.loudness
+	}
+	c.mu.Unlock()
+
+	switch {
+	case !found:
+		c.fetch(ctx, song.SongURL)
+	case !measured:
+		c.measureEntry(ctx, entry, path)
+	case hasLoudness:
+		gain := normalizationGain(loudness, c.targetLoudness)
+		tx := c.q.BeginTxn(true)
+		defer tx.Discard()
+		err := tx.SetGain(song.ID, gain)
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			// Give up on the gain rather than retrying it forever.
+			slog.ErrorContext(ctx, "Unable to store song gain", slog.String("title", song.Title), slog.String("err", err.Error()))
+			c.mu.Lock()
+			entry.hasLoudness = false
+			c.mu.Unlock()
+			return
+		}
+		slog.InfoContext(ctx, "Stored song gain", slog.String("title", song.Title), slog.Float64("gain", gain))
+	}
+}
+
+func (c *mediaCache) measureEntry(ctx context.Context, entry *cacheEntry, path string) {
+	loudness, err := c.measure(ctx, path)
+	if ctx.Err() != nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry.measured = true
+	if err != nil {
+		slog.WarnContext(ctx, "Unable to measure loudness", slog.String("path", path), slog.String("err", err.Error()))
+		return
+	}
+	entry.loudness = loudness
+	entry.hasLoudness = true
+}
+
+func (c *mediaCache) fetch(ctx context.Context, url string) {
+	key := cacheKey(url)
+	entry := &cacheEntry{key: key, state: cacheDownloading}
+	c.mu.Lock()
+	c.entries[key] = entry
+	c.mu.Unlock()
+
+	slog.InfoContext(ctx, "Downloading song", slog.String("url", url))
+	path, err := c.download(ctx, url, c.dir, key)
+	var info os.FileInfo
+	if err == nil {
+		info, err = os.Stat(path)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ctx.Err() != nil {
+		delete(c.entries, key)
+		return
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "Unable to download song", slog.String("url", url), slog.String("err", err.Error()))
+		entry.state = cacheFailed
+		entry.err = err.Error()
+		return
+	}
+	entry.path = path
+	entry.size = info.Size()
+	entry.state = cacheReady
+	entry.lastUsed = time.Now()
+	c.evictLocked()
+}
+
+// evictLocked removes the least recently used files until the cache fits
+// in its size limit. Files of the song being played and of upcoming songs
+// are kept.
+func (c *mediaCache) evictLocked() {
+	var total int64
+	var candidates []*cacheEntry
+	for _, entry := range c.entries {
+		if entry.state != cacheReady {
+			continue
+		}
+		total += entry.size
+		if !slices.Contains(c.pinned, entry.key) {
+			candidates = append(candidates, entry)
+		}
+	}
+	slices.SortFunc(candidates, func(a, b *cacheEntry) int {
+		return a.lastUsed.Compare(b.lastUsed)
+	})
+
+	for _, entry := range candidates {
+		if total <= c.maxBytes {
+			break
+		}
+		if err := os.Remove(entry.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			slog.Warn("Unable to remove cached song", slog.String("path", entry.path), slog.String("err", err.Error()))
+			continue
+		}
+		total -= entry.size
+		delete(c.entries, entry.key)
+	}
+}
+
+// localFile returns the downloaded file for a URL if there is one.
+func (c *mediaCache) localFile(url string) (path string, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, found := c.entries[cacheKey(url)]
+	if !found || entry.state != cacheReady {
+		return "", false
+	}
+	entry.lastUsed = time.Now()
+	return entry.path, true
+}
+
+// state returns the download state of a URL, along with the download
+// error if it failed.
+func (c *mediaCache) state(url string) (state cacheState, reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, found := c.entries[cacheKey(url)]
+	if !found {
+		return cacheMissing, ""
+	}
+	return entry.state, entry.err
+}
+
+// downloadWithYTDLP downloads a URL into dir using yt-dlp, naming the
+// file after the key.
+func downloadWithYTDLP(ctx context.Context, url, dir, key string) (path string, err error) {
+	args := append(ytdlpOptions.formatArgs(),
+		"--no-playlist",
+		"--no-progress",
+		"--quiet",
+		"--output", filepath.Join(dir, key+".%(ext)s"),
+		"--print", "after_move:filepath",
+		url,
+	)
+	cmd := exec.CommandContext(ctx, ytdlpPath, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if message := lastLine(stderr.Bytes()); message != "" {
+			return "", errors.New(message)
+		}
+		return "", err
+	}
+	if path = lastLine(out); path == "" {
+		return "", fmt.Errorf("yt-dlp did not report a file for %s", url)
+	}
+	return path, nil
+}
+
+func lastLine(b []byte) (line string) {
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		if text := strings.TrimSpace(scanner.Text()); text != "" {
+			line = text
+		}
+	}
+	return line
+}
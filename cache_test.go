@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/xoltia/mdk3/queue"
+)
+
+// fakeDownloads writes files of a fixed size instead of running yt-dlp.
+type fakeDownloads struct {
+	size   int
+	failed map[string]error
+}
+
+func (d *fakeDownloads) download(_ context.Context, url, dir, key string) (string, error) {
+	if err := d.failed[url]; err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, key+".webm")
+	return path, os.WriteFile(path, make([]byte, d.size), 0o644)
+}
+
+func newTestCache(t *testing.T, maxBytes int64, prefetch int) (*mediaCache, *queue.Queue, *fakeDownloads) {
+	t.Helper()
+	q, err := queue.OpenQueue(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { q.Close() })
+
+	downloads := &fakeDownloads{size: 10, failed: make(map[string]error)}
+	c := newMediaCache(q, t.TempDir(), maxBytes, prefetch)
+	c.download = downloads.download
+	if err := c.Load(); err != nil {
+		t.Fatal(err)
+	}
+	return c, q, downloads
+}
+
+// prefetchAll processes songs until there is nothing left to do.
+func prefetchAll(c *mediaCache) {
+	ctx := context.Background()
+	for {
+		song, ok := c.nextSong(ctx)
+		if !ok {
+			return
+		}
+		c.process(ctx, song)
+	}
+}
+
+func TestMediaCachePrefetchesUpcomingSongs(t *testing.T) {
+	c, q, downloads := newTestCache(t, 1000, 2)
+	enqueueTestSong(t, q, "First", "https://example.com/first")
+	enqueueTestSong(t, q, "Second", "https://example.com/second")
+	enqueueTestSong(t, q, "Third", "https://example.com/third")
+	downloads.failed["https://example.com/second"] = errors.New("video unavailable")
+
+	prefetchAll(c)
+
+	if path, ok := c.localFile("https://example.com/first"); !ok || filepath.Dir(path) != c.dir {
+		t.Errorf("expected first song to be downloaded, got %q", path)
+	}
+	if state, reason := c.state("https://example.com/second"); state != cacheFailed || reason != "video unavailable" {
+		t.Errorf("expected second song to be flagged, got state %v (%q)", state, reason)
+	}
+	if state, _ := c.state("https://example.com/third"); state != cacheMissing {
+		t.Errorf("expected third song to be outside the prefetch window, got state %v", state)
+	}
+}
+
+func TestMediaCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c, q, _ := newTestCache(t, 25, 1)
+
+	enqueueTestSong(t, q, "First", "https://example.com/first")
+	prefetchAll(c)
+	dequeueTestSong(t, q)
+	enqueueTestSong(t, q, "Second", "https://example.com/second")
+	prefetchAll(c)
+	dequeueTestSong(t, q)
+
+	time.Sleep(time.Millisecond)
+	if _, ok := c.localFile("https://example.com/first"); !ok {
+		t.Fatal("expected first song to be cached")
+	}
+
+	enqueueTestSong(t, q, "Third", "https://example.com/third")
+	prefetchAll(c)
+
+	if _, ok := c.localFile("https://example.com/second"); ok {
+		t.Error("expected least recently used song to be evicted")
+	}
+	if _, ok := c.localFile("https://example.com/first"); !ok {
+		t.Error("expected recently used song to be kept")
+	}
+	if _, ok := c.localFile("https://example.com/third"); !ok {
+		t.Error("expected upcoming song to be kept")
+	}
+	files, err := os.ReadDir(c.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Errorf("expected evicted file to be removed, got %d files", len(files))
+	}
+}
+
+func TestMediaCacheKeepsSongBeingPlayed(t *testing.T) {
+	c, q, _ := newTestCache(t, 15, 1)
+
+	enqueueTestSong(t, q, "First", "https://example.com/first")
+	prefetchAll(c)
+	tx := q.BeginTxn(true)
+	defer tx.Discard()
+	song, err := tx.Dequeue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.SetNowPlaying(queue.NowPlaying{SongID: song.ID, Phase: queue.PhasePlaying}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	enqueueTestSong(t, q, "Second", "https://example.com/second")
+	prefetchAll(c)
+
+	if _, ok := c.localFile("https://example.com/first"); !ok {
+		t.Error("expected song being played to be kept")
+	}
+	if _, ok := c.localFile("https://example.com/second"); !ok {
+		t.Error("expected upcoming song to be kept")
+	}
+
+	reloaded := newMediaCache(q, c.dir, 5, c.prefetch)
+	if err := reloaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := reloaded.localFile("https://example.com/first"); !ok {
+		t.Error("expected song being resumed to be kept after a restart")
+	}
+}
+
+func TestMediaCacheLoadsExistingFiles(t *testing.T) {
+	c, _, _ := newTestCache(t, 1000, 1)
+	key := cacheKey("https://example.com/song")
+	for _, name := range []string{key + ".webm", key + ".f251.webm.part"} {
+		if err := os.WriteFile(filepath.Join(c.dir, name), []byte("data"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reloaded := newMediaCache(c.q, c.dir, c.maxBytes, c.prefetch)
+	if err := reloaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	path, ok := reloaded.localFile("https://example.com/song")
+	if !ok || filepath.Base(path) != key+".webm" {
+		t.Errorf("expected existing download to be used, got %q", path)
+	}
+	if len(reloaded.entries) != 1 {
+		t.Errorf("expected partial download to be ignored, got %d entries", len(reloaded.entries))
+	}
+}
+
+func TestMediaCacheStoresGain(t *testing.T) {
+	c, q, _ := newTestCache(t, 1000, 2)
+	withLoudnessTarget(-16)(c)
+	c.measure = func(_ context.Context, path string) (float64, error) {
+		if filepath.Base(path) == cacheKey("https://example.com/quiet")+".webm" {
+			return -22.5, nil
+		}
+		return 0, errors.New("no audio")
+	}
+	enqueueTestSong(t, q, "Quiet", "https://example.com/quiet")
+	enqueueTestSong(t, q, "Silent", "https://example.com/silent")
+
+	prefetchAll(c)
+
+	tx := q.BeginTxn(false)
+	defer tx.Discard()
+	songs, err := tx.List(0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !songs[0].GainMeasured || songs[0].Gain != 6.5 {
+		t.Errorf("expected gain 6.5, got %v (measured %v)", songs[0].Gain, songs[0].GainMeasured)
+	}
+	if songs[1].GainMeasured {
+		t.Errorf("expected no gain for song that could not be measured, got %v", songs[1].Gain)
+	}
+}
+
+func enqueueTestSong(t *testing.T, q *queue.Queue, title, url string) {
+	t.Helper()
+	tx := q.BeginTxn(true)
+	defer tx.Discard()
+	if _, err := tx.Enqueue(queue.NewSong{UserID: "1", Title: title, SongURL: url}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func dequeueTestSong(t *testing.T, q *queue.Queue) {
+	t.Helper()
+	tx := q.BeginTxn(true)
+	defer tx.Discard()
+	if _, err := tx.Dequeue(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -23,13 +23,29 @@ var commands = []api.CreateCommandData{
 	{
 		Name:        "enqueue",
 		Description: "Add a song to the queue.",
-		Options: []discord.CommandOption{
+		Options: append([]discord.CommandOption{
 			&discord.StringOption{
 				OptionName:  "url",
 				Description: "The URL of the song to add.",
 				Required:    true,
 			},
-		},
+			&discord.StringOption{
+				OptionName:  "language",
+				Description: "Language of the lyrics to show, such as en or ja.",
+			},
+			&discord.StringOption{
+				OptionName:  "start",
+				Description: "Where to start the song, such as 1:30. Defaults to the timestamp in the URL.",
+			},
+			&discord.StringOption{
+				OptionName:  "end",
+				Description: "Where to end the song, such as 3:45.",
+			},
+			&discord.BooleanOption{
+				OptionName:  "audio_only",
+				Description: "Play only the audio, showing the poster instead of the video.",
+			},
+		}, adjustmentOptions()...),
 	},
 	{
 		Name:        "list",
@@ -86,6 +102,164 @@ var commands = []api.CreateCommandData{
 		Name:        "stop",
 		Description: "Stop playing the queue. Will not stop the current song.",
 	},
+	{
+		Name:        "pause",
+		Description: "Pause the current song.",
+	},
+	{
+		Name:        "resume",
+		Description: "Resume the current song.",
+	},
+	{
+		Name:        "seek",
+		Description: "Seek within the current song.",
+		Options: []discord.CommandOption{
+			&discord.StringOption{
+				OptionName:  "position",
+				Description: "Position to seek to (e.g. 1:30), or an offset in seconds (e.g. +10, -10).",
+				Required:    true,
+			},
+		},
+	},
+	{
+		Name:        "volume",
+		Description: "Change the playback volume.",
+		Options: []discord.CommandOption{
+			&discord.IntegerOption{
+				OptionName:  "level",
+				Description: "The volume level in percent.",
+				Required:    true,
+				Min:         option.NewInt(0),
+				Max:         option.NewInt(maxVolume),
+			},
+		},
+	},
+	{
+		Name:        "skip",
+		Description: "Skip the current song.",
+	},
+	{
+		Name:        "requeue",
+		Description: "Put the song interrupted by a restart back at the front of the queue.",
+	},
+	{
+		Name:        "adjust",
+		Description: "Change the key, tempo or vocals of a song.",
+		Options: append([]discord.CommandOption{
+			&discord.StringOption{
+				OptionName:  "id",
+				Description: "The ID of the song to change. Defaults to the current song.",
+			},
+		}, adjustmentOptions()...),
+	},
+	{
+		Name:        "gain",
+		Description: "Make a song louder or quieter.",
+		Options: []discord.CommandOption{
+			&discord.NumberOption{
+				OptionName:  "db",
+				Description: "Decibels to add to the volume of the song, negative to lower it.",
+				Required:    true,
+				Min:         option.NewFloat(-maxVolumeGain),
+				Max:         option.NewFloat(maxVolumeGain),
+			},
+			&discord.StringOption{
+				OptionName:  "id",
+				Description: "The ID of the song to change. Defaults to the current song.",
+			},
+		},
+	},
+	{
+		Name:        "exempt",
+		Description: "Exempt a song from the maximum play time.",
+		Options: []discord.CommandOption{
+			&discord.StringOption{
+				OptionName:  "id",
+				Description: "The ID of the song to exempt. Defaults to the current song.",
+			},
+			&discord.BooleanOption{
+				OptionName:  "exempt",
+				Description: "Whether the song is exempt. Defaults to true.",
+			},
+		},
+	},
+	{
+		Name:        "report",
+		Description: "Compare the predicted and actual start of the songs played tonight.",
+	},
+	{
+		Name:        "announce",
+		Description: "Choose whether your songs are announced before they start.",
+		Options: []discord.CommandOption{
+			&discord.BooleanOption{
+				OptionName:  "enabled",
+				Description: "Whether your songs are announced.",
+				Required:    true,
+			},
+		},
+	},
+	{
+		Name:        "profile",
+		Description: "Switch the mpv profile, restarting the player.",
+		Options: []discord.CommandOption{
+			&discord.StringOption{
+				OptionName:  "name",
+				Description: "The profile to switch to. Lists the profiles if not given.",
+			},
+		},
+	},
+}
+
+// adjustmentOptions returns the options for the adjustments made to a
+// song, shared by the commands setting them.
+func adjustmentOptions() []discord.CommandOption {
+	return []discord.CommandOption{
+		&discord.IntegerOption{
+			OptionName:  "pitch",
+			Description: "Semitones to shift the key by.",
+			Min:         option.NewInt(-maxPitchShift),
+			Max:         option.NewInt(maxPitchShift),
+		},
+		&discord.NumberOption{
+			OptionName:  "tempo",
+			Description: "Playback speed, 1 being the original speed.",
+			Min:         option.NewFloat(minTempo),
+			Max:         option.NewFloat(maxTempo),
+		},
+		&discord.BooleanOption{
+			OptionName:  "vocal_reduction",
+			Description: "Reduce the vocals of the song.",
+		},
+	}
+}
+
+// adjustmentValues holds the adjustment options given to a command, nil
+// if they were not given.
+type adjustmentValues struct {
+	pitch          *int
+	tempo          *float64
+	vocalReduction *bool
+}
+
+// apply changes the adjustments that were given, returning false if a
+// value is out of range.
+func (v adjustmentValues) apply(a *queue.Adjustments) bool {
+	if v.pitch != nil {
+		if *v.pitch < -maxPitchShift || *v.pitch > maxPitchShift {
+			return false
+		}
+		a.Pitch = *v.pitch
+	}
+	if v.tempo != nil {
+		if *v.tempo < minTempo || *v.tempo > maxTempo {
+			return false
+		}
+		a.Tempo = *v.tempo
+	}
+	if v.vocalReduction != nil {
+		a.VocalReduction = *v.vocalReduction
+	}
+	return true
 }
 
 type queueCommandHandler struct {
@@ -96,6 +270,15 @@ type queueCommandHandler struct {
 	userLimit    int
 	adminRoles   []discord.RoleID
 	playbackTime time.Duration
+	maxPlayTime  time.Duration
+	player       *Player
+	cache        *mediaCache
+	subtitles    *subtitleFinder
+	launcher     *mpvLauncher
+	mpv          *mpvSupervisor
+	// room is the name of the room the handler is for, empty if there is
+	// only one.
+	room string
 }
 
 type queueCommandHandlerOption func(*queueCommandHandler)
@@ -106,82 +289,686 @@ func withUserLimit(limit int) queueCommandHandlerOption {
 	}
 }
 
-func withAdminRoles(roles []discord.Snowflake) queueCommandHandlerOption {
-	return func(h *queueCommandHandler) {
-		for _, role := range roles {
-			h.adminRoles = append(h.adminRoles, discord.RoleID(role))
-		}
+func withAdminRoles(roles []discord.Snowflake) queueCommandHandlerOption {
+	return func(h *queueCommandHandler) {
+		for _, role := range roles {
+			h.adminRoles = append(h.adminRoles, discord.RoleID(role))
+		}
+	}
+}
+
+func withPlaybackTime(d time.Duration) queueCommandHandlerOption {
+	return func(h *queueCommandHandler) {
+		h.playbackTime = d
+	}
+}
+
+// withPlayTimeLimit caps the length of songs in play time estimates to
+// the maximum play time enforced by the player.
+func withPlayTimeLimit(d time.Duration) queueCommandHandlerOption {
+	return func(h *queueCommandHandler) {
+		h.maxPlayTime = d
+	}
+}
+
+func withPlayer(p *Player) queueCommandHandlerOption {
+	return func(h *queueCommandHandler) {
+		h.player = p
+	}
+}
+
+func withMediaCache(c *mediaCache) queueCommandHandlerOption {
+	return func(h *queueCommandHandler) {
+		h.cache = c
+	}
+}
+
+func withSubtitleFinder(f *subtitleFinder) queueCommandHandlerOption {
+	return func(h *queueCommandHandler) {
+		h.subtitles = f
+	}
+}
+
+// withMPVProfiles allows admins to switch the profile mpv is launched
+// with, restarting it.
+func withMPVProfiles(l *mpvLauncher, s *mpvSupervisor) queueCommandHandlerOption {
+	return func(h *queueCommandHandler) {
+		h.launcher = l
+		h.mpv = s
+	}
+}
+
+// withRoom makes the handler serve the room with the given name.
+func withRoom(name string) queueCommandHandlerOption {
+	return func(h *queueCommandHandler) {
+		h.room = name
+	}
+}
+
+func newHandler(s *state.State, q *queue.Queue, options ...queueCommandHandlerOption) *queueCommandHandler {
+	h := &queueCommandHandler{
+		s:          s,
+		q:          q,
+		userLimit:  1,
+		pageSize:   5,
+		adminRoles: []discord.RoleID{},
+	}
+
+	for _, opt := range options {
+		opt(h)
+	}
+
+	h.Router = cmdroute.NewRouter()
+	h.Use(cmdroute.Deferrable(s, cmdroute.DeferOpts{}))
+	h.AddFunc("enqueue", h.cmdEnqueue)
+	h.AddFunc("list", h.cmdList)
+	h.AddFunc("remove", h.cmdRemove)
+	h.AddFunc("swap", h.cmdSwap)
+	h.AddFunc("move", h.cmdMove)
+	h.AddFunc("start", h.cmdStart)
+	h.AddFunc("stop", h.cmdStop)
+	h.AddFunc("pause", h.cmdPause)
+	h.AddFunc("resume", h.cmdResume)
+	h.AddFunc("seek", h.cmdSeek)
+	h.AddFunc("volume", h.cmdVolume)
+	h.AddFunc("skip", h.cmdSkip)
+	h.AddFunc("requeue", h.cmdRequeue)
+	h.AddFunc("adjust", h.cmdAdjust)
+	h.AddFunc("gain", h.cmdGain)
+	h.AddFunc("exempt", h.cmdExempt)
+	h.AddFunc("profile", h.cmdProfile)
+	h.AddFunc("announce", h.cmdAnnounce)
+	h.AddFunc("report", h.cmdReport)
+
+	return h
+}
+
+func (h *queueCommandHandler) cmdStart(_ context.Context, data cmdroute.CommandData) *api.InteractionResponseData {
+	if !h.isAdmin(data.Event.Member) {
+		return &api.InteractionResponseData{
+			Content:         option.NewNullableString("You are not allowed to start the queue."),
+			Flags:           discord.EphemeralMessage,
+			AllowedMentions: &api.AllowedMentions{},
+		}
+	}
+
+	message := "Queue playback started."
+	if h.player.SetDequeueEnabled(true) {
+		message = "Queue playback already started."
+	}
+	return &api.InteractionResponseData{
+		Content:         option.NewNullableString(message),
+		Flags:           discord.EphemeralMessage,
+		AllowedMentions: &api.AllowedMentions{},
+	}
+}
+
+func (h *queueCommandHandler) cmdStop(_ context.Context, data cmdroute.CommandData) *api.InteractionResponseData {
+	if !h.isAdmin(data.Event.Member) {
+		return &api.InteractionResponseData{
+			Content:         option.NewNullableString("You are not allowed to stop the queue."),
+			Flags:           discord.EphemeralMessage,
+			AllowedMentions: &api.AllowedMentions{},
+		}
+	}
+
+	message := "Queue playback stopped."
+	if !h.player.SetDequeueEnabled(false) {
+		message = "Queue playback already stopped."
+	}
+	return &api.InteractionResponseData{
+		Content:         option.NewNullableString(message),
+		Flags:           discord.EphemeralMessage,
+		AllowedMentions: &api.AllowedMentions{},
+	}
+}
+
+func (h *queueCommandHandler) cmdPause(ctx context.Context, data cmdroute.CommandData) *api.InteractionResponseData {
+	if !h.isAdmin(data.Event.Member) {
+		return &api.InteractionResponseData{
+			Content:         option.NewNullableString("You are not allowed to pause playback."),
+			Flags:           discord.EphemeralMessage,
+			AllowedMentions: &api.AllowedMentions{},
+		}
+	}
+
+	if err := h.player.Pause(ctx); err != nil {
+		slog.ErrorContext(ctx, "Cannot pause playback", slog.String("err", err.Error()))
+		return errorResponse(err)
+	}
+
+	return h.playbackStatusResponse(ctx, "Playback paused.")
+}
+
+func (h *queueCommandHandler) cmdResume(ctx context.Context, data cmdroute.CommandData) *api.InteractionResponseData {
+	if !h.isAdmin(data.Event.Member) {
+		return &api.InteractionResponseData{
+			Content:         option.NewNullableString("You are not allowed to resume playback."),
+			Flags:           discord.EphemeralMessage,
+			AllowedMentions: &api.AllowedMentions{},
+		}
+	}
+
+	if err := h.player.Resume(ctx); err != nil {
+		slog.ErrorContext(ctx, "Cannot resume playback", slog.String("err", err.Error()))
+		return errorResponse(err)
+	}
+
+	return h.playbackStatusResponse(ctx, "Playback resumed.")
+}
+
+func (h *queueCommandHandler) cmdSeek(ctx context.Context, data cmdroute.CommandData) *api.InteractionResponseData {
+	var options struct {
+		Position string `discord:"position"`
+	}
+
+	if err := data.Options.Unmarshal(&options); err != nil {
+		return errorResponse(err)
+	}
+
+	if !h.isAdmin(data.Event.Member) {
+		return &api.InteractionResponseData{
+			Content:         option.NewNullableString("You are not allowed to seek."),
+			Flags:           discord.EphemeralMessage,
+			AllowedMentions: &api.AllowedMentions{},
+		}
+	}
+
+	position, relative, err := parseSeekPosition(options.Position)
+	if err != nil {
+		return &api.InteractionResponseData{
+			Content:         option.NewNullableString("Invalid position."),
+			Flags:           discord.EphemeralMessage,
+			AllowedMentions: &api.AllowedMentions{},
+		}
+	}
+
+	if err := h.player.Seek(ctx, position, relative); err != nil {
+		slog.ErrorContext(ctx, "Cannot seek", slog.String("err", err.Error()))
+		return errorResponse(err)
+	}
+
+	return h.playbackStatusResponse(ctx, "Seeked.")
+}
+
+func (h *queueCommandHandler) cmdVolume(ctx context.Context, data cmdroute.CommandData) *api.InteractionResponseData {
+	var options struct {
+		Level int `discord:"level"`
+	}
+
+	if err := data.Options.Unmarshal(&options); err != nil {
+		return errorResponse(err)
+	}
+
+	if !h.isAdmin(data.Event.Member) {
+		return &api.InteractionResponseData{
+			Content:         option.NewNullableString("You are not allowed to change the volume."),
+			Flags:           discord.EphemeralMessage,
+			AllowedMentions: &api.AllowedMentions{},
+		}
+	}
+
+	if options.Level < 0 || options.Level > maxVolume {
+		return &api.InteractionResponseData{
+			Content:         option.NewNullableString("Invalid volume level."),
+			Flags:           discord.EphemeralMessage,
+			AllowedMentions: &api.AllowedMentions{},
+		}
+	}
+
+	if err := h.player.SetVolume(ctx, float64(options.Level)); err != nil {
+		slog.ErrorContext(ctx, "Cannot set volume", slog.String("err", err.Error()))
+		return errorResponse(err)
+	}
+
+	return h.playbackStatusResponse(ctx, "Volume changed.")
+}
+
+func (h *queueCommandHandler) cmdSkip(ctx context.Context, data cmdroute.CommandData) *api.InteractionResponseData {
+	if !h.isAdmin(data.Event.Member) {
+		return &api.InteractionResponseData{
+			Content:         option.NewNullableString("You are not allowed to skip songs."),
+			Flags:           discord.EphemeralMessage,
+			AllowedMentions: &api.AllowedMentions{},
+		}
+	}
+
+	song := h.player.Skip()
+	if song == nil {
+		return &api.InteractionResponseData{
+			Content:         option.NewNullableString("There is no song to skip."),
+			Flags:           discord.EphemeralMessage,
+			AllowedMentions: &api.AllowedMentions{},
+		}
+	}
+
+	return h.playbackStatusResponse(ctx, fmt.Sprintf("Skipped %s.", song.Title))
+}
+
+func (h *queueCommandHandler) cmdRequeue(ctx context.Context, data cmdroute.CommandData) *api.InteractionResponseData {
+	if !h.isAdmin(data.Event.Member) {
+		return &api.InteractionResponseData{
+			Content:         option.NewNullableString("You are not allowed to requeue songs."),
+			Flags:           discord.EphemeralMessage,
+			AllowedMentions: &api.AllowedMentions{},
+		}
+	}
+
+	song, err := h.player.RequeueInterrupted()
+	if errors.Is(err, errNothingInterrupted) {
+		return &api.InteractionResponseData{
+			Content:         option.NewNullableString("There is no interrupted song to requeue."),
+			Flags:           discord.EphemeralMessage,
+			AllowedMentions: &api.AllowedMentions{},
+		}
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Cannot requeue interrupted song", slog.String("err", err.Error()))
+		return errorResponse(err)
+	}
+
+	return &api.InteractionResponseData{
+		Content:         option.NewNullableString(fmt.Sprintf("Requeued %s at the front of the queue (ID: %s).", song.Title, song.Slug)),
+		Flags:           discord.EphemeralMessage,
+		AllowedMentions: &api.AllowedMentions{},
+	}
+}
+
+// songByIDOrCurrent returns the song with the given ID, or the current
+// song if the ID is empty.
+func (h *queueCommandHandler) songByIDOrCurrent(tx *queue.QueueTx, id string) (queue.QueuedSong, error) {
+	if id != "" {
+		return tx.GetBySlug(id)
+	}
+	if current := h.player.Current(); current != nil {
+		return tx.GetByID(current.ID)
+	}
+	return queue.QueuedSong{}, queue.ErrSongNotFound
+}
+
+func (h *queueCommandHandler) cmdAdjust(ctx context.Context, data cmdroute.CommandData) *api.InteractionResponseData {
+	var options struct {
+		ID             string   `discord:"id?"`
+		Pitch          *int     `discord:"pitch"`
+		Tempo          *float64 `discord:"tempo"`
+		VocalReduction *bool    `discord:"vocal_reduction"`
+	}
+
+	if err := data.Options.Unmarshal(&options); err != nil {
+		return errorResponse(err)
+	}
+
+	tx := h.q.BeginTxn(true)
+	defer tx.Discard()
+
+	song, err := h.songByIDOrCurrent(tx, options.ID)
+	if err == queue.ErrSongNotFound {
+		return &api.InteractionResponseData{
+			Content:         option.NewNullableString("Song not found."),
+			Flags:           discord.EphemeralMessage,
+			AllowedMentions: &api.AllowedMentions{},
+		}
+	} else if err != nil {
+		slog.ErrorContext(ctx, "Cannot find song", slog.String("err", err.Error()))
+		return errorResponse(err)
+	}
+
+	member := data.Event.Member
+	if member.User.ID.String() != song.UserID && !h.isAdmin(member) {
+		return &api.InteractionResponseData{
+			Content:         option.NewNullableString("You are not allowed to adjust this song."),
+			Flags:           discord.EphemeralMessage,
+			AllowedMentions: &api.AllowedMentions{},
+		}
+	}
+
+	values := adjustmentValues{options.Pitch, options.Tempo, options.VocalReduction}
+	if !values.apply(&song.Adjustments) {
+		return &api.InteractionResponseData{
+			Content:         option.NewNullableString("Invalid adjustments."),
+			Flags:           discord.EphemeralMessage,
+			AllowedMentions: &api.AllowedMentions{},
+		}
+	}
+
+	if err := tx.Update(song.ID, song.NewSong); err != nil {
+		slog.ErrorContext(ctx, "Cannot update song", slog.String("err", err.Error()))
+		return errorResponse(err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		slog.ErrorContext(ctx, "Cannot commit transaction", slog.String("err", err.Error()))
+		return errorResponse(err)
+	}
+
+	if err := h.player.UpdateSong(ctx, song); err != nil {
+		return errorResponse(err)
+	}
+
+	description := formatAdjustments(song.Adjustments)
+	if description == "" {
+		description = "no adjustments"
+	}
+	return &api.InteractionResponseData{
+		Content:         option.NewNullableString(fmt.Sprintf("%s will be played with %s.", song.Title, description)),
+		Flags:           discord.EphemeralMessage,
+		AllowedMentions: &api.AllowedMentions{},
+	}
+}
+
+func (h *queueCommandHandler) cmdGain(ctx context.Context, data cmdroute.CommandData) *api.InteractionResponseData {
+	var options struct {
+		DB float64 `discord:"db"`
+		ID string  `discord:"id?"`
+	}
+
+	if err := data.Options.Unmarshal(&options); err != nil {
+		return errorResponse(err)
+	}
+
+	if !h.isAdmin(data.Event.Member) {
+		return &api.InteractionResponseData{
+			Content:         option.NewNullableString("You are not allowed to change the gain of songs."),
+			Flags:           discord.EphemeralMessage,
+			AllowedMentions: &api.AllowedMentions{},
+		}
+	}
+
+	tx := h.q.BeginTxn(true)
+	defer tx.Discard()
+
+	song, err := h.songByIDOrCurrent(tx, options.ID)
+	if err == queue.ErrSongNotFound {
+		return &api.InteractionResponseData{
+			Content:         option.NewNullableString("Song not found."),
+			Flags:           discord.EphemeralMessage,
+			AllowedMentions: &api.AllowedMentions{},
+		}
+	} else if err != nil {
+		slog.ErrorContext(ctx, "Cannot find song", slog.String("err", err.Error()))
+		return errorResponse(err)
+	}
+
+	previous := songAudioSettings(song).gain
+	song, err = tx.AdjustGain(song.ID, options.DB, maxVolumeGain)
+	if err != nil {
+		slog.ErrorContext(ctx, "Cannot adjust song gain", slog.String("err", err.Error()))
+		return errorResponse(err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		slog.ErrorContext(ctx, "Cannot commit transaction", slog.String("err", err.Error()))
+		return errorResponse(err)
+	}
+
+	if err := h.player.UpdateSong(ctx, song); err != nil {
+		return errorResponse(err)
+	}
+
+	gain := songAudioSettings(song).gain
+	return &api.InteractionResponseData{
+		Content:         option.NewNullableString(fmt.Sprintf("Gain of %s changed by %+.1f dB, now %+.1f dB.", song.Title, gain-previous, gain)),
+		Flags:           discord.EphemeralMessage,
+		AllowedMentions: &api.AllowedMentions{},
+	}
+}
+
+func (h *queueCommandHandler) cmdExempt(ctx context.Context, data cmdroute.CommandData) *api.InteractionResponseData {
+	var options struct {
+		ID     string `discord:"id?"`
+		Exempt *bool  `discord:"exempt"`
+	}
+
+	if err := data.Options.Unmarshal(&options); err != nil {
+		return errorResponse(err)
+	}
+
+	if !h.isAdmin(data.Event.Member) {
+		return &api.InteractionResponseData{
+			Content:         option.NewNullableString("You are not allowed to exempt songs."),
+			Flags:           discord.EphemeralMessage,
+			AllowedMentions: &api.AllowedMentions{},
+		}
+	}
+
+	tx := h.q.BeginTxn(true)
+	defer tx.Discard()
+
+	song, err := h.songByIDOrCurrent(tx, options.ID)
+	if err == queue.ErrSongNotFound {
+		return &api.InteractionResponseData{
+			Content:         option.NewNullableString("Song not found."),
+			Flags:           discord.EphemeralMessage,
+			AllowedMentions: &api.AllowedMentions{},
+		}
+	} else if err != nil {
+		slog.ErrorContext(ctx, "Cannot find song", slog.String("err", err.Error()))
+		return errorResponse(err)
+	}
+
+	exempt := options.Exempt == nil || *options.Exempt
+	song, err = tx.SetUnlimited(song.ID, exempt)
+	if err != nil {
+		slog.ErrorContext(ctx, "Cannot exempt song", slog.String("err", err.Error()))
+		return errorResponse(err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		slog.ErrorContext(ctx, "Cannot commit transaction", slog.String("err", err.Error()))
+		return errorResponse(err)
+	}
+
+	if err := h.player.UpdateSong(ctx, song); err != nil {
+		return errorResponse(err)
+	}
+
+	message := fmt.Sprintf("%s is now exempt from the maximum play time.", song.Title)
+	if !exempt {
+		message = fmt.Sprintf("%s is no longer exempt from the maximum play time.", song.Title)
+	}
+	return &api.InteractionResponseData{
+		Content:         option.NewNullableString(message),
+		Flags:           discord.EphemeralMessage,
+		AllowedMentions: &api.AllowedMentions{},
+	}
+}
+
+// reportSongs is how many songs the ETA report lists.
+const reportSongs = 10
+
+func (h *queueCommandHandler) cmdReport(ctx context.Context, data cmdroute.CommandData) *api.InteractionResponseData {
+	if !h.isAdmin(data.Event.Member) {
+		return &api.InteractionResponseData{
+			Content:         option.NewNullableString("You are not allowed to view the report."),
+			Flags:           discord.EphemeralMessage,
+			AllowedMentions: &api.AllowedMentions{},
+		}
+	}
+
+	tx := h.q.BeginTxn(false)
+	defer tx.Discard()
+
+	now := time.Now()
+	report, err := newETAReport(tx, now)
+	if err != nil {
+		slog.ErrorContext(ctx, "Cannot build ETA report", slog.String("err", err.Error()))
+		return errorResponse(err)
+	}
+	eta, err := newETAEstimator(tx, now, h.playbackTime, h.playLength)
+	if err != nil {
+		slog.ErrorContext(ctx, "Cannot estimate play times", slog.String("err", err.Error()))
+		return errorResponse(err)
+	}
+
+	embed := discord.NewEmbed()
+	embed.Title = "ETA Report"
+	if len(report.songs) == 0 {
+		embed.Description = "No song with a predicted start has been played yet."
+	} else {
+		embed.Description = fmt.Sprintf(
+			"%d songs started on average %s from their predicted time (%s overall). Turns are currently estimated at their song length %s.",
+			len(report.songs), report.off.Round(time.Second), formatOffset(report.late), formatOffset(eta.overhead),
+		)
+	}
+	for _, song := range report.songs[:min(len(report.songs), reportSongs)] {
+		embed.Fields = append(embed.Fields, discord.EmbedField{
+			Name: song.Title,
+			Value: fmt.Sprintf(
+				"Predicted <t:%d:t>, started <t:%d:t> (%s)",
+				song.Times.Predicted.Unix(), song.Times.Countdown.Unix(), formatOffset(song.Times.Countdown.Sub(song.Times.Predicted)),
+			),
+		})
+	}
+
+	return &api.InteractionResponseData{
+		Embeds:          &[]discord.Embed{*embed},
+		Flags:           discord.EphemeralMessage,
+		AllowedMentions: &api.AllowedMentions{},
 	}
 }
 
-func withPlaybackTime(d time.Duration) queueCommandHandlerOption {
-	return func(h *queueCommandHandler) {
-		h.playbackTime = d
+func (h *queueCommandHandler) cmdAnnounce(ctx context.Context, data cmdroute.CommandData) *api.InteractionResponseData {
+	var options struct {
+		Enabled bool `discord:"enabled"`
 	}
-}
 
-func newHandler(s *state.State, q *queue.Queue, options ...queueCommandHandlerOption) *queueCommandHandler {
-	h := &queueCommandHandler{
-		s:          s,
-		q:          q,
-		userLimit:  1,
-		pageSize:   5,
-		adminRoles: []discord.RoleID{},
+	if err := data.Options.Unmarshal(&options); err != nil {
+		return errorResponse(err)
 	}
 
-	for _, opt := range options {
-		opt(h)
+	tx := h.q.BeginTxn(true)
+	defer tx.Discard()
+
+	userID := data.Event.Member.User.ID.String()
+	settings, err := tx.GetUserSettings(userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Cannot get user settings", slog.String("err", err.Error()))
+		return errorResponse(err)
+	}
+	settings.NoAnnouncement = !options.Enabled
+	if err := tx.SetUserSettings(userID, settings); err != nil {
+		slog.ErrorContext(ctx, "Cannot set user settings", slog.String("err", err.Error()))
+		return errorResponse(err)
 	}
 
-	h.s.AddInteractionHandlerFunc(h.handleComponentInteraction)
-	h.Router = cmdroute.NewRouter()
-	h.Use(cmdroute.Deferrable(s, cmdroute.DeferOpts{}))
-	h.AddFunc("enqueue", h.cmdEnqueue)
-	h.AddFunc("list", h.cmdList)
-	h.AddFunc("remove", h.cmdRemove)
-	h.AddFunc("swap", h.cmdSwap)
-	h.AddFunc("move", h.cmdMove)
-	h.AddFunc("start", h.cmdStart)
-	h.AddFunc("stop", h.cmdStop)
+	if err := tx.Commit(); err != nil {
+		slog.ErrorContext(ctx, "Cannot commit transaction", slog.String("err", err.Error()))
+		return errorResponse(err)
+	}
 
-	return h
+	message := "Your songs will be announced before they start."
+	if !options.Enabled {
+		message = "Your songs will no longer be announced."
+	}
+	return &api.InteractionResponseData{
+		Content:         option.NewNullableString(message),
+		Flags:           discord.EphemeralMessage,
+		AllowedMentions: &api.AllowedMentions{},
+	}
 }
 
-func (h *queueCommandHandler) cmdStart(_ context.Context, data cmdroute.CommandData) *api.InteractionResponseData {
+func (h *queueCommandHandler) cmdProfile(ctx context.Context, data cmdroute.CommandData) *api.InteractionResponseData {
+	var options struct {
+		Name string `discord:"name?"`
+	}
+
+	if err := data.Options.Unmarshal(&options); err != nil {
+		return errorResponse(err)
+	}
+
 	if !h.isAdmin(data.Event.Member) {
 		return &api.InteractionResponseData{
-			Content:         option.NewNullableString("You are not allowed to start the queue."),
+			Content:         option.NewNullableString("You are not allowed to switch profiles."),
 			Flags:           discord.EphemeralMessage,
 			AllowedMentions: &api.AllowedMentions{},
 		}
 	}
 
-	message := "Queue playback started."
-	if dequeueEnabled.Swap(true) {
-		message = "Queue playback already started."
+	if h.launcher == nil || len(h.launcher.Profiles()) == 0 {
+		return &api.InteractionResponseData{
+			Content:         option.NewNullableString("No profiles are configured."),
+			Flags:           discord.EphemeralMessage,
+			AllowedMentions: &api.AllowedMentions{},
+		}
 	}
-	return &api.InteractionResponseData{
-		Content:         option.NewNullableString(message),
-		Flags:           discord.EphemeralMessage,
-		AllowedMentions: &api.AllowedMentions{},
+
+	profiles := strings.Join(h.launcher.Profiles(), ", ")
+	if options.Name == "" {
+		current := h.launcher.Profile()
+		if current == "" {
+			current = "none"
+		}
+		return &api.InteractionResponseData{
+			Content:         option.NewNullableString(fmt.Sprintf("Current profile: %s\nAvailable profiles: %s", current, profiles)),
+			Flags:           discord.EphemeralMessage,
+			AllowedMentions: &api.AllowedMentions{},
+		}
 	}
-}
 
-func (h *queueCommandHandler) cmdStop(_ context.Context, data cmdroute.CommandData) *api.InteractionResponseData {
-	if !h.isAdmin(data.Event.Member) {
+	if err := h.launcher.SetProfile(options.Name); errors.Is(err, errUnknownProfile) {
 		return &api.InteractionResponseData{
-			Content:         option.NewNullableString("You are not allowed to stop the queue."),
+			Content:         option.NewNullableString(fmt.Sprintf("Unknown profile. Available profiles: %s", profiles)),
 			Flags:           discord.EphemeralMessage,
 			AllowedMentions: &api.AllowedMentions{},
 		}
 	}
 
-	message := "Queue playback stopped."
-	if !dequeueEnabled.Swap(false) {
-		message = "Queue playback already stopped."
+	message := fmt.Sprintf("Switched to the %s profile, restarting the player.", options.Name)
+	if err := h.mpv.Restart(ctx); errors.Is(err, errMPVNotRunning) {
+		message = fmt.Sprintf("Switched to the %s profile, which is used once the player has restarted.", options.Name)
+	} else if err != nil {
+		slog.ErrorContext(ctx, "Cannot restart mpv", slog.String("err", err.Error()))
+		return errorResponse(err)
 	}
 	return &api.InteractionResponseData{
 		Content:         option.NewNullableString(message),
+		AllowedMentions: &api.AllowedMentions{},
+	}
+}
+
+// playLength returns how long a song is expected to play, which is its
+// trimmed length at its tempo capped to the maximum play time unless it
+// is exempt.
+func (h *queueCommandHandler) playLength(song queue.QueuedSong) time.Duration {
+	length := song.Adjustments.PlayTime(song.Length())
+	if h.maxPlayTime > 0 && !song.Unlimited {
+		length = min(length, h.maxPlayTime)
+	}
+	return length
+}
+
+// predictStarts predicts when the turns of the first n queued songs
+// begin, from how long the last songs took.
+func (h *queueCommandHandler) predictStarts(tx *queue.QueueTx, n int) ([]time.Time, error) {
+	now := time.Now()
+	eta, err := newETAEstimator(tx, now, h.playbackTime, h.playLength)
+	if err != nil {
+		return nil, err
+	}
+	starts, _, err := eta.starts(tx, now, n)
+	return starts, err
+}
+
+// listField describes a queued song in the list, along with when it is
+// expected to start.
+func (h *queueCommandHandler) listField(position int, song queue.QueuedSong, start time.Time) discord.EmbedField {
+	return discord.EmbedField{
+		Name:  fmt.Sprintf("%d. %s", position, song.Title),
+		Value: fmt.Sprintf("ID: %s | Queued by <@%s> | ETA <t:%d:t>%s", song.Slug, song.UserID, start.Unix(), h.cacheStatus(song)),
+	}
+}
+
+// playbackStatusResponse responds with the given message followed by
+// the playback state reported by mpv.
+func (h *queueCommandHandler) playbackStatusResponse(ctx context.Context, message string) *api.InteractionResponseData {
+	status, err := h.player.Status(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "Cannot get playback status", slog.String("err", err.Error()))
+		return errorResponse(err)
+	}
+
+	return &api.InteractionResponseData{
+		Content:         option.NewNullableString(message + "\n" + status),
 		Flags:           discord.EphemeralMessage,
 		AllowedMentions: &api.AllowedMentions{},
 	}
@@ -189,13 +976,38 @@ func (h *queueCommandHandler) cmdStop(_ context.Context, data cmdroute.CommandDa
 
 func (h *queueCommandHandler) cmdEnqueue(ctx context.Context, data cmdroute.CommandData) *api.InteractionResponseData {
 	var options struct {
-		URL string `discord:"url"`
+		URL            string   `discord:"url"`
+		Language       string   `discord:"language?"`
+		Start          string   `discord:"start?"`
+		End            string   `discord:"end?"`
+		AudioOnly      bool     `discord:"audio_only?"`
+		Pitch          *int     `discord:"pitch"`
+		Tempo          *float64 `discord:"tempo"`
+		VocalReduction *bool    `discord:"vocal_reduction"`
 	}
 
 	if err := data.Options.Unmarshal(&options); err != nil {
 		return errorResponse(err)
 	}
 
+	var adjustments queue.Adjustments
+	values := adjustmentValues{options.Pitch, options.Tempo, options.VocalReduction}
+	if !values.apply(&adjustments) {
+		return &api.InteractionResponseData{
+			Content:         option.NewNullableString("Invalid adjustments."),
+			Flags:           discord.EphemeralMessage,
+			AllowedMentions: &api.AllowedMentions{},
+		}
+	}
+
+	if options.Language != "" && !validLanguage(options.Language) {
+		return &api.InteractionResponseData{
+			Content:         option.NewNullableString("Invalid language."),
+			Flags:           discord.EphemeralMessage,
+			AllowedMentions: &api.AllowedMentions{},
+		}
+	}
+
 	u, err := url.Parse(options.URL)
 	if err != nil {
 		return &api.InteractionResponseData{
@@ -205,6 +1017,21 @@ func (h *queueCommandHandler) cmdEnqueue(ctx context.Context, data cmdroute.Comm
 		}
 	}
 
+	start, end := urlTimestamps(u)
+	if options.Start != "" {
+		start, err = parseTimestamp(options.Start)
+	}
+	if err == nil && options.End != "" {
+		end, err = parseTimestamp(options.End)
+	}
+	if err != nil || (end > 0 && end <= start) {
+		return &api.InteractionResponseData{
+			Content:         option.NewNullableString("Invalid start or end time."),
+			Flags:           discord.EphemeralMessage,
+			AllowedMentions: &api.AllowedMentions{},
+		}
+	}
+
 	video, err := getVideoInfo(ctx, u)
 	if err != nil {
 		return errorResponse(err)
@@ -216,6 +1043,26 @@ func (h *queueCommandHandler) cmdEnqueue(ctx context.Context, data cmdroute.Comm
 		SongURL:      video.URL,
 		ThumbnailURL: video.Thumbnail,
 		Duration:     video.Duration,
+		Start:        start,
+		End:          end,
+		Adjustments:  adjustments,
+		AudioOnly:    options.AudioOnly,
+	}
+	if s.Duration > 0 && s.Start >= s.Duration {
+		return &api.InteractionResponseData{
+			Content:         option.NewNullableString("The start time is past the end of the song."),
+			Flags:           discord.EphemeralMessage,
+			AllowedMentions: &api.AllowedMentions{},
+		}
+	}
+
+	subtitlesMissing := false
+	if h.subtitles != nil {
+		s.Subtitles, s.SubtitleLanguage, err = h.subtitles.find(ctx, video, options.Language)
+		if err != nil && !errors.Is(err, errNoSubtitles) {
+			slog.WarnContext(ctx, "Unable to find subtitles", slog.String("url", video.URL), slog.String("err", err.Error()))
+		}
+		subtitlesMissing = err != nil && options.Language != ""
 	}
 
 	tx := h.q.BeginTxn(true)
@@ -237,20 +1084,15 @@ func (h *queueCommandHandler) cmdEnqueue(ctx context.Context, data cmdroute.Comm
 		adminPass = true
 	}
 
-	queueDuration := time.Duration(0)
-
-	lastSong, err := tx.LastDequeued()
-	if err != nil && !errors.Is(err, queue.ErrSongNotFound) {
-		slog.ErrorContext(ctx, "Unable to get last dequeue", slog.String("err", err.Error()))
-	} else if err == nil {
-		queueDuration += lastSong.Duration - time.Since(lastSong.DequeuedAt)
+	now := time.Now()
+	eta, err := newETAEstimator(tx, now, h.playbackTime, h.playLength)
+	if err != nil {
+		slog.ErrorContext(ctx, "Cannot estimate play times", slog.String("err", err.Error()))
+		return errorResponse(err)
 	}
-
-	err = tx.IterateFromHead(func(song queue.QueuedSong) bool {
-		queueDuration += song.Duration
-		return true
-	})
+	_, playTime, err := eta.starts(tx, now, 0)
 	if err != nil {
+		slog.ErrorContext(ctx, "Cannot estimate play time", slog.String("err", err.Error()))
 		return errorResponse(err)
 	}
 
@@ -259,9 +1101,12 @@ func (h *queueCommandHandler) cmdEnqueue(ctx context.Context, data cmdroute.Comm
 		return errorResponse(err)
 	}
 
-	queued, err := tx.GetByID(queuedID)
+	// The prediction is kept to compare it with when the song starts.
+	queued, err := tx.UpdateTimes(queuedID, func(times *queue.PlayTimes) {
+		times.Predicted = playTime
+	})
 	if err != nil {
-		slog.ErrorContext(ctx, "Cannot find queued song", slog.String("err", err.Error()))
+		slog.ErrorContext(ctx, "Cannot record predicted play time", slog.String("err", err.Error()))
 		return errorResponse(err)
 	}
 
@@ -273,8 +1118,6 @@ func (h *queueCommandHandler) cmdEnqueue(ctx context.Context, data cmdroute.Comm
 
 	playTimeString := "Next"
 	if queuePosition > 1 {
-		queueDuration += (h.playbackTime) * time.Duration(queuePosition-1)
-		playTime := time.Now().Add(queueDuration)
 		playTimeString = fmt.Sprintf("<t:%d:t>", playTime.Unix())
 	}
 
@@ -282,6 +1125,9 @@ func (h *queueCommandHandler) cmdEnqueue(ctx context.Context, data cmdroute.Comm
 		slog.ErrorContext(ctx, "Cannot commit transaction", slog.String("err", err.Error()))
 		return errorResponse(err)
 	}
+	if h.cache != nil {
+		h.cache.Wake()
+	}
 
 	embed := discord.NewEmbed()
 	embed.Title = "Song Enqueued"
@@ -301,6 +1147,40 @@ func (h *queueCommandHandler) cmdEnqueue(ctx context.Context, data cmdroute.Comm
 		Value:  playTimeString,
 		Inline: true,
 	})
+	if s.IsTrimmed() {
+		embed.Fields = append(embed.Fields, discord.EmbedField{
+			Name:   "Plays",
+			Value:  formatTrim(s),
+			Inline: true,
+		})
+	}
+	if adjustments := formatAdjustments(s.Adjustments); adjustments != "" {
+		embed.Fields = append(embed.Fields, discord.EmbedField{
+			Name:   "Adjustments",
+			Value:  adjustments,
+			Inline: true,
+		})
+	}
+	if s.AudioOnly {
+		embed.Fields = append(embed.Fields, discord.EmbedField{
+			Name:   "Video",
+			Value:  "Audio only",
+			Inline: true,
+		})
+	}
+	if s.Subtitles != "" || subtitlesMissing {
+		lyrics := "Yes"
+		if subtitlesMissing {
+			lyrics = fmt.Sprintf("None found in %s", options.Language)
+		} else if s.SubtitleLanguage != "" {
+			lyrics = s.SubtitleLanguage
+		}
+		embed.Fields = append(embed.Fields, discord.EmbedField{
+			Name:   "Lyrics",
+			Value:  lyrics,
+			Inline: true,
+		})
+	}
 
 	return &api.InteractionResponseData{
 		Embeds:          &[]discord.Embed{*embed},
@@ -336,18 +1216,21 @@ func (h *queueCommandHandler) cmdList(ctx context.Context, data cmdroute.Command
 		return errorResponse(err)
 	}
 
+	starts, err := h.predictStarts(tx, len(songs))
+	if err != nil {
+		slog.ErrorContext(ctx, "Cannot estimate play times", slog.String("err", err.Error()))
+		return errorResponse(err)
+	}
+
 	for i, song := range songs {
-		embed.Fields = append(embed.Fields, discord.EmbedField{
-			Name:  fmt.Sprintf("%d. %s", i+1, song.Title),
-			Value: fmt.Sprintf("ID: %s | Queued by <@%s>", song.Slug, song.UserID),
-		})
+		embed.Fields = append(embed.Fields, h.listField(i+1, song, starts[i]))
 	}
 
 	buttons := []discord.InteractiveComponent{
 		&discord.ButtonComponent{
 			Label:    "Refresh",
 			Style:    discord.SecondaryButtonStyle(),
-			CustomID: discord.ComponentID(fmt.Sprintf("list_page:0:%d", time.Now().UnixMilli())),
+			CustomID: h.listPageID(0),
 		},
 	}
 
@@ -361,7 +1244,7 @@ func (h *queueCommandHandler) cmdList(ctx context.Context, data cmdroute.Command
 		buttons = append(buttons, &discord.ButtonComponent{
 			Label:    "Next",
 			Style:    discord.PrimaryButtonStyle(),
-			CustomID: discord.ComponentID(fmt.Sprintf("list_page:1:%d", time.Now().UnixMilli())),
+			CustomID: h.listPageID(1),
 		})
 	}
 
@@ -451,6 +1334,10 @@ func (h *queueCommandHandler) cmdSwap(ctx context.Context, data cmdroute.Command
 	if err != nil {
 		return errorResponse(err)
 	}
+	start, end := urlTimestamps(u)
+	if video.Duration > 0 && start >= video.Duration {
+		start, end = 0, 0
+	}
 
 	tx := h.q.BeginTxn(true)
 	defer tx.Discard()
@@ -479,12 +1366,30 @@ func (h *queueCommandHandler) cmdSwap(ctx context.Context, data cmdroute.Command
 		}
 	}
 
+	// Subtitles are looked up again for the new video, in the language
+	// chosen when the song was enqueued.
+	var subtitles, subtitleLanguage string
+	if h.subtitles != nil {
+		subtitles, subtitleLanguage, err = h.subtitles.find(ctx, video, song.SubtitleLanguage)
+		if err != nil && !errors.Is(err, errNoSubtitles) {
+			slog.WarnContext(ctx, "Unable to find subtitles", slog.String("url", video.URL), slog.String("err", err.Error()))
+		}
+	}
+
 	err = tx.Update(song.ID, queue.NewSong{
-		UserID:       song.UserID,
-		Title:        video.Title,
-		SongURL:      video.URL,
-		ThumbnailURL: video.Thumbnail,
-		Duration:     video.Duration,
+		UserID:           song.UserID,
+		Title:            video.Title,
+		SongURL:          video.URL,
+		ThumbnailURL:     video.Thumbnail,
+		Duration:         video.Duration,
+		Start:            start,
+		End:              end,
+		Subtitles:        subtitles,
+		SubtitleLanguage: subtitleLanguage,
+		// Playing without video and in another key or tempo are choices
+		// of the singer, not the song.
+		AudioOnly:   song.AudioOnly,
+		Adjustments: song.Adjustments,
 	})
 	if err != nil {
 		slog.ErrorContext(ctx, "Cannot update song by slug", slog.String("err", err.Error()))
@@ -576,6 +1481,24 @@ func (h *queueCommandHandler) cmdMove(ctx context.Context, data cmdroute.Command
 	}
 }
 
+// cacheStatus returns the download state of a song shown in the queue
+// list, if songs are being downloaded.
+func (h *queueCommandHandler) cacheStatus(song queue.QueuedSong) string {
+	if h.cache == nil {
+		return ""
+	}
+	switch state, reason := h.cache.state(song.SongURL); state {
+	case cacheDownloading:
+		return " | Downloading"
+	case cacheReady:
+		return " | Downloaded"
+	case cacheFailed:
+		return " | :warning: Download failed: " + reason
+	default:
+		return ""
+	}
+}
+
 func (h *queueCommandHandler) isAdmin(member *discord.Member) bool {
 	return slices.ContainsFunc(h.adminRoles, func(role discord.RoleID) bool {
 		return slices.Contains(member.RoleIDs, discord.RoleID(role))
@@ -590,6 +1513,26 @@ func errorResponse(err error) *api.InteractionResponseData {
 	}
 }
 
+// HandleInteraction handles the commands of the room and the components of
+// the messages sent for it.
+func (h *queueCommandHandler) HandleInteraction(ev *discord.InteractionEvent) *api.InteractionResponse {
+	if ev.Data.InteractionType() == discord.ComponentInteractionType {
+		return h.handleComponentInteraction(ev)
+	}
+	return h.Router.HandleInteraction(ev)
+}
+
+// listPageID returns the custom ID of a button showing a page of the
+// queue. The room is part of the ID, as the list can be shown outside of
+// the channel of the room.
+func (h *queueCommandHandler) listPageID(page int) discord.ComponentID {
+	id := fmt.Sprintf("list_page:%d:%d", page, time.Now().UnixMilli())
+	if h.room != "" {
+		id += ":" + h.room
+	}
+	return discord.ComponentID(id)
+}
+
 func (h *queueCommandHandler) handleComponentInteraction(ev *discord.InteractionEvent) *api.InteractionResponse {
 	if ev.Data.InteractionType() != discord.ComponentInteractionType {
 		return nil
@@ -606,11 +1549,151 @@ func (h *queueCommandHandler) handleComponentInteraction(ev *discord.Interaction
 	case "list_page":
 		pageNumber, _ := strconv.Atoi(parts[1])
 		return h.handleListPage(context.Background(), pageNumber)
+	case "requeue_failed":
+		songID, err := strconv.Atoi(parts[1])
+		if err != nil || ev.Member == nil {
+			return nil
+		}
+		return h.handleRequeueFailed(context.Background(), ev.Member, songID)
+	case "ready", "push_back":
+		songID, err := strconv.Atoi(parts[1])
+		if err != nil || ev.Member == nil {
+			return nil
+		}
+		return h.handleReadyCheck(context.Background(), ev.Member, songID, parts[0] == "push_back")
 	default:
 		return nil
 	}
 }
 
+// handleReadyCheck confirms that the singer of the current song is ready,
+// or moves the song back in the queue, when its requester or an admin
+// presses the buttons of the heads up.
+func (h *queueCommandHandler) handleReadyCheck(ctx context.Context, member *discord.Member, songID int, pushBack bool) *api.InteractionResponse {
+	respond := func(data *api.InteractionResponseData) *api.InteractionResponse {
+		return &api.InteractionResponse{
+			Type: api.MessageInteractionWithSource,
+			Data: data,
+		}
+	}
+
+	current := h.player.Current()
+	if current == nil || current.ID != songID {
+		return respond(&api.InteractionResponseData{
+			Content:         option.NewNullableString("This song is no longer up next."),
+			Flags:           discord.EphemeralMessage,
+			AllowedMentions: &api.AllowedMentions{},
+		})
+	}
+	if member.User.ID.String() != current.UserID && !h.isAdmin(member) {
+		return respond(&api.InteractionResponseData{
+			Content:         option.NewNullableString("Only the singer can answer for this song."),
+			Flags:           discord.EphemeralMessage,
+			AllowedMentions: &api.AllowedMentions{},
+		})
+	}
+
+	if !pushBack {
+		if !h.player.Ready(songID) {
+			return respond(&api.InteractionResponseData{
+				Content:         option.NewNullableString("This song is no longer up next."),
+				Flags:           discord.EphemeralMessage,
+				AllowedMentions: &api.AllowedMentions{},
+			})
+		}
+		return respond(&api.InteractionResponseData{
+			Content:         option.NewNullableString("Great, get ready to sing!"),
+			Flags:           discord.EphemeralMessage,
+			AllowedMentions: &api.AllowedMentions{},
+		})
+	}
+
+	requeued, err := h.player.PushBack(songID)
+	if errors.Is(err, errNotCurrentSong) {
+		return respond(&api.InteractionResponseData{
+			Content:         option.NewNullableString("This song is no longer up next."),
+			Flags:           discord.EphemeralMessage,
+			AllowedMentions: &api.AllowedMentions{},
+		})
+	} else if err != nil {
+		slog.ErrorContext(ctx, "Cannot push back song", slog.String("err", err.Error()))
+		return respond(errorResponse(err))
+	}
+	return respond(&api.InteractionResponseData{
+		Content:         option.NewNullableString(fmt.Sprintf("%s was moved back in the queue. ID: %s", requeued.Title, requeued.Slug)),
+		AllowedMentions: &api.AllowedMentions{},
+	})
+}
+
+// handleRequeueFailed puts a song that could not be played back in the
+// queue when its requester or an admin presses the re-queue button.
+func (h *queueCommandHandler) handleRequeueFailed(ctx context.Context, member *discord.Member, songID int) *api.InteractionResponse {
+	respond := func(data *api.InteractionResponseData) *api.InteractionResponse {
+		return &api.InteractionResponse{
+			Type: api.MessageInteractionWithSource,
+			Data: data,
+		}
+	}
+
+	tx := h.q.BeginTxn(true)
+	defer tx.Discard()
+
+	song, err := tx.GetByID(songID)
+	if errors.Is(err, queue.ErrSongNotFound) {
+		return respond(&api.InteractionResponseData{
+			Content:         option.NewNullableString("Song not found."),
+			Flags:           discord.EphemeralMessage,
+			AllowedMentions: &api.AllowedMentions{},
+		})
+	} else if err != nil {
+		slog.ErrorContext(ctx, "Cannot find failed song", slog.String("err", err.Error()))
+		return respond(errorResponse(err))
+	}
+
+	if member.User.ID.String() != song.UserID && !h.isAdmin(member) {
+		return respond(&api.InteractionResponseData{
+			Content:         option.NewNullableString("You are not allowed to requeue this song."),
+			Flags:           discord.EphemeralMessage,
+			AllowedMentions: &api.AllowedMentions{},
+		})
+	}
+
+	userStats, err := tx.GetUserStats(song.UserID)
+	if err != nil && err != queue.ErrUserStatsNotFound {
+		return respond(errorResponse(err))
+	}
+	if int(userStats.QueuedCount) >= h.userLimit && !h.isAdmin(member) {
+		return respond(&api.InteractionResponseData{
+			Content:         option.NewNullableString("You have reached the limit of songs you can enqueue."),
+			Flags:           discord.EphemeralMessage,
+			AllowedMentions: &api.AllowedMentions{},
+		})
+	}
+
+	requeued, err := tx.RequeueFailed(songID)
+	if errors.Is(err, queue.ErrSongNotFailed) {
+		return respond(&api.InteractionResponseData{
+			Content:         option.NewNullableString("This song has already been requeued."),
+			Flags:           discord.EphemeralMessage,
+			AllowedMentions: &api.AllowedMentions{},
+		})
+	} else if err != nil {
+		slog.ErrorContext(ctx, "Cannot requeue failed song", slog.String("err", err.Error()))
+		return respond(errorResponse(err))
+	}
+
+	if err := tx.Commit(); err != nil {
+		slog.ErrorContext(ctx, "Cannot commit transaction", slog.String("err", err.Error()))
+		return respond(errorResponse(err))
+	}
+
+	return respond(&api.InteractionResponseData{
+		Content:         option.NewNullableString(fmt.Sprintf("Requeued %s (ID: %s).", requeued.Title, requeued.Slug)),
+		Flags:           discord.EphemeralMessage,
+		AllowedMentions: &api.AllowedMentions{},
+	})
+}
+
 func (h *queueCommandHandler) handleListPage(ctx context.Context, pageNumber int) *api.InteractionResponse {
 	embed := discord.NewEmbed()
 	embed.Title = "Current Queue"
@@ -638,7 +1721,7 @@ func (h *queueCommandHandler) handleListPage(ctx context.Context, pageNumber int
 						&discord.ButtonComponent{
 							Label:    "Refresh",
 							Style:    discord.SecondaryButtonStyle(),
-							CustomID: discord.ComponentID(fmt.Sprintf("list_page:0:%d", time.Now().UnixMilli())),
+							CustomID: h.listPageID(0),
 						},
 					},
 				},
@@ -680,11 +1763,17 @@ func (h *queueCommandHandler) handleListPage(ctx context.Context, pageNumber int
 		}
 	}
 
+	starts, err := h.predictStarts(tx, start+len(songs))
+	if err != nil {
+		slog.ErrorContext(ctx, "Cannot estimate play times", slog.String("err", err.Error()))
+		return &api.InteractionResponse{
+			Type: api.UpdateMessage,
+			Data: errorResponse(err),
+		}
+	}
+
 	for i, song := range songs {
-		embed.Fields = append(embed.Fields, discord.EmbedField{
-			Name:  fmt.Sprintf("%d. %s", start+i+1, song.Title),
-			Value: fmt.Sprintf("ID: %s | Queued by <@%s>", song.Slug, song.UserID),
-		})
+		embed.Fields = append(embed.Fields, h.listField(start+i+1, song, starts[start+i]))
 	}
 
 	buttons := make([]discord.InteractiveComponent, 0, 3)
@@ -692,20 +1781,20 @@ func (h *queueCommandHandler) handleListPage(ctx context.Context, pageNumber int
 	buttons = append(buttons, &discord.ButtonComponent{
 		Label:    "Previous",
 		Style:    discord.PrimaryButtonStyle(),
-		CustomID: discord.ComponentID(fmt.Sprintf("list_page:%d:%d", pageNumber-1, time.Now().UnixMilli())),
+		CustomID: h.listPageID(pageNumber - 1),
 		Disabled: pageNumber == 0,
 	})
 
 	buttons = append(buttons, &discord.ButtonComponent{
 		Label:    "Refresh",
 		Style:    discord.SecondaryButtonStyle(),
-		CustomID: discord.ComponentID(fmt.Sprintf("list_page:%d:%d", pageNumber, time.Now().UnixMilli())),
+		CustomID: h.listPageID(pageNumber),
 	})
 
 	buttons = append(buttons, &discord.ButtonComponent{
 		Label:    "Next",
 		Style:    discord.PrimaryButtonStyle(),
-		CustomID: discord.ComponentID(fmt.Sprintf("list_page:%d:%d", pageNumber+1, time.Now().UnixMilli())),
+		CustomID: h.listPageID(pageNumber + 1),
 		Disabled: end == count,
 	})
 
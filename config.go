@@ -2,11 +2,15 @@ package main
 
 import (
 	"fmt"
+	"maps"
+	"path/filepath"
 	"reflect"
+	"slices"
 	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/diamondburned/arikawa/v3/discord"
+	"github.com/xoltia/mpv"
 )
 
 type discordConfig struct {
@@ -17,18 +21,204 @@ type discordConfig struct {
 }
 
 type binaryConfig struct {
-	YTDLPath string `toml:"ytdlp"`
-	MPVPath  string `toml:"mpv"`
+	YTDLPath   string `toml:"ytdlp"`
+	MPVPath    string `toml:"mpv"`
+	FFmpegPath string `toml:"ffmpeg"`
+}
+
+type cacheConfig struct {
+	Dir       string `toml:"dir"`
+	MaxSizeMB int64  `toml:"max_size_mb"`
+	// Prefetch is how many upcoming songs are downloaded ahead of their
+	// turn. Zero disables prefetching. Defaults to 3.
+	Prefetch int `toml:"prefetch"`
+	// Normalize measures the loudness of downloaded songs with ffmpeg
+	// and adjusts their volume towards TargetLoudness (LUFS).
+	Normalize      bool    `toml:"normalize"`
+	TargetLoudness float64 `toml:"target_loudness"`
+}
+
+type subtitleConfig struct {
+	// Dir is where downloaded subtitles are stored.
+	Dir string `toml:"dir"`
+	// LyricsDir is searched for LRC files named after the video ID or
+	// title of a song.
+	LyricsDir string `toml:"lyrics_dir"`
+	// Language is the subtitle language used when none is given.
+	Language string `toml:"language"`
+}
+
+type mpvConfig struct {
+	// Args are passed to mpv before the arguments of the profile.
+	Args []string `toml:"args"`
+	// ConfigDir replaces the directory mpv loads its configuration from.
+	ConfigDir string `toml:"config_dir"`
+	// Profile is the profile mpv is started with, which admins can
+	// switch at runtime.
+	Profile  string                `toml:"profile"`
+	Profiles map[string]mpvProfile `toml:"profiles"`
+	// ConnectRetries and ConnectRetryDelay control how often connecting
+	// to a new mpv process is attempted, the delay doubling each time.
+	ConnectRetries    int           `toml:"connect_retries"`
+	ConnectRetryDelay time.Duration `toml:"connect_retry_delay"`
+	DialTimeout       time.Duration `toml:"dial_timeout"`
+	// SocketPath is the IPC socket of mpv, which must differ between
+	// rooms. mpv's default is used when it is empty.
+	SocketPath string `toml:"socket_path"`
+}
+
+// inherit fills the settings that are not set from parent.
+func (c mpvConfig) inherit(parent mpvConfig) mpvConfig {
+	if c.Args == nil {
+		c.Args = parent.Args
+	}
+	if c.ConfigDir == "" {
+		c.ConfigDir = parent.ConfigDir
+	}
+	if c.Profiles == nil {
+		c.Profiles = parent.Profiles
+		if c.Profile == "" {
+			c.Profile = parent.Profile
+		}
+	}
+	if c.ConnectRetries == 0 {
+		c.ConnectRetries = parent.ConnectRetries
+	}
+	if c.ConnectRetryDelay == 0 {
+		c.ConnectRetryDelay = parent.ConnectRetryDelay
+	}
+	if c.DialTimeout == 0 {
+		c.DialTimeout = parent.DialTimeout
+	}
+	return c
+}
+
+// mpvProfile is a named set of mpv arguments, such as for a venue TV or
+// a stream.
+type mpvProfile struct {
+	Args []string `toml:"args"`
+}
+
+// ytdlConfig are the options of yt-dlp, used both when resolving and
+// downloading songs and by mpv when playing them.
+type ytdlConfig struct {
+	// Format is the format selection, such as
+	// "bestvideo[height<=720]+bestaudio/best".
+	Format string `toml:"format"`
+	// Cookies is the path of a cookies file in the Netscape format.
+	Cookies string `toml:"cookies"`
+	Proxy   string `toml:"proxy"`
+	// RateLimit is the maximum download rate, such as "2M".
+	RateLimit string `toml:"rate_limit"`
+	// Options are other options by their long name without dashes, with
+	// an empty value for options that take none.
+	Options map[string]string `toml:"options"`
+}
+
+type readyCheckConfig struct {
+	// Timeout is how long singers have to confirm they are ready after
+	// the heads up. Zero disables the ready check.
+	Timeout time.Duration `toml:"timeout"`
+	// PushBack is the number of positions a song is moved back when its
+	// singer does not show up or asks for it. Zero puts it back at the
+	// head of the queue. Defaults to 3.
+	PushBack int `toml:"push_back"`
+	// MaxNoShows is the number of times a singer can miss their turn
+	// before the song is removed. Zero never removes it. Defaults to 2.
+	MaxNoShows int `toml:"max_no_shows"`
+}
+
+// hotkeyConfig binds keys in the mpv window to player actions, using mpv
+// key names such as "Ctrl+n". Actions without a key are not bound.
+type hotkeyConfig struct {
+	// Skip skips the current song.
+	Skip string `toml:"skip"`
+	// Requeue puts the current song back at the head of the queue.
+	Requeue string `toml:"requeue"`
+	// Extend adds ExtendBy to the countdown.
+	Extend   string        `toml:"extend"`
+	ExtendBy time.Duration `toml:"extend_by"`
+	// Toggle starts or stops the queue.
+	Toggle string `toml:"toggle"`
+}
+
+// bindings returns the keys by action.
+func (c hotkeyConfig) bindings() map[hotkeyAction]string {
+	return map[hotkeyAction]string{
+		hotkeySkip:    c.Skip,
+		hotkeyRequeue: c.Requeue,
+		hotkeyExtend:  c.Extend,
+		hotkeyToggle:  c.Toggle,
+	}
+}
+
+// idleMusicConfig is the background music played while the queue is
+// empty or stopped.
+type idleMusicConfig struct {
+	// Dir is a directory of tracks, played in name order.
+	Dir string `toml:"dir"`
+	// URLs are tracks played after those in Dir.
+	URLs []string `toml:"urls"`
+	// Gain is added to the volume of the tracks, in dB, so that they stay
+	// in the background. Defaults to -12.
+	Gain float64 `toml:"gain"`
+}
+
+// announcementConfig speaks the singer and title of each song before it
+// starts, through a local text-to-speech command.
+type announcementConfig struct {
+	// Command is the text-to-speech command and its arguments, in which
+	// {output} is replaced by the path of the clip to write and {text} by
+	// what is said. The text is given on stdin if no argument has {text}.
+	// Songs are not announced if it is empty.
+	Command []string `toml:"command"`
+	// Dir is where generated clips are cached.
+	Dir string `toml:"dir"`
+}
+
+// roomConfig is a karaoke room, with its own queue, mpv process and
+// announcement channel. Admin roles and mpv settings that are not set
+// are taken from the top level.
+type roomConfig struct {
+	Channel    discord.Snowflake   `toml:"channel"`
+	AdminRoles []discord.Snowflake `toml:"admin_roles"`
+	QueuePath  string              `toml:"queue_path"`
+	MPV        mpvConfig           `toml:"mpv"`
 }
 
 type config struct {
-	QueuePath        string        `toml:"queue_path"`
-	UserLimit        int           `toml:"user_limit"`
-	PlaybackTime     time.Duration `toml:"auto_play_delay"`
-	DisablePing      bool          `toml:"disable_ping"`
-	StartImmediately bool          `toml:"start_immediately"`
-	Discord          discordConfig `toml:"discord"`
-	Binary           binaryConfig  `toml:"binary"`
+	QueuePath        string             `toml:"queue_path"`
+	UserLimit        int                `toml:"user_limit"`
+	PlaybackTime     time.Duration      `toml:"auto_play_delay"`
+	DisablePing      bool               `toml:"disable_ping"`
+	StartImmediately bool               `toml:"start_immediately"`
+	AutoResume       bool               `toml:"auto_resume"`
+	MaxPlayTime      time.Duration      `toml:"max_play_time"`
+	AudioOnly        bool               `toml:"audio_only"`
+	Discord          discordConfig      `toml:"discord"`
+	Binary           binaryConfig       `toml:"binary"`
+	Cache            cacheConfig        `toml:"cache"`
+	Subtitles        subtitleConfig     `toml:"subtitles"`
+	MPV              mpvConfig          `toml:"mpv"`
+	YTDL             ytdlConfig         `toml:"ytdl"`
+	ReadyCheck       readyCheckConfig   `toml:"ready_check"`
+	Hotkeys          hotkeyConfig       `toml:"hotkeys"`
+	IdleMusic        idleMusicConfig    `toml:"idle_music"`
+	Announcement     announcementConfig `toml:"announcement"`
+	// Rooms are played at the same time, each managed like a separate
+	// bot. Without rooms, the top level settings make up a single room.
+	Rooms map[string]roomConfig `toml:"rooms"`
+}
+
+// defaultConfig returns the defaults of settings for which zero is a valid
+// value, so they are set before the configuration file is decoded instead
+// of by applyDefaults.
+func defaultConfig() config {
+	return config{
+		Cache:      cacheConfig{Prefetch: 3},
+		ReadyCheck: readyCheckConfig{PushBack: 3, MaxNoShows: 2},
+		IdleMusic:  idleMusicConfig{Gain: -12},
+	}
 }
 
 func (c *config) applyDefaults() {
@@ -48,6 +238,76 @@ func (c *config) applyDefaults() {
 	if c.Binary.MPVPath == "" {
 		c.Binary.MPVPath = "mpv"
 	}
+
+	if c.Binary.FFmpegPath == "" {
+		c.Binary.FFmpegPath = "ffmpeg"
+	}
+
+	if c.Cache.MaxSizeMB == 0 {
+		c.Cache.MaxSizeMB = 2048
+	}
+	if c.Cache.TargetLoudness == 0 {
+		c.Cache.TargetLoudness = -16
+	}
+
+	if c.Subtitles.Dir == "" {
+		c.Subtitles.Dir = "subtitles"
+	}
+
+	if c.MPV.ConnectRetries == 0 {
+		c.MPV.ConnectRetries = 10
+	}
+	if c.MPV.ConnectRetryDelay == 0 {
+		c.MPV.ConnectRetryDelay = time.Second
+	}
+	if c.MPV.DialTimeout == 0 {
+		c.MPV.DialTimeout = 5 * time.Second
+	}
+
+	if c.Hotkeys.ExtendBy == 0 {
+		c.Hotkeys.ExtendBy = 30 * time.Second
+	}
+	if c.Announcement.Dir == "" {
+		c.Announcement.Dir = "announcements"
+	}
+
+	for name, room := range c.Rooms {
+		if room.QueuePath == "" {
+			room.QueuePath = filepath.Join(c.QueuePath, name)
+		}
+		if room.AdminRoles == nil {
+			room.AdminRoles = c.Discord.AdminRoles
+		}
+		room.MPV = room.MPV.inherit(c.MPV)
+		if room.MPV.SocketPath == "" {
+			room.MPV.SocketPath = mpv.DefaultSocketPath() + "-" + name
+		}
+		c.Rooms[name] = room
+	}
+}
+
+// namedRoom is a room along with its name, which is empty for the room
+// made up of the top level settings.
+type namedRoom struct {
+	name string
+	roomConfig
+}
+
+// rooms returns the configured rooms ordered by name.
+func (c *config) rooms() []namedRoom {
+	if len(c.Rooms) == 0 {
+		return []namedRoom{{roomConfig: roomConfig{
+			Channel:    c.Discord.Channel,
+			AdminRoles: c.Discord.AdminRoles,
+			QueuePath:  c.QueuePath,
+			MPV:        c.MPV,
+		}}}
+	}
+	rooms := make([]namedRoom, 0, len(c.Rooms))
+	for _, name := range slices.Sorted(maps.Keys(c.Rooms)) {
+		rooms = append(rooms, namedRoom{name, c.Rooms[name]})
+	}
+	return rooms
 }
 
 type validationErrors []error
@@ -76,6 +336,13 @@ func requireNotZeroValue(field string, value any) error {
 	return nil
 }
 
+func requireNotNegative(field string, value int) error {
+	if value < 0 {
+		return validationError{field, "must not be negative"}
+	}
+	return nil
+}
+
 func requireValidSnowflake(field string, value discord.Snowflake) error {
 	if !value.IsValid() {
 		return validationError{field, "invalid snowflake"}
@@ -83,6 +350,13 @@ func requireValidSnowflake(field string, value discord.Snowflake) error {
 	return nil
 }
 
+func requireChannel(field string, value discord.Snowflake) error {
+	if err := requireNotZeroValue(field, value); err != nil {
+		return err
+	}
+	return requireValidSnowflake(field, value)
+}
+
 func (c *config) validate() error {
 	errs := make(validationErrors, 0)
 	errs = append(errs, requireNotZeroValue("queue_path", c.QueuePath))
@@ -95,11 +369,8 @@ func (c *config) validate() error {
 		errs = append(errs, discordServerIDMissingErr)
 	}
 
-	discordChannelIDMissingErr := requireNotZeroValue("discord.channel", c.Discord.Channel)
-	if discordChannelIDMissingErr == nil {
-		errs = append(errs, requireValidSnowflake("discord.channel", c.Discord.Channel))
-	} else {
-		errs = append(errs, discordChannelIDMissingErr)
+	if len(c.Rooms) == 0 {
+		errs = append(errs, requireChannel("discord.channel", c.Discord.Channel))
 	}
 
 	errs = append(errs, requireNotZeroValue("binary.ytdlp", c.Binary.YTDLPath))
@@ -107,6 +378,36 @@ func (c *config) validate() error {
 	for i, role := range c.Discord.AdminRoles {
 		errs = append(errs, requireValidSnowflake(fmt.Sprintf("discord.admin_roles[%d]", i), role))
 	}
+	if _, ok := c.MPV.Profiles[c.MPV.Profile]; c.MPV.Profile != "" && !ok {
+		errs = append(errs, validationError{"mpv.profile", "profile not defined in mpv.profiles"})
+	}
+	errs = append(errs, requireNotNegative("cache.prefetch", c.Cache.Prefetch))
+	errs = append(errs, requireNotNegative("ready_check.push_back", c.ReadyCheck.PushBack))
+	errs = append(errs, requireNotNegative("ready_check.max_no_shows", c.ReadyCheck.MaxNoShows))
+
+	channels := make(map[discord.Snowflake]string)
+	sockets := make(map[string]string)
+	for _, room := range c.rooms() {
+		if room.name == "" {
+			continue
+		}
+		field := "rooms." + room.name
+		errs = append(errs, requireChannel(field+".channel", room.Channel))
+		if other, ok := channels[room.Channel]; ok {
+			errs = append(errs, validationError{field + ".channel", "same channel as room " + other})
+		}
+		channels[room.Channel] = room.name
+		if other, ok := sockets[room.MPV.SocketPath]; ok {
+			errs = append(errs, validationError{field + ".mpv.socket_path", "same socket as room " + other})
+		}
+		sockets[room.MPV.SocketPath] = room.name
+		for i, role := range room.AdminRoles {
+			errs = append(errs, requireValidSnowflake(fmt.Sprintf("%s.admin_roles[%d]", field, i), role))
+		}
+		if _, ok := room.MPV.Profiles[room.MPV.Profile]; room.MPV.Profile != "" && !ok {
+			errs = append(errs, validationError{field + ".mpv.profile", "profile not defined in mpv.profiles"})
+		}
+	}
 
 	var filtered validationErrors
 	for _, err := range errs {
@@ -123,6 +424,7 @@ func (c *config) validate() error {
 }
 
 func loadConfig(path string) (cfg config, err error) {
+	cfg = defaultConfig()
 	if _, err := toml.DecodeFile(path, &cfg); err != nil {
 		return cfg, err
 	}
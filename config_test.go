@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const testConfig = `
+[discord]
+token = "token"
+server = 175928847299117063
+channel = 175928847299117064
+`
+
+func TestLoadConfigDefaults(t *testing.T) {
+	cfg, err := loadConfig(writeConfig(t, testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Cache.Prefetch != 3 {
+		t.Errorf("expected 3 songs to be prefetched, got %d", cfg.Cache.Prefetch)
+	}
+	if cfg.IdleMusic.Gain != -12 {
+		t.Errorf("expected idle music gain of -12 dB, got %v", cfg.IdleMusic.Gain)
+	}
+	if cfg.ReadyCheck.PushBack != 3 || cfg.ReadyCheck.MaxNoShows != 2 {
+		t.Errorf("expected ready check defaults, got %+v", cfg.ReadyCheck)
+	}
+}
+
+func TestLoadConfigKeepsZero(t *testing.T) {
+	cfg, err := loadConfig(writeConfig(t, testConfig+`
+[cache]
+prefetch = 0
+
+[idle_music]
+gain = 0
+
+[ready_check]
+push_back = 0
+max_no_shows = 0
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ReadyCheck.PushBack != 0 || cfg.ReadyCheck.MaxNoShows != 0 {
+		t.Errorf("expected zero ready check settings to be kept, got %+v", cfg.ReadyCheck)
+	}
+	if cfg.Cache.Prefetch != 0 {
+		t.Errorf("expected prefetching to be disabled, got %d", cfg.Cache.Prefetch)
+	}
+	if cfg.IdleMusic.Gain != 0 {
+		t.Errorf("expected idle music gain of 0 dB to be kept, got %v", cfg.IdleMusic.Gain)
+	}
+}
+
+func TestLoadConfigRejectsNegative(t *testing.T) {
+	_, err := loadConfig(writeConfig(t, testConfig+`
+[ready_check]
+push_back = -1
+`))
+	if err == nil {
+		t.Error("expected negative push back to be rejected")
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/xoltia/mdk3/queue"
+)
+
+const (
+	// etaSamples is how many of the songs played last the time taken
+	// between songs is averaged over.
+	etaSamples = 10
+	// etaWindow is how long ago songs can have been played to be
+	// averaged over, so that the pace of an earlier night is not used.
+	etaWindow = 3 * time.Hour
+	// reportWindow is how long ago songs can have been played to be
+	// reported.
+	reportWindow = 12 * time.Hour
+)
+
+// etaEstimator predicts when the turns of queued songs begin. A turn is
+// the countdown to a song, the song itself and the changeover to the next
+// one, which on a real night take longer than the countdown alone.
+type etaEstimator struct {
+	playLength func(queue.QueuedSong) time.Duration
+	// overhead is how much longer a turn takes than its song.
+	overhead time.Duration
+}
+
+// newETAEstimator averages how much longer than the time their songs were
+// played the turns of the last songs played within etaWindow took, so that
+// skipped songs do not count as time between songs. The fallback overhead
+// is used until a song has been played.
+func newETAEstimator(tx *queue.QueueTx, now time.Time, fallback time.Duration, playLength func(queue.QueuedSong) time.Duration) (etaEstimator, error) {
+	e := etaEstimator{playLength: playLength, overhead: fallback}
+	var total time.Duration
+	samples := 0
+	cutoff := now.Add(-etaWindow)
+	err := tx.IterateBackwardsFromHead(func(song queue.QueuedSong) bool {
+		if song.DequeuedAt.Before(cutoff) || samples == etaSamples {
+			return false
+		}
+		times := song.Times
+		// Songs that never started, such as those of singers who did not
+		// show up, are requeued and counted again later.
+		if times.Countdown.IsZero() || times.Started.IsZero() || times.Ended.IsZero() {
+			return true
+		}
+		// Songs played for longer than their length were paused, which
+		// is time between songs too.
+		played := min(times.Ended.Sub(times.Started), playLength(song))
+		total += times.Ended.Sub(times.Countdown) - played
+		samples++
+		return true
+	})
+	if samples > 0 {
+		e.overhead = total / time.Duration(samples)
+	}
+	return e, err
+}
+
+// turn estimates how long the turn of a song takes.
+func (e etaEstimator) turn(song queue.QueuedSong) time.Duration {
+	return max(e.playLength(song)+e.overhead, 0)
+}
+
+// starts predicts when the turns of the first n queued songs begin, and
+// when the turn of a song added to the end of the queue would.
+func (e etaEstimator) starts(tx *queue.QueueTx, now time.Time, n int) (starts []time.Time, end time.Time, err error) {
+	end = now
+	last, err := tx.LastDequeued()
+	if err != nil && !errors.Is(err, queue.ErrSongNotFound) {
+		return nil, end, err
+	}
+	if err == nil && last.Times.Ended.IsZero() {
+		begin := last.Times.Countdown
+		if begin.IsZero() {
+			begin = last.DequeuedAt
+		}
+		end = end.Add(max(e.turn(last)-now.Sub(begin), 0))
+	}
+
+	err = tx.IterateFromHead(func(song queue.QueuedSong) bool {
+		if len(starts) < n {
+			starts = append(starts, end)
+		}
+		end = end.Add(e.turn(song))
+		return true
+	})
+	return starts, end, err
+}
+
+// etaReport compares when the songs played within reportWindow were
+// predicted to start with when they did, most recent first.
+type etaReport struct {
+	songs []queue.QueuedSong
+	// late is how much later than predicted songs started on average,
+	// and off how far from the predictions they started on average.
+	late time.Duration
+	off  time.Duration
+}
+
+func newETAReport(tx *queue.QueueTx, now time.Time) (report etaReport, err error) {
+	var late, off time.Duration
+	cutoff := now.Add(-reportWindow)
+	err = tx.IterateBackwardsFromHead(func(song queue.QueuedSong) bool {
+		if song.DequeuedAt.Before(cutoff) {
+			return false
+		}
+		if song.Times.Predicted.IsZero() || song.Times.Countdown.IsZero() {
+			return true
+		}
+		d := song.Times.Countdown.Sub(song.Times.Predicted)
+		late += d
+		off += max(d, -d)
+		report.songs = append(report.songs, song)
+		return true
+	})
+	if n := time.Duration(len(report.songs)); n > 0 {
+		report.late = late / n
+		report.off = off / n
+	}
+	return
+}
+
+// formatOffset formats how far a time was from its prediction.
+func formatOffset(d time.Duration) string {
+	d = d.Round(time.Second)
+	if d >= 0 {
+		return fmt.Sprintf("+%s", d)
+	}
+	return d.String()
+}
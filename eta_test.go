@@ -0,0 +1,118 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xoltia/mdk3/queue"
+)
+
+func TestETAEstimator(t *testing.T) {
+	q, err := queue.OpenQueue(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	tx := q.BeginTxn(true)
+	defer tx.Discard()
+
+	length := func(song queue.QueuedSong) time.Duration { return song.Duration }
+	now := time.Now()
+	fresh, err := newETAEstimator(tx, now, 30*time.Second, length)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fresh.overhead != 30*time.Second {
+		t.Errorf("expected fallback overhead without history, got %v", fresh.overhead)
+	}
+
+	// Two songs of 3 minutes took 4 and 5 minutes, one was skipped after a
+	// minute and one was a no-show.
+	turns := []struct{ turn, played time.Duration }{
+		{4 * time.Minute, 3*time.Minute + 30*time.Second},
+		{5 * time.Minute, 4*time.Minute + 30*time.Second},
+		{90 * time.Second, time.Minute},
+		{},
+	}
+	for i, turn := range turns {
+		if _, err := tx.Enqueue(queue.NewSong{UserID: "1", Title: "Played", Duration: 3 * time.Minute}); err != nil {
+			t.Fatal(err)
+		}
+		song, err := tx.Dequeue()
+		if err != nil {
+			t.Fatal(err)
+		}
+		countdown := now.Add(time.Duration(i-len(turns)) * 10 * time.Minute)
+		_, err = tx.UpdateTimes(song.ID, func(times *queue.PlayTimes) {
+			times.Predicted = countdown.Add(-time.Minute)
+			times.Countdown = countdown
+			if turn.played > 0 {
+				times.Started = countdown.Add(turn.turn - turn.played)
+			}
+			times.Ended = countdown.Add(turn.turn)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, d := range []time.Duration{2 * time.Minute, 4 * time.Minute} {
+		if _, err := tx.Enqueue(queue.NewSong{UserID: "2", Title: "Queued", Duration: d}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	eta, err := newETAEstimator(tx, now, 30*time.Second, length)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := 70 * time.Second; eta.overhead != expected {
+		t.Errorf("expected overhead %v, got %v", expected, eta.overhead)
+	}
+
+	starts, end, err := eta.starts(tx, now, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(starts) != 1 || !starts[0].Equal(now) {
+		t.Errorf("expected first song to start now, got %v", starts)
+	}
+	if expected := now.Add(8*time.Minute + 20*time.Second); !end.Equal(expected) {
+		t.Errorf("expected queue to end at %v, got %v", expected, end)
+	}
+
+	report, err := newETAReport(tx, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.songs) != 4 || report.late != time.Minute || report.off != time.Minute {
+		t.Errorf("expected 4 songs a minute late, got %d songs %v late and %v off", len(report.songs), report.late, report.off)
+	}
+}
+
+func TestFormatOffset(t *testing.T) {
+	if s := formatOffset(90*time.Second + 400*time.Millisecond); s != "+1m30s" {
+		t.Errorf("expected +1m30s, got %s", s)
+	}
+	if s := formatOffset(-45 * time.Second); s != "-45s" {
+		t.Errorf("expected -45s, got %s", s)
+	}
+}
+
+func TestPlayLengthAtTempo(t *testing.T) {
+	h := &queueCommandHandler{maxPlayTime: 5 * time.Minute}
+	song := queue.QueuedSong{NewSong: queue.NewSong{Duration: 3 * time.Minute}}
+
+	song.Adjustments.Tempo = 1.5
+	if length := h.playLength(song); length != 2*time.Minute {
+		t.Errorf("expected faster song to play for 2m, got %v", length)
+	}
+	song.Adjustments.Tempo = 0.5
+	if length := h.playLength(song); length != 5*time.Minute {
+		t.Errorf("expected slower song to be capped to 5m, got %v", length)
+	}
+	song.Unlimited = true
+	if length := h.playLength(song); length != 6*time.Minute {
+		t.Errorf("expected exempt slower song to play for 6m, got %v", length)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// hotkeyAction is a player action that can be bound to a key in mpv, so
+// that the host can control the queue from the machine running it.
+type hotkeyAction string
+
+const (
+	hotkeySkip    hotkeyAction = "skip"
+	hotkeyRequeue hotkeyAction = "requeue"
+	hotkeyExtend  hotkeyAction = "extend"
+	hotkeyToggle  hotkeyAction = "toggle"
+)
+
+// hotkeyMessagePrefix namespaces the script messages sent by the key
+// bindings, which mpv broadcasts to every client.
+const hotkeyMessagePrefix = "mdk3-"
+
+// withHotkeys binds keys in mpv to player actions. Extending the countdown
+// adds extendBy to it.
+func withHotkeys(keys map[hotkeyAction]string, extendBy time.Duration) playerOption {
+	return func(p *Player) {
+		p.hotkeys = keys
+		p.extendBy = extendBy
+	}
+}
+
+// bindHotkeys registers the key bindings with the backend, which loses
+// them when it restarts.
+func (p *Player) bindHotkeys(ctx context.Context) {
+	for _, action := range slices.Sorted(maps.Keys(p.hotkeys)) {
+		key := p.hotkeys[action]
+		if key == "" {
+			continue
+		}
+		if _, err := p.media.Command(ctx, "keybind", key, "script-message "+hotkeyMessagePrefix+string(action)); err != nil {
+			slog.ErrorContext(ctx, "Unable to bind hotkey", slog.String("key", key), slog.String("action", string(action)), slog.String("err", err.Error()))
+		}
+	}
+}
+
+// watchHotkeys handles the messages sent by the key bindings until stop
+// is called.
+func (p *Player) watchHotkeys(ctx context.Context) (stop func()) {
+	return p.media.AddEventHandlerSync(func(event map[string]any) {
+		if event["event"] != "client-message" {
+			return
+		}
+		args, _ := event["args"].([]any)
+		if len(args) == 0 {
+			return
+		}
+		name, _ := args[0].(string)
+		action, ok := strings.CutPrefix(name, hotkeyMessagePrefix)
+		if !ok {
+			return
+		}
+		// Commands cannot be sent from the event handler.
+		go p.handleHotkey(ctx, hotkeyAction(action))
+	})
+}
+
+// handleHotkey performs an action and shows the outcome on the OSD.
+func (p *Player) handleHotkey(ctx context.Context, action hotkeyAction) {
+	var message string
+	switch action {
+	case hotkeySkip:
+		message = "There is no song to skip."
+		if song := p.Skip(); song != nil {
+			message = fmt.Sprintf("Skipped %s.", song.Title)
+		}
+	case hotkeyRequeue:
+		song, err := p.RequeueCurrent()
+		switch {
+		case errors.Is(err, errNothingPlaying):
+			message = "There is no song to requeue."
+		case err != nil:
+			slog.ErrorContext(ctx, "Cannot requeue current song", slog.String("err", err.Error()))
+			message = "Unable to requeue the song."
+		default:
+			message = fmt.Sprintf("Requeued %s at the front of the queue.", song.Title)
+		}
+	case hotkeyExtend:
+		message = "There is no countdown to extend."
+		if p.ExtendCountdown(p.extendBy) {
+			message = fmt.Sprintf("Countdown extended by %s.", p.extendBy)
+		}
+	case hotkeyToggle:
+		message = "Queue playback stopped."
+		if p.ToggleDequeue() {
+			message = "Queue playback started."
+		}
+	default:
+		slog.WarnContext(ctx, "Unknown hotkey action", slog.String("action", string(action)))
+		return
+	}
+
+	slog.InfoContext(ctx, "Hotkey pressed", slog.String("action", string(action)), slog.String("result", message))
+	if err := showOSD(ctx, p.media, message); err != nil {
+		slog.ErrorContext(ctx, "Unable to show hotkey result", slog.String("err", err.Error()))
+	}
+}
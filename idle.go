@@ -0,0 +1,147 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"log/slog"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/xoltia/mdk3/queue"
+	"github.com/xoltia/mpv"
+)
+
+const (
+	idleRecentSongs   = 5
+	idleTopRequesters = 5
+	// idleHistory is how far back played songs are shown on the idle
+	// screen, which is roughly one night.
+	idleHistory = 12 * time.Hour
+)
+
+// idleScreen is what is shown while no song is playing.
+type idleScreen struct {
+	// waiting is set while the queue has not been started.
+	waiting    bool
+	queued     int
+	channel    string
+	recent     []queue.QueuedSong
+	requesters []requester
+}
+
+// requester is a user and the number of their songs played recently.
+type requester struct {
+	name  string
+	songs int
+}
+
+// idleKey identifies the queue state shown on the idle screen, which is
+// only rendered again when it changes.
+type idleKey struct {
+	waiting    bool
+	queued     int
+	lastPlayed int
+}
+
+// showIdle shows the idle screen, rendering it again if the queue changed
+// or the backend is no longer showing it. With idle music, the screen is
+// the cover art of the tracks and the next one is played once a track
+// ends.
+func (p *Player) showIdle(ctx context.Context, waiting bool) {
+	screen, counts, err := p.readIdleScreen(waiting)
+	if err != nil {
+		slog.ErrorContext(ctx, "Unable to read queue for idle screen", slog.String("err", err.Error()))
+		return
+	}
+	key := idleKey{waiting: waiting, queued: screen.queued}
+	if len(screen.recent) > 0 {
+		key.lastPlayed = screen.recent[0].ID
+	}
+	idle, err := getPropertyBool(ctx, p.media, "idle-active")
+	showing := err == nil && !idle
+	if showing && key == p.idleShown {
+		return
+	}
+
+	screen.channel = p.notifier.channelName(ctx)
+	screen.requesters = p.topRequesters(ctx, counts)
+	path, err := p.posters.renderIdle(screen)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error rendering idle screen", slog.String("err", err.Error()))
+		showOSD(ctx, p.media, screen.status())
+		return
+	}
+	if p.music != nil {
+		if showing && p.music.playing {
+			if err := p.showIdleCover(ctx, path); err != nil {
+				slog.ErrorContext(ctx, "Unable to update idle screen", slog.String("err", err.Error()))
+			}
+			p.idleShown = key
+			return
+		}
+		if p.playIdleMusic(ctx, path) {
+			p.idleShown = key
+			return
+		}
+	}
+	options := map[string]string{"image-display-duration": "inf"}
+	if _, err := loadFileWithOptions(ctx, p.media, path, mpv.LoadFileModeReplace, options); err != nil {
+		slog.ErrorContext(ctx, "Unable to show idle screen", slog.String("err", err.Error()))
+		return
+	}
+	p.idleShown = key
+}
+
+// readIdleScreen reads the queue state shown on the idle screen, along
+// with the number of songs played recently by each user.
+func (p *Player) readIdleScreen(waiting bool) (screen idleScreen, counts map[string]int, err error) {
+	tx := p.q.BeginTxn(false)
+	defer tx.Discard()
+
+	screen.waiting = waiting
+	if screen.queued, err = tx.Count(); err != nil {
+		return
+	}
+
+	counts = make(map[string]int)
+	cutoff := time.Now().Add(-idleHistory)
+	err = tx.IterateBackwardsFromHead(func(song queue.QueuedSong) bool {
+		if song.DequeuedAt.Before(cutoff) {
+			return false
+		}
+		if song.IsFailed() {
+			return true
+		}
+		if len(screen.recent) < idleRecentSongs {
+			screen.recent = append(screen.recent, song)
+		}
+		counts[song.UserID]++
+		return true
+	})
+	return
+}
+
+// topRequesters returns the users with the most songs played, most first.
+func (p *Player) topRequesters(ctx context.Context, counts map[string]int) []requester {
+	users := slices.SortedFunc(maps.Keys(counts), func(a, b string) int {
+		return cmp.Or(cmp.Compare(counts[b], counts[a]), cmp.Compare(a, b))
+	})
+	var requesters []requester
+	for _, user := range users[:min(len(users), idleTopRequesters)] {
+		name := p.notifier.displayName(ctx, user)
+		if name == "" {
+			name = "Unknown singer"
+		}
+		requesters = append(requesters, requester{name, counts[user]})
+	}
+	return requesters
+}
+
+// status returns the headline of the idle screen.
+func (s idleScreen) status() string {
+	if s.waiting {
+		return "Waiting for /start"
+	}
+	return "The queue is empty"
+}
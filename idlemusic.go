@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/xoltia/mpv"
+)
+
+// idleTrack is a track of the music played while no song is.
+type idleTrack struct {
+	title string
+	// file is the path or URL loaded in mpv.
+	file string
+	// stream is set for URLs, which mpv plays through yt-dlp.
+	stream bool
+}
+
+// idleMusic is the background music played in order while the queue is
+// empty or stopped. It is never added to the queue.
+type idleMusic struct {
+	tracks []idleTrack
+	// gain is added to the volume of the tracks, in dB.
+	gain float64
+	next int
+	// playing is set while a track is loaded, only used by Run.
+	playing bool
+}
+
+// withIdleMusic plays the tracks at the given gain while no song is
+// playing. Nothing is played if there are no tracks.
+func withIdleMusic(tracks []idleTrack, gain float64) playerOption {
+	return func(p *Player) {
+		if len(tracks) > 0 {
+			p.music = &idleMusic{tracks: tracks, gain: gain}
+		}
+	}
+}
+
+// loadIdleTracks returns the files in dir followed by the URLs, which are
+// resolved through yt-dlp. URLs that cannot be resolved are skipped.
+func loadIdleTracks(ctx context.Context, dir string, urls []string) ([]idleTrack, error) {
+	var tracks []idleTrack
+	if dir != "" {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+			tracks = append(tracks, idleTrack{
+				title: strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name())),
+				file:  filepath.Join(dir, entry.Name()),
+			})
+		}
+	}
+
+	for _, rawURL := range urls {
+		u, err := url.Parse(rawURL)
+		if err == nil {
+			var info *VideoInfo
+			if info, err = getVideoInfo(ctx, u); err == nil {
+				tracks = append(tracks, idleTrack{title: info.Title, file: info.URL, stream: true})
+				continue
+			}
+		}
+		slog.WarnContext(ctx, "Skipping idle music track", slog.String("url", rawURL), slog.String("err", err.Error()))
+	}
+	return tracks, nil
+}
+
+// playIdleMusic loads the next track with the idle screen at path shown as
+// its cover art, returning false if it could not be loaded.
+func (p *Player) playIdleMusic(ctx context.Context, path string) bool {
+	m := p.music
+	track := m.tracks[m.next]
+	m.next = (m.next + 1) % len(m.tracks)
+
+	options := map[string]string{
+		"cover-art-files": path,
+		"volume-gain":     strconv.FormatFloat(min(m.gain, maxVolumeGain), 'f', 2, 64),
+	}
+	if track.stream {
+		options["ytdl-format"] = audioOnlyFormat
+	}
+	if _, err := loadFileWithOptions(ctx, p.media, track.file, mpv.LoadFileModeReplace, options); err != nil {
+		slog.ErrorContext(ctx, "Unable to play idle music", slog.String("title", track.title), slog.String("err", err.Error()))
+		return false
+	}
+	slog.DebugContext(ctx, "Playing idle music", slog.String("title", track.title))
+	m.playing = true
+	return true
+}
+
+// showIdleCover shows an updated idle screen as the cover art of the track
+// being played, without interrupting it.
+func (p *Player) showIdleCover(ctx context.Context, path string) error {
+	_, err := p.media.Command(ctx, "video-add", path, "select", "idle", "", true)
+	return err
+}
+
+// stopIdleMusic marks the idle music as stopped, as a song replaces it.
+func (p *Player) stopIdleMusic() {
+	if p.music != nil {
+		p.music.playing = false
+	}
+}
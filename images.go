@@ -8,9 +8,12 @@ import (
 	"image/color"
 	"image/draw"
 	"image/png"
+	"math"
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
+	"time"
 
 	_ "embed"
 	_ "image/gif"
@@ -39,10 +42,86 @@ func init() {
 	}
 }
 
-var (
-	previewPath = filepath.Join(os.TempDir(), "mdk3-preview.png")
-	loadingPath = filepath.Join(os.TempDir(), "mdk3-loading.png")
-)
+// filePosterRenderer renders posters to PNG files in a directory.
+type filePosterRenderer struct {
+	previewPath string
+	loadingPath string
+	idlePath    string
+	// countdownPaths and progressPaths are used in turn so that the file
+	// shown by mpv is not rewritten while it is being read.
+	countdownPaths  [2]string
+	audioPosterPath string
+	progressPaths   [2]string
+}
+
+func newFilePosterRenderer(dir string) *filePosterRenderer {
+	return &filePosterRenderer{
+		previewPath: filepath.Join(dir, "mdk3-preview.png"),
+		loadingPath: filepath.Join(dir, "mdk3-loading.png"),
+		idlePath:    filepath.Join(dir, "mdk3-idle.png"),
+		countdownPaths: [2]string{
+			filepath.Join(dir, "mdk3-countdown-0.bgra"),
+			filepath.Join(dir, "mdk3-countdown-1.bgra"),
+		},
+		audioPosterPath: filepath.Join(dir, "mdk3-audio-poster.bgra"),
+		progressPaths: [2]string{
+			filepath.Join(dir, "mdk3-progress-0.bgra"),
+			filepath.Join(dir, "mdk3-progress-1.bgra"),
+		},
+	}
+}
+
+func (r *filePosterRenderer) renderPreview(
+	song queue.QueuedSong,
+	username string,
+	nextSongs []queue.QueuedSong,
+	thumbnail image.Image,
+) (string, error) {
+	return writePreviewPoster(r.previewPath, song, username, nextSongs, thumbnail)
+}
+
+func (r *filePosterRenderer) renderLoading(thumbnail image.Image) (string, error) {
+	return writeLoadingPoster(r.loadingPath, thumbnail)
+}
+
+func (r *filePosterRenderer) renderIdle(screen idleScreen) (string, error) {
+	return r.idlePath, savePNG(r.idlePath, drawIdleScreen(screen))
+}
+
+func (r *filePosterRenderer) renderCountdown(username string, left, total time.Duration, frame int) (overlayImage, error) {
+	img := drawCountdown(username, left, total, frame)
+	path := r.countdownPaths[frame%2]
+	return overlayImage{
+		path:   path,
+		width:  img.Bounds().Dx(),
+		height: img.Bounds().Dy(),
+	}, writeOverlay(path, img)
+}
+
+func (r *filePosterRenderer) renderAudioPoster(
+	song queue.QueuedSong,
+	username string,
+	nextSongs []queue.QueuedSong,
+	thumbnail image.Image,
+	width, height int,
+) (overlayImage, error) {
+	img := fitToScreen(drawPreviewPoster(song, username, nextSongs, thumbnail), width, height)
+	return overlayImage{
+		path:   r.audioPosterPath,
+		width:  width,
+		height: height,
+	}, writeOverlay(r.audioPosterPath, img)
+}
+
+func (r *filePosterRenderer) renderProgress(elapsed, length time.Duration, width, frame int) (overlayImage, error) {
+	img := drawProgress(elapsed, length, width)
+	path := r.progressPaths[frame%2]
+	return overlayImage{
+		path:   path,
+		width:  img.Bounds().Dx(),
+		height: img.Bounds().Dy(),
+	}, writeOverlay(path, img)
+}
 
 func downloadThumbnail(ctx context.Context, url string) (image.Image, error) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
@@ -73,18 +152,18 @@ func downloadThumbnail(ctx context.Context, url string) (image.Image, error) {
 }
 
 func writePreviewPoster(
+	previewPath string,
 	song queue.QueuedSong,
 	username string,
 	nextSongs []queue.QueuedSong,
 	thumbnail image.Image,
 ) (string, error) {
-	poster, err := os.Create(previewPath)
-	if err != nil {
-		return "", err
-	}
-
-	defer poster.Close()
+	return previewPath, savePNG(previewPath, drawPreviewPoster(song, username, nextSongs, thumbnail))
+}
 
+// drawPreviewPoster draws the poster shown before a song: its thumbnail,
+// title, singer and adjustments, along with the songs up next.
+func drawPreviewPoster(song queue.QueuedSong, username string, nextSongs []queue.QueuedSong, thumbnail image.Image) *image.RGBA {
 	smallThumbnail := image.NewRGBA(image.Rect(0, 0, 1024, 576))
 	xdraw.ApproxBiLinear.Scale(smallThumbnail, smallThumbnail.Bounds(), thumbnail, thumbnail.Bounds(), xdraw.Over, nil)
 
@@ -121,6 +200,17 @@ func writePreviewPoster(
 		OverflowMode: twemoji.OverflowModeClip,
 	})
 
+	if adjustments := formatAdjustments(song.Adjustments); adjustments != "" {
+		twemoji.DrawText(img, twemoji.DrawTextOptions{
+			Text:         adjustments,
+			MaxWidth:     1720,
+			X:            100,
+			Y:            800 + y + 96,
+			Face:         face,
+			OverflowMode: twemoji.OverflowModeClip,
+		})
+	}
+
 	twemoji.DrawText(img, twemoji.DrawTextOptions{
 		Text:         "Up next:",
 		MaxWidth:     675,
@@ -141,10 +231,10 @@ func writePreviewPoster(
 		})
 	}
 
-	return previewPath, savePNG(previewPath, img)
+	return img
 }
 
-func writeLoadingPoster(thumbnail image.Image) (string, error) {
+func writeLoadingPoster(loadingPath string, thumbnail image.Image) (string, error) {
 	poster, err := os.Create(loadingPath)
 	if err != nil {
 		return "", err
@@ -174,6 +264,241 @@ func writeLoadingPoster(thumbnail image.Image) (string, error) {
 	return loadingPath, savePNG(loadingPath, img)
 }
 
+// idleHints are the commands shown on the idle screen.
+var idleHints = []string{
+	"/enqueue <url> to add your song",
+	"/list to see what's coming up",
+	"/remove <id> to take your song out",
+}
+
+// drawIdleScreen draws the screen shown while no song is playing, with
+// how to join in, the songs played recently and the top requesters.
+func drawIdleScreen(screen idleScreen) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 1920, 1080))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{18, 18, 28, 255}), image.Point{}, draw.Src)
+	white := color.RGBA{255, 255, 255, 255}
+	gray := color.RGBA{170, 170, 190, 255}
+	accent := color.RGBA{255, 214, 10, 255}
+
+	face := truetype.NewFace(notoSansFont, &truetype.Options{Size: 96, DPI: 72})
+	drawCentered(img, "Karaoke", face, white, 200)
+	face = truetype.NewFace(notoSansFont, &truetype.Options{Size: 48, DPI: 72})
+	drawCentered(img, screen.status(), face, accent, 290)
+	if screen.queued > 0 {
+		face = truetype.NewFace(notoSansFont, &truetype.Options{Size: 36, DPI: 72})
+		drawCentered(img, fmt.Sprintf("%d songs in the queue", screen.queued), face, gray, 350)
+	}
+
+	heading := truetype.NewFace(notoSansFont, &truetype.Options{Size: 44, DPI: 72})
+	text := truetype.NewFace(notoSansFont, &truetype.Options{Size: 32, DPI: 72})
+	column := func(x int, title string, lines []string) {
+		twemoji.DrawText(img, twemoji.DrawTextOptions{
+			Text:     title,
+			MaxWidth: 540,
+			X:        x,
+			Y:        450,
+			Face:     heading,
+			Color:    accent,
+		})
+		for i, line := range lines {
+			twemoji.DrawText(img, twemoji.DrawTextOptions{
+				Text:         line,
+				MaxWidth:     540,
+				X:            x,
+				Y:            530 + i*56,
+				Face:         text,
+				Color:        white,
+				OverflowMode: twemoji.OverflowModeClip,
+			})
+		}
+	}
+
+	join := slices.Clone(idleHints)
+	if screen.channel != "" {
+		join = append([]string{"Type in #" + screen.channel + ":"}, join...)
+	}
+	column(100, "Join in", join)
+
+	var recent []string
+	for _, song := range screen.recent {
+		recent = append(recent, song.Title)
+	}
+	if len(recent) == 0 {
+		recent = []string{"Nothing yet, be the first!"}
+	}
+	column(690, "Recently played", recent)
+
+	var requesters []string
+	for i, r := range screen.requesters {
+		songs := "songs"
+		if r.songs == 1 {
+			songs = "song"
+		}
+		requesters = append(requesters, fmt.Sprintf("%d. %s · %d %s", i+1, r.name, r.songs, songs))
+	}
+	if len(requesters) > 0 {
+		column(1280, "Top singers", requesters)
+	}
+	return img
+}
+
+// overlayImage is a raw BGRA image file that can be shown with mpv's
+// overlay-add command.
+type overlayImage struct {
+	path   string
+	width  int
+	height int
+}
+
+const (
+	countdownWidth  = 560
+	countdownHeight = 440
+	// countdownUrgent is when the countdown ring turns orange.
+	countdownUrgent = 5 * time.Second
+)
+
+// drawCountdown draws the countdown overlay: a ring showing the time left
+// around the number of seconds, the name of the singer and a pulsing
+// "Get ready!" that alternates in size with each frame.
+func drawCountdown(username string, left, total time.Duration, frame int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, countdownWidth, countdownHeight))
+	drawShadow(img, 0, 0, countdownWidth, countdownHeight, 180)
+
+	progress := 0.0
+	if total > 0 {
+		progress = min(max(float64(left)/float64(total), 0), 1)
+	}
+	ringColor := color.RGBA{29, 161, 242, 255}
+	if left <= countdownUrgent {
+		ringColor = color.RGBA{255, 149, 0, 255}
+	}
+	center := image.Pt(countdownWidth/2, 160)
+	drawRing(img, center, 130, 16, 1, color.RGBA{80, 80, 80, 255})
+	drawRing(img, center, 130, 16, progress, ringColor)
+
+	seconds := int(math.Ceil(max(left, 0).Seconds()))
+	face := truetype.NewFace(notoSansFont, &truetype.Options{Size: 96, DPI: 72})
+	drawCentered(img, fmt.Sprint(seconds), face, color.White, center.Y+34)
+
+	face = truetype.NewFace(notoSansFont, &truetype.Options{Size: 36, DPI: 72})
+	name := "🎤 " + username
+	nameWidth := min(font.MeasureString(face, name).Ceil()+36, countdownWidth-40)
+	twemoji.DrawText(img, twemoji.DrawTextOptions{
+		Text:         name,
+		MaxWidth:     countdownWidth - 40,
+		X:            (countdownWidth - nameWidth) / 2,
+		Y:            310,
+		Face:         face,
+		Color:        color.White,
+		OverflowMode: twemoji.OverflowModeClip,
+	})
+
+	size := 36.0
+	if frame%2 == 1 {
+		size = 42
+	}
+	face = truetype.NewFace(notoSansFont, &truetype.Options{Size: size, DPI: 72})
+	drawCentered(img, "Get ready!", face, color.RGBA{255, 214, 10, 255}, 410)
+	return img
+}
+
+// fitToScreen scales an image to fit a screen of the given size, keeping
+// its aspect ratio and filling the rest with black.
+func fitToScreen(src image.Image, width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.Black, image.Point{}, draw.Src)
+	bounds := src.Bounds()
+	scale := min(float64(width)/float64(bounds.Dx()), float64(height)/float64(bounds.Dy()))
+	w, h := int(float64(bounds.Dx())*scale), int(float64(bounds.Dy())*scale)
+	dst := image.Rect((width-w)/2, (height-h)/2, (width+w)/2, (height+h)/2)
+	xdraw.ApproxBiLinear.Scale(img, dst, src, bounds, xdraw.Over, nil)
+	return img
+}
+
+const (
+	progressHeight = 64
+	// progressTextWidth is the space to the right of the bar taken by
+	// the playback time.
+	progressTextWidth = 220
+)
+
+// drawProgress draws the progress bar overlay of a song played without
+// video, with the playback time next to it. An unknown length leaves the
+// bar empty.
+func drawProgress(elapsed, length time.Duration, width int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, progressHeight))
+	drawShadow(img, 0, 0, width, progressHeight, 180)
+
+	barWidth := max(width-progressTextWidth-48, 0)
+	draw.Draw(img, image.Rect(24, 26, 24+barWidth, 38), image.NewUniform(color.RGBA{80, 80, 80, 255}), image.Point{}, draw.Src)
+	text := formatPlaybackTime(elapsed.Seconds())
+	if length > 0 {
+		filled := int(float64(barWidth) * min(max(float64(elapsed)/float64(length), 0), 1))
+		draw.Draw(img, image.Rect(24, 26, 24+filled, 38), image.NewUniform(color.RGBA{29, 161, 242, 255}), image.Point{}, draw.Src)
+		text += " / " + formatPlaybackTime(length.Seconds())
+	}
+
+	face := truetype.NewFace(notoSansFont, &truetype.Options{Size: 28, DPI: 72})
+	drawer := font.Drawer{
+		Dst:  img,
+		Src:  image.White,
+		Face: face,
+	}
+	drawer.Dot = fixed.P(width-progressTextWidth, 42)
+	drawer.DrawString(text)
+	return img
+}
+
+// drawRing draws the given fraction of a ring clockwise from the top.
+func drawRing(img *image.RGBA, center image.Point, radius, thickness, fraction float64, c color.Color) {
+	bounds := image.Rect(center.X-int(radius)-1, center.Y-int(radius)-1, center.X+int(radius)+1, center.Y+int(radius)+1)
+	mask := image.NewAlpha(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			dx := float64(x-center.X) + 0.5
+			dy := float64(y-center.Y) + 0.5
+			angle := math.Atan2(dx, -dy)
+			if angle < 0 {
+				angle += 2 * math.Pi
+			}
+			if angle > fraction*2*math.Pi {
+				continue
+			}
+			// Smooth the edges over a pixel.
+			d := math.Hypot(dx, dy)
+			coverage := min(d-(radius-thickness), radius-d) + 0.5
+			mask.SetAlpha(x, y, color.Alpha{uint8(min(max(coverage, 0), 1) * 255)})
+		}
+	}
+	draw.DrawMask(img, bounds, image.NewUniform(c), image.Point{}, mask, bounds.Min, draw.Over)
+}
+
+// drawCentered draws text centered horizontally on the given baseline.
+func drawCentered(img *image.RGBA, text string, face font.Face, c color.Color, baseline int) {
+	drawer := font.Drawer{
+		Dst:  img,
+		Src:  image.NewUniform(c),
+		Face: face,
+	}
+	width := drawer.MeasureString(text).Ceil()
+	drawer.Dot = fixed.P((img.Bounds().Dx()-width)/2, baseline)
+	drawer.DrawString(text)
+}
+
+// writeOverlay writes an image as the premultiplied BGRA pixels expected
+// by overlay-add.
+func writeOverlay(path string, img *image.RGBA) error {
+	bounds := img.Bounds()
+	pixels := make([]byte, 0, bounds.Dx()*bounds.Dy()*4)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(bounds.Min.X, y):img.PixOffset(bounds.Max.X, y)]
+		for i := 0; i < len(row); i += 4 {
+			pixels = append(pixels, row[i+2], row[i+1], row[i], row[i+3])
+		}
+	}
+	return os.WriteFile(path, pixels, 0o644)
+}
+
 func drawShadow(img *image.RGBA, x, y, w, h int, alpha uint8) {
 	shadow := image.NewUniform(color.RGBA{0, 0, 0, alpha})
 	draw.Draw(img, image.Rect(x, y, x+w, y+h), shadow, image.Point{}, draw.Over)
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriteOverlay(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	img.SetRGBA(0, 0, color.RGBA{R: 10, G: 20, B: 30, A: 255})
+	img.SetRGBA(1, 0, color.RGBA{R: 5, G: 0, B: 0, A: 128})
+
+	path := filepath.Join(t.TempDir(), "overlay.bgra")
+	if err := writeOverlay(path, img); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{30, 20, 10, 255, 0, 0, 5, 128}
+	if !bytes.Equal(b, expected) {
+		t.Errorf("expected %v, got %v", expected, b)
+	}
+}
+
+func TestRenderCountdownAlternatesFiles(t *testing.T) {
+	r := newFilePosterRenderer(t.TempDir())
+	first, err := r.renderCountdown("Singer 🎤", 30*time.Second, 30*time.Second, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := r.renderCountdown("Singer 🎤", 29*time.Second, 30*time.Second, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.path == second.path {
+		t.Error("expected consecutive frames to use different files")
+	}
+	info, err := os.Stat(second.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != int64(second.width*second.height*4) {
+		t.Errorf("expected %dx%d BGRA pixels, got %d bytes", second.width, second.height, info.Size())
+	}
+}
+
+func TestFitToScreenLetterboxes(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 160, 90))
+	draw.Draw(src, src.Bounds(), image.White, image.Point{}, draw.Src)
+
+	img := fitToScreen(src, 100, 100)
+	if img.Bounds().Dx() != 100 || img.Bounds().Dy() != 100 {
+		t.Fatalf("expected 100x100 image, got %v", img.Bounds())
+	}
+	if c := img.RGBAAt(50, 10); c != (color.RGBA{0, 0, 0, 255}) {
+		t.Errorf("expected black bar above the poster, got %v", c)
+	}
+	if c := img.RGBAAt(50, 50); c != (color.RGBA{255, 255, 255, 255}) {
+		t.Errorf("expected poster in the middle, got %v", c)
+	}
+}
@@ -0,0 +1,253 @@
+//go:build unix
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/xoltia/mdk3/mpvtest"
+	"github.com/xoltia/mdk3/queue"
+	"github.com/xoltia/mpv"
+)
+
+// mpvTestEnv makes the test binary act as mpv so that the player can be
+// tested against a real process and socket.
+const mpvTestEnv = "MDK3_MPVTEST"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(mpvTestEnv) == "1" {
+		if err := mpvtest.Main(os.Args[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// fakeMPVProcess returns a function creating processes that run the test
+// binary as mpv, all listening on the same socket.
+func fakeMPVProcess(t *testing.T, args ...string) func() *mpv.Process {
+	t.Helper()
+	t.Setenv(mpvTestEnv, "1")
+
+	socketPath := filepath.Join(t.TempDir(), "mpv.sock")
+	return func() *mpv.Process {
+		return mpv.NewProcessWithOptions(mpv.ProcessOptions{
+			Path:          os.Args[0],
+			Args:          args,
+			Stderr:        os.Stderr,
+			ClientOptions: mpv.ClientOptions{SocketPath: socketPath},
+		})
+	}
+}
+
+// startFakeMPV starts the test binary as an mpv process and connects to
+// it, along with a client for inspecting its state.
+func startFakeMPV(t *testing.T, args ...string) (player *mpv.Client, inspector *mpv.Client) {
+	t.Helper()
+	process := fakeMPVProcess(t, args...)()
+	t.Cleanup(func() { process.Close() })
+
+	player, err := process.OpenClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	inspector, err = process.OpenClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return player, inspector
+}
+
+func waitUntil(t *testing.T, description string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", description)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPlayerWithMPVProcess(t *testing.T) {
+	client, inspector := startFakeMPV(t,
+		"--mpvtest-image-duration=50ms",
+		"--mpvtest-default-duration=200ms",
+	)
+
+	q, err := queue.OpenQueue(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { q.Close() })
+
+	enqueueTestSong(t, q, "Song", "https://example.com/song")
+
+	notifier := &fakeNotifier{}
+	player := newPlayer(
+		q, client,
+		withNotifier(notifier),
+		withPosterRenderer(&fakePosters{}),
+		withThumbnailFetcher(func(context.Context, string) (image.Image, error) {
+			return image.Black, nil
+		}),
+		withCountdown(100*time.Millisecond),
+	)
+	player.SetDequeueEnabled(true)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	finished := make(chan error, 1)
+	go func() { finished <- player.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		<-finished
+	})
+
+	waitUntil(t, "song to play", func() bool {
+		path, err := inspector.GetProperty(ctx, "path")
+		return err == nil && path == "https://example.com/song"
+	})
+	waitUntil(t, "song to finish", func() bool {
+		return player.Current() == nil
+	})
+
+	tx := q.BeginTxn(false)
+	defer tx.Discard()
+	count, err := tx.Count()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("expected queue to be empty, got %d songs", count)
+	}
+	if len(notifier.notified()) != 1 {
+		t.Errorf("expected requester to be notified once, got %v", notifier.notified())
+	}
+}
+
+func TestSupervisorRestartsMPV(t *testing.T) {
+	supervisor := newMPVSupervisor(fakeMPVProcess(t,
+		"--mpvtest-image-duration=50ms",
+		"--mpvtest-default-duration=1h",
+	))
+	supervisor.minBackoff = 10 * time.Millisecond
+	if err := supervisor.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { supervisor.Close() })
+
+	q, err := queue.OpenQueue(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { q.Close() })
+	enqueueTestSong(t, q, "Song", "https://example.com/song")
+
+	notifier := &fakeNotifier{}
+	player := newPlayer(
+		q, supervisor,
+		withNotifier(notifier),
+		withPosterRenderer(&fakePosters{}),
+		withThumbnailFetcher(func(context.Context, string) (image.Image, error) {
+			return image.Black, nil
+		}),
+		withCountdown(50*time.Millisecond),
+	)
+	player.pollInterval = 10 * time.Millisecond
+	player.SetDequeueEnabled(true)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	finished := make(chan error, 1)
+	go supervisor.Run(ctx, player)
+	go func() { finished <- player.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		<-finished
+	})
+
+	if err := player.SetVolume(ctx, 70); err != nil {
+		t.Fatal(err)
+	}
+	waitUntil(t, "song to play", func() bool {
+		return player.current.position() > 200*time.Millisecond
+	})
+
+	position := player.current.position()
+	// Like real mpv, the process may exit before its reply arrives.
+	if _, err := supervisor.Command(ctx, "quit"); err != nil && !errors.Is(err, mpv.ErrClosed) {
+		t.Fatal(err)
+	}
+
+	waitUntil(t, "mpv to restart", func() bool {
+		return len(notifier.noticed()) == 2
+	})
+	waitUntil(t, "song to resume", func() bool {
+		path, err := supervisor.GetProperty(ctx, "path")
+		return err == nil && path == "https://example.com/song"
+	})
+	resumedAt, err := getPropertyFloat(ctx, supervisor, "time-pos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resumedAt < position.Seconds() {
+		t.Errorf("expected song to resume at %v or later, got %vs", position, resumedAt)
+	}
+	volume, err := getPropertyFloat(ctx, supervisor, "volume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if volume != 70 {
+		t.Errorf("expected volume to be restored, got %v", volume)
+	}
+	if current := player.Current(); current == nil || current.Title != "Song" {
+		t.Errorf("expected song to still be current, got %v", current)
+	}
+}
+
+func TestSupervisorRestartsMPVOnRequest(t *testing.T) {
+	supervisor := newMPVSupervisor(fakeMPVProcess(t,
+		"--mpvtest-image-duration=50ms",
+		"--mpvtest-default-duration=1h",
+	))
+	supervisor.minBackoff = time.Hour
+	if err := supervisor.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { supervisor.Close() })
+
+	q, err := queue.OpenQueue(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { q.Close() })
+
+	notifier := &fakeNotifier{}
+	player := newPlayer(q, supervisor, withNotifier(notifier), withPosterRenderer(&fakePosters{}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go supervisor.Run(ctx, player)
+
+	if err := supervisor.Restart(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// The restart is not delayed by the backoff.
+	waitUntil(t, "mpv to restart", func() bool {
+		return len(notifier.noticed()) > 0
+	})
+	if notices := notifier.noticed(); len(notices) != 1 || notices[0] != "The player is back." {
+		t.Errorf("expected only the restart to be announced, got %v", notices)
+	}
+	if _, err := supervisor.GetProperty(ctx, "idle-active"); err != nil {
+		t.Errorf("expected new process to be connected, got %v", err)
+	}
+}
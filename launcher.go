@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/xoltia/mpv"
+)
+
+// errUnknownProfile is returned when switching to a profile that is not
+// in the configuration.
+var errUnknownProfile = errors.New("unknown mpv profile")
+
+// mpvLauncher creates mpv processes from the configuration, with the
+// arguments of the selected profile.
+type mpvLauncher struct {
+	path string
+	cfg  mpvConfig
+	ytdl ytdlOptions
+
+	mu      sync.Mutex
+	profile string
+}
+
+func newMPVLauncher(path string, cfg mpvConfig, ytdl ytdlOptions) *mpvLauncher {
+	return &mpvLauncher{path: path, cfg: cfg, ytdl: ytdl, profile: cfg.Profile}
+}
+
+// newProcess returns a process that is started with the arguments of the
+// current profile.
+func (l *mpvLauncher) newProcess() *mpv.Process {
+	return mpv.NewProcessWithOptions(mpv.ProcessOptions{
+		Path:           l.path,
+		Args:           l.args(),
+		ConnMaxRetries: l.cfg.ConnectRetries,
+		ConnRetryDelay: l.cfg.ConnectRetryDelay,
+		ClientOptions:  mpv.ClientOptions{SocketPath: l.cfg.SocketPath, DialTimeout: l.cfg.DialTimeout},
+	})
+}
+
+// args returns the arguments mpv is started with. Later arguments take
+// precedence, so the profile can override the common ones.
+func (l *mpvLauncher) args() []string {
+	args := []string{"--force-window"}
+	if l.cfg.ConfigDir != "" {
+		args = append(args, "--config-dir="+l.cfg.ConfigDir)
+	}
+	args = append(args, l.ytdl.mpvArgs()...)
+	args = append(args, l.cfg.Args...)
+	return append(args, l.cfg.Profiles[l.Profile()].Args...)
+}
+
+// Profile returns the name of the current profile, or an empty string
+// if none is selected.
+func (l *mpvLauncher) Profile() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.profile
+}
+
+// Profiles returns the names of the configured profiles in order.
+func (l *mpvLauncher) Profiles() []string {
+	return slices.Sorted(maps.Keys(l.cfg.Profiles))
+}
+
+// SetProfile selects the profile used by the next process.
+func (l *mpvLauncher) SetProfile(name string) error {
+	if _, ok := l.cfg.Profiles[name]; !ok {
+		return errUnknownProfile
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.profile = name
+	return nil
+}
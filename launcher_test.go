@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestMPVLauncherArgs(t *testing.T) {
+	l := newMPVLauncher("mpv", mpvConfig{
+		Args:      []string{"--volume=80"},
+		ConfigDir: "/etc/mdk3/mpv",
+		Profile:   "venue-tv",
+		Profiles: map[string]mpvProfile{
+			"venue-tv": {Args: []string{"--fs", "--screen=1"}},
+			"stream":   {Args: []string{"--force-window=no"}},
+		},
+	}, ytdlOptions{})
+
+	expected := []string{"--force-window", "--config-dir=/etc/mdk3/mpv", "--volume=80", "--fs", "--screen=1"}
+	if args := l.args(); !slices.Equal(args, expected) {
+		t.Errorf("expected %v, got %v", expected, args)
+	}
+
+	if err := l.SetProfile("stream"); err != nil {
+		t.Fatal(err)
+	}
+	expected = []string{"--force-window", "--config-dir=/etc/mdk3/mpv", "--volume=80", "--force-window=no"}
+	if args := l.args(); !slices.Equal(args, expected) {
+		t.Errorf("expected %v, got %v", expected, args)
+	}
+
+	if err := l.SetProfile("audio-only"); !errors.Is(err, errUnknownProfile) {
+		t.Errorf("expected unknown profile error, got %v", err)
+	}
+	if profile := l.Profile(); profile != "stream" {
+		t.Errorf("expected profile to stay stream, got %q", profile)
+	}
+	if profiles := l.Profiles(); !slices.Equal(profiles, []string{"stream", "venue-tv"}) {
+		t.Errorf("expected sorted profiles, got %v", profiles)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+)
+
+var ffmpegPath = "ffmpeg"
+
+// maxGain limits the normalization gain in dB so that silent or broken
+// files are not amplified into noise.
+const maxGain = 15.0
+
+// measureLoudness returns the integrated loudness of a file in LUFS,
+// measured with ffmpeg's loudnorm filter.
+func measureLoudness(ctx context.Context, path string) (float64, error) {
+	cmd := exec.CommandContext(ctx, ffmpegPath,
+		"-hide_banner",
+		"-nostats",
+		"-i", path,
+		"-vn",
+		"-af", "loudnorm=print_format=json",
+		"-f", "null",
+		"-",
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if message := lastLine(stderr.Bytes()); message != "" {
+			return 0, errors.New(message)
+		}
+		return 0, err
+	}
+	return parseLoudnorm(stderr.Bytes())
+}
+
+// parseLoudnorm reads the input loudness from the JSON summary printed
+// by the loudnorm filter at the end of the ffmpeg output.
+func parseLoudnorm(output []byte) (float64, error) {
+	start := bytes.LastIndexByte(output, '{')
+	end := bytes.LastIndexByte(output, '}')
+	if start < 0 || end < start {
+		return 0, errors.New("no loudnorm summary in ffmpeg output")
+	}
+
+	var summary struct {
+		InputI string `json:"input_i"`
+	}
+	if err := json.Unmarshal(output[start:end+1], &summary); err != nil {
+		return 0, err
+	}
+	loudness, err := strconv.ParseFloat(summary.InputI, 64)
+	if err != nil || math.IsInf(loudness, 0) {
+		return 0, fmt.Errorf("invalid loudness %q", summary.InputI)
+	}
+	return loudness, nil
+}
+
+// normalizationGain returns the gain in dB bringing a song with the
+// given loudness to the target loudness.
+func normalizationGain(loudness, target float64) float64 {
+	return min(max(target-loudness, -maxGain), maxGain)
+}
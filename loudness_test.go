@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestParseLoudnorm(t *testing.T) {
+	output := []byte(`[Parsed_loudnorm_0 @ 0x5581] 
+{
+	"input_i" : "-23.45",
+	"input_tp" : "-4.20",
+	"input_lra" : "7.10",
+	"input_thresh" : "-33.80"
+}
+`)
+	loudness, err := parseLoudnorm(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loudness != -23.45 {
+		t.Errorf("expected -23.45, got %v", loudness)
+	}
+
+	if _, err := parseLoudnorm([]byte(`{"input_i" : "-inf"}`)); err == nil {
+		t.Error("expected error for silent input")
+	}
+	if gain := normalizationGain(-70, -16); gain != maxGain {
+		t.Errorf("expected gain to be limited to %v, got %v", maxGain, gain)
+	}
+}
@@ -2,26 +2,23 @@ package main
 
 import (
 	"context"
-	"errors"
 	"flag"
 	"fmt"
 	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
-	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/diamondburned/arikawa/v3/discord"
 	"github.com/diamondburned/arikawa/v3/gateway"
 	"github.com/diamondburned/arikawa/v3/state"
-	"github.com/xoltia/mdk3/queue"
-	"github.com/xoltia/mpv"
 )
 
 var (
 	configFile    = flag.String("config", "config.toml", "config file")
 	skipOverwrite = flag.Bool("skip-overwrite", false, "skip overwriting commands")
+	startProfile  = flag.String("profile", "", "mpv profile to start with instead of the configured one")
 )
 
 func main() {
@@ -53,62 +50,48 @@ func main() {
 	flag.Parse()
 	// goutubedl.Path = cfg.Binary.YTDLPath
 	ytdlpPath = cfg.Binary.YTDLPath
+	ytdlpOptions = newYTDLOptions(cfg.YTDL)
+	ffmpegPath = cfg.Binary.FFmpegPath
 
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
-	mpvProcess := mpv.NewProcessWithOptions(mpv.ProcessOptions{
-		Path:           cfg.Binary.MPVPath,
-		Args:           []string{"--force-window"},
-		ConnMaxRetries: 10,
-		ConnRetryDelay: time.Second * 1,
-	})
-	defer mpvProcess.Close()
-
-	mpvClient, err := mpvProcess.OpenClient()
-	if err != nil {
-		slog.ErrorContext(ctx, "Unable to open mpv client", slog.String("err", err.Error()))
-		exitCode = 1
-		return
-	}
+	slog.InfoContext(ctx, "Initializing Discord application")
 
-	go func() {
-		mpvProcess.Wait()
-		slog.ErrorContext(ctx, "MPV process exited")
-		cancel()
+	s := state.New("Bot " + cfg.Discord.Token)
+	router := &roomRouter{}
+	defer func() {
+		for _, r := range router.rooms {
+			r.Close()
+		}
 	}()
 
-	slog.InfoContext(ctx, "Connected to MPV")
-
-	q, err := queue.OpenQueue(cfg.QueuePath)
+	music, err := loadIdleTracks(ctx, cfg.IdleMusic.Dir, cfg.IdleMusic.URLs)
 	if err != nil {
-		slog.ErrorContext(ctx, "Error opening queue database", slog.String("err", err.Error()))
-		if errors.Is(err, queue.ErrVersionMismatch) {
-			slog.ErrorContext(ctx, "The current queue data was created with an incompatible version, move/delete it or set a different location in the configuration file")
-		}
+		slog.ErrorContext(ctx, "Unable to load idle music", slog.String("err", err.Error()))
 		exitCode = 1
 		return
 	}
-	defer q.Close()
 
-	go func() {
-		err = q.GC()
+	// Rooms share the cache of announcements.
+	var announce announcer
+	if len(cfg.Announcement.Command) > 0 {
+		announce = newTTSAnnouncer(cfg.Announcement.Command, cfg.Announcement.Dir)
+	}
+
+	var names []string
+	for _, rc := range cfg.rooms() {
+		r, err := openRoom(ctx, s, cfg, rc, music, announce)
 		if err != nil {
-			slog.WarnContext(ctx, "Error calling GC on queue database", slog.String("err", err.Error()))
+			slog.ErrorContext(ctx, "Unable to open room", slog.String("err", err.Error()), slog.String("room", rc.name))
+			exitCode = 1
+			return
 		}
-	}()
-
-	slog.InfoContext(ctx, "Initializing Discord application")
+		router.rooms = append(router.rooms, r)
+		names = append(names, rc.name)
+	}
 
-	s := state.New("Bot " + cfg.Discord.Token)
-	handler := newHandler(
-		s, q,
-		withUserLimit(cfg.UserLimit),
-		withAdminRoles(cfg.Discord.AdminRoles),
-		withPlaybackTime(cfg.PlaybackTime),
-	)
-
-	s.AddInteractionHandler(handler)
+	s.AddInteractionHandler(router)
 	s.AddIntents(gateway.IntentGuilds | gateway.IntentGuildMembers | gateway.IntentGuildMessages)
 
 	if !*skipOverwrite {
@@ -118,14 +101,16 @@ func main() {
 			exitCode = 1
 			return
 		}
-		if _, err := s.BulkOverwriteGuildCommands(application.ID, discord.GuildID(cfg.Discord.Guild), commands); err != nil {
+		if _, err := s.BulkOverwriteGuildCommands(application.ID, discord.GuildID(cfg.Discord.Guild), roomCommands(names)); err != nil {
 			slog.ErrorContext(ctx, "Unable to overwrite application commands", slog.String("err", err.Error()))
 			exitCode = 1
 			return
 		}
 	}
 
-	go loopPlayMPV(ctx, q, handler, mpvClient, cfg)
+	for _, r := range router.rooms {
+		go r.Run(ctx)
+	}
 
 	slog.InfoContext(ctx, "Connecting Discord application")
 	if err := s.Connect(ctx); err != nil {
@@ -3,228 +3,159 @@ package main
 import (
 	"context"
 	"fmt"
-	"image"
 	"log/slog"
-	"sync/atomic"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/diamondburned/arikawa/v3/discord"
-	"github.com/xoltia/mdk3/queue"
 	"github.com/xoltia/mpv"
 )
 
-var (
-	dequeueEnabled = atomic.Bool{}
-)
+// maxVolume is the highest volume accepted by mpv with default settings.
+const maxVolume = 130
+
+// mediaBackend is the subset of the mpv client used by the player.
+type mediaBackend interface {
+	Command(ctx context.Context, command string, args ...any) (any, error)
+	LoadFile(ctx context.Context, file string, mode mpv.LoadFileMode) error
+	SetProperty(ctx context.Context, property string, value any) error
+	GetProperty(ctx context.Context, property string) (any, error)
+	ObserveProperty(ctx context.Context, property string, fn func(any)) (func() error, error)
+	AddEventHandlerSync(fn func(event map[string]any)) (rm func())
+}
 
-func showOSD(ctx context.Context, mpvClient *mpv.Client, text string) error {
-	_, err := mpvClient.Command(ctx, "show-text", text)
+var _ mediaBackend = (*mpv.Client)(nil)
+
+func showOSD(ctx context.Context, media mediaBackend, text string) error {
+	_, err := media.Command(ctx, "show-text", text)
 	return err
 }
 
-func loopPlayMPV(ctx context.Context, q *queue.Queue, h *queueCommandHandler, mpvClient *mpv.Client, cfg config) {
-	if cfg.StartImmediately {
-		slog.DebugContext(ctx, "Start immediately flag set")
-		dequeueEnabled.Store(true)
-	}
-
-	// Set osd-duration
-	if err := mpvClient.SetProperty(ctx, "osd-duration", 1100); err != nil {
-		slog.ErrorContext(ctx, "Failed to set OSD duration", slog.String("err", err.Error()))
+// stopMedia stops playback and clears the mpv playlist, causing mpv to
+// enter the idle state.
+func stopMedia(ctx context.Context, media mediaBackend) {
+	if _, err := media.Command(ctx, "stop"); err != nil {
+		slog.ErrorContext(ctx, "Unable to stop mpv playback", slog.String("err", err.Error()))
 	}
+}
 
-	for {
-		if ctx.Err() != nil {
-			return
-		}
-
-		if !dequeueEnabled.Load() {
-			showOSD(ctx, mpvClient, "Waiting for /start")
-			select {
-			case <-ctx.Done():
-				return
-			case <-time.After(1 * time.Second):
-				continue
-			}
-		}
-
-		tx := q.BeginTxn(true)
-		song, err := tx.Dequeue()
-		if err != nil {
-			if err == queue.ErrQueueEmpty {
-				tx.Discard()
-				select {
-				case <-ctx.Done():
-					return
-				case <-time.After(1 * time.Second):
-					continue
-				}
-			}
-
-			slog.ErrorContext(ctx, "Error dequeuing", slog.String("err", err.Error()))
-			tx.Discard()
-			break
-		}
-		next, err := tx.List(0, 10)
-		if err != nil {
-			slog.ErrorContext(ctx, "Error listing queue items", slog.String("err", err.Error()))
-			tx.Discard()
-			break
-		}
-		if err = tx.Commit(); err != nil {
-			slog.ErrorContext(ctx, "Error committing transaction", slog.String("err", err.Error()))
-			break
-		}
-		slog.InfoContext(ctx, "Playing next song", slog.String("member", song.UserID), slog.String("title", song.Title), slog.String("url", song.SongURL))
-
-		var username string
-		userSnowflake, err := discord.ParseSnowflake(song.UserID)
-		if err != nil {
-			slog.ErrorContext(ctx, "Unable to parse user ID", slog.String("err", err.Error()), slog.String("user_id", song.UserID))
-		} else {
-			member, err := h.s.Member(discord.GuildID(cfg.Discord.Guild), discord.UserID(userSnowflake))
-			if err != nil {
-				slog.WarnContext(ctx, "Unable to get username", slog.String("err", err.Error()), slog.String("user_id", song.UserID))
-			} else {
-				username = member.Nick
-				if username == "" {
-					username = member.User.DisplayOrUsername()
-				}
-			}
-		}
-
-		var thumbnail image.Image
-		actualThumbnail, err := downloadThumbnail(ctx, song.ThumbnailURL)
-		if err != nil {
-			thumbnail = image.Black
-			slog.WarnContext(ctx, "Unable to download thumbnail", slog.String("err", err.Error()), slog.String("url", song.ThumbnailURL))
-		} else {
-			thumbnail = actualThumbnail
-		}
-
-		hasPoster := false
-		previewLocation, err := writePreviewPoster(song, username, next, thumbnail)
-		if err != nil {
-			slog.ErrorContext(ctx, "Error writing preview poster", slog.String("err", err.Error()))
-		} else {
-			if err = mpvClient.LoadFile(ctx, previewLocation, mpv.LoadFileModeReplace); err != nil {
-				slog.ErrorContext(ctx, "Error loading preview poster file to mpv", slog.String("err", err.Error()))
-				continue
-			}
-			hasPoster = true
-		}
-
-		if err = mpvClient.Pause(ctx); err != nil {
-			slog.ErrorContext(ctx, "Error pausing mpv", slog.String("err", err.Error()))
-			continue
-		}
-
-		loadingLocation, err := writeLoadingPoster(thumbnail)
-		if err != nil {
-			slog.ErrorContext(ctx, "Error writing loading poster", slog.String("err", err.Error()))
-		} else {
-			if err = mpvClient.LoadFile(ctx, loadingLocation, mpv.LoadFileModeAppend); err != nil {
-				slog.ErrorContext(ctx, "Error sending loading poster file to mpv", slog.String("err", err.Error()))
-				continue
+// loadFileWithOptions loads a file with per-file options such as start,
+// returning its playlist entry ID, or 0 if mpv did not report one. The
+// options are passed after the playlist index, which requires mpv 0.38 or
+// newer.
+func loadFileWithOptions(ctx context.Context, media mediaBackend, file string, mode mpv.LoadFileMode, options map[string]string) (entryID int, err error) {
+	args := []any{file, string(mode)}
+	if len(options) > 0 {
+		pairs := make([]string, 0, len(options))
+		for name, value := range options {
+			if strings.ContainsAny(value, ",%") {
+				// Quote values that would otherwise be split.
+				value = fmt.Sprintf("%%%d%%%s", len(value), value)
 			}
+			pairs = append(pairs, name+"="+value)
 		}
+		slices.Sort(pairs)
+		args = append(args, -1, strings.Join(pairs, ","))
+	}
 
-		mode := mpv.LoadFileModeAppend
-		if !hasPoster {
-			mode = mpv.LoadFileModeReplace
-		}
-		if err = mpvClient.LoadFile(ctx, song.SongURL, mode); err != nil {
-			slog.ErrorContext(ctx, "Error loading song URL to mpv", slog.String("err", err.Error()))
-			continue
-		}
+	data, err := media.Command(ctx, "loadfile", args...)
+	if err != nil {
+		return 0, err
+	}
+	if result, ok := data.(map[string]any); ok {
+		id, _ := result["playlist_entry_id"].(float64)
+		entryID = int(id)
+	}
+	return entryID, nil
+}
 
-		messageContent := ""
-		if !cfg.DisablePing {
-			messageContent = fmt.Sprintf("<@%s>", song.UserID)
-		}
-		_, err = h.s.SendMessage(discord.ChannelID(cfg.Discord.Channel), messageContent, discord.Embed{
-			Title:       song.Title,
-			Description: fmt.Sprintf("Your song is up next! The song will start in %s unless started manually.", cfg.PlaybackTime),
-		})
+// endFileError returns the error reported by an end-file event for the
+// given playlist entry, if the entry failed to play.
+func endFileError(event map[string]any, entryID int) (reason string, failed bool) {
+	if entryID == 0 || event["event"] != "end-file" || event["reason"] != "error" {
+		return "", false
+	}
+	if id, _ := event["playlist_entry_id"].(float64); int(id) != entryID {
+		return "", false
+	}
+	reason, _ = event["file_error"].(string)
+	if reason == "" {
+		reason = "unknown error"
+	}
+	return reason, true
+}
 
-		if err != nil {
-			slog.ErrorContext(ctx, "Unable to send heads up message", slog.String("err", err.Error()))
-		}
+func getPropertyBool(ctx context.Context, media mediaBackend, property string) (bool, error) {
+	value, err := media.GetProperty(ctx, property)
+	if err != nil {
+		return false, err
+	}
+	b, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("mpv: property is not a bool: %v", value)
+	}
+	return b, nil
+}
 
-		// Change OSD font size
-		restoreFontSize := true
-		oldSize, err := mpvClient.GetPropertyFloat(ctx, "osd-font-size")
-		if err != nil {
-			slog.WarnContext(ctx, "Unable to get OSD font size", slog.String("err", err.Error()))
-			restoreFontSize = false
-		} else {
-			if err = mpvClient.SetProperty(ctx, "osd-font-size", 30); err != nil {
-				slog.WarnContext(ctx, "Unable to set OSD font size", slog.String("err", err.Error()))
-			}
-		}
+func getPropertyFloat(ctx context.Context, media mediaBackend, property string) (float64, error) {
+	value, err := media.GetProperty(ctx, property)
+	if err != nil {
+		return 0, err
+	}
+	f, ok := value.(float64)
+	if !ok {
+		return 0, fmt.Errorf("mpv: property is not a float: %v", value)
+	}
+	return f, nil
+}
 
-		unpausedCh := make(chan struct{})
-		unpauseCheckCtx, cancelUnpauseCheck := context.WithCancel(ctx)
-		timeLeft := cfg.PlaybackTime
-		go func() {
-			for {
-				select {
-				case <-time.After(time.Second):
-					timeLeft -= time.Second
-					paused, err := mpvClient.GetPropertyBool(ctx, "pause")
-					if err != nil {
-						slog.ErrorContext(ctx, "Unable to get pause state", slog.String("err", err.Error()))
-						continue
-					}
-					if !paused {
-						close(unpausedCh)
-						slog.DebugContext(ctx, "Detected false pause state, continuing")
-						return
-					} else {
-						showOSD(ctx, mpvClient, fmt.Sprintf("Starting in %s", timeLeft))
-					}
-				case <-unpauseCheckCtx.Done():
-					return
-				}
-			}
-		}()
-
-		select {
-		case <-unpauseCheckCtx.Done():
-			cancelUnpauseCheck()
-			slog.ErrorContext(ctx, "Context cancelled", slog.String("err", unpauseCheckCtx.Err().Error()))
-			return
-		case <-unpausedCh:
-		case <-time.After(cfg.PlaybackTime):
-			if err = mpvClient.Play(ctx); err != nil {
-				cancelUnpauseCheck()
-				slog.ErrorContext(ctx, "Unable to set pause state", slog.String("err", err.Error()))
-				continue
-			}
-		}
-		cancelUnpauseCheck()
+// formatPlaybackTime formats a position in seconds as [h:]mm:ss.
+func formatPlaybackTime(seconds float64) string {
+	d := time.Duration(max(seconds, 0)) * time.Second
+	h := int(d / time.Hour)
+	m := int(d % time.Hour / time.Minute)
+	s := int(d % time.Minute / time.Second)
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, s)
+	}
+	return fmt.Sprintf("%d:%02d", m, s)
+}
 
-		if restoreFontSize {
-			if err = mpvClient.SetProperty(ctx, "osd-font-size", oldSize); err != nil {
-				slog.ErrorContext(ctx, "Error restoring OSD font size", slog.String("err", err.Error()))
-			}
-		}
+// parseTimestamp parses a timestamp in the form of [[h:]m:]s.
+func parseTimestamp(s string) (time.Duration, error) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("invalid timestamp: %q", s)
+	}
 
-		continueCh := make(chan struct{})
-		unobserve, err := mpvClient.ObserveProperty(ctx, "idle-active", func(value any) {
-			slog.DebugContext(ctx, "Observed change in idle-active state", slog.Bool("idle-active", value.(bool)))
-			if value.(bool) {
-				close(continueCh)
-			}
-		})
-		if err != nil {
-			slog.ErrorContext(ctx, "Unable to observe idle-active property", slog.String("err", err.Error()))
-			continue
+	var seconds float64
+	for _, part := range parts {
+		n, err := strconv.ParseFloat(part, 64)
+		if err != nil || n < 0 || math.IsInf(n, 0) || math.IsNaN(n) {
+			return 0, fmt.Errorf("invalid timestamp: %q", s)
 		}
+		seconds = seconds*60 + n
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
 
-		<-continueCh
-		if err = unobserve(); err != nil {
-			slog.ErrorContext(ctx, "Unable to unobserve idle-active property", slog.String("err", err.Error()))
-		}
+// parseSeekPosition parses a seek position. Positions prefixed with a sign
+// are relative to the current position.
+func parseSeekPosition(s string) (position time.Duration, relative bool, err error) {
+	s = strings.TrimSpace(s)
+	sign := time.Duration(1)
+	switch {
+	case strings.HasPrefix(s, "+"):
+		relative = true
+		s = s[1:]
+	case strings.HasPrefix(s, "-"):
+		relative = true
+		sign = -1
+		s = s[1:]
 	}
+	position, err = parseTimestamp(s)
+	position *= sign
+	return
 }
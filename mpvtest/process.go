@@ -0,0 +1,70 @@
+//go:build unix
+
+package mpvtest
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Main runs a server configured from mpv style command line arguments
+// until it is closed or the process is interrupted. It allows a binary
+// that calls Main to be launched in place of mpv, for example by
+// mpv.NewProcessWithOptions.
+//
+// The socket is taken from --input-ipc-server. The timeline can be set
+// with --mpvtest-default-duration, --mpvtest-image-duration and
+// --mpvtest-duration=<file>=<duration>, which may be repeated. Other
+// arguments are ignored.
+func Main(args []string) error {
+	var socketPath string
+	opts := Options{Durations: make(map[string]time.Duration)}
+
+	for _, arg := range args {
+		name, value, _ := strings.Cut(arg, "=")
+		var err error
+		switch name {
+		case "--input-ipc-server":
+			socketPath = value
+		case "--mpvtest-default-duration":
+			opts.DefaultDuration, err = time.ParseDuration(value)
+		case "--mpvtest-image-duration":
+			opts.ImageDuration, err = time.ParseDuration(value)
+		case "--mpvtest-duration":
+			i := strings.LastIndex(value, "=")
+			if i < 0 {
+				err = errors.New("expected <file>=<duration>")
+				break
+			}
+			opts.Durations[value[:i]], err = time.ParseDuration(value[i+1:])
+		}
+		if err != nil {
+			return fmt.Errorf("invalid argument %q: %w", arg, err)
+		}
+	}
+
+	if socketPath == "" {
+		return errors.New("--input-ipc-server not set")
+	}
+
+	s, err := Listen(socketPath, opts)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	select {
+	case <-s.Done():
+	case <-signals:
+	}
+	return nil
+}
@@ -0,0 +1,746 @@
+//go:build unix
+
+// Package mpvtest provides a stand-in for mpv that speaks its JSON IPC
+// protocol over a Unix socket. Files are not decoded; instead each
+// playlist entry plays for a scripted duration, which allows playback to
+// be tested without a display.
+package mpvtest
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Options configure the timeline of a Server.
+type Options struct {
+	// DefaultDuration is how long files play when they are not listed
+	// in Durations.
+	DefaultDuration time.Duration
+	// ImageDuration is how long images are displayed, similar to mpv's
+	// image-display-duration option.
+	ImageDuration time.Duration
+	// Durations maps files to how long they play.
+	Durations map[string]time.Duration
+	// TickInterval is the resolution of the playback timeline.
+	TickInterval time.Duration
+}
+
+func (o *Options) applyDefaults() {
+	if o.DefaultDuration == 0 {
+		o.DefaultDuration = time.Second
+	}
+	if o.ImageDuration == 0 {
+		o.ImageDuration = time.Second
+	}
+	if o.TickInterval == 0 {
+		o.TickInterval = 10 * time.Millisecond
+	}
+}
+
+var imageExtensions = []string{".png", ".jpg", ".jpeg", ".gif", ".webp", ".bmp"}
+
+// Entry is a file that was loaded into the playlist.
+type Entry struct {
+	ID       int
+	File     string
+	Options  string
+	Duration time.Duration
+}
+
+// endPosition returns where the entry stops playing, which is its end
+// option if it has one before the end of the file.
+func (e Entry) endPosition() time.Duration {
+	if end, ok := fileOption(e.Options, "end"); ok && end < e.Duration {
+		return end
+	}
+	return e.Duration
+}
+
+// Server is a fake mpv instance listening on a Unix socket.
+type Server struct {
+	opts Options
+	ln   net.Listener
+
+	mu       sync.Mutex
+	props    map[string]any
+	playlist []Entry
+	pos      int
+	elapsed  time.Duration
+	entryID  int
+	conns    map[*conn]struct{}
+	started  []Entry
+	commands [][]any
+	osd      []string
+	overlays map[int]string
+	keys     map[string]string
+
+	closeOnce sync.Once
+	closed    chan struct{}
+	wg        sync.WaitGroup
+}
+
+type conn struct {
+	net.Conn
+	out       chan message
+	observers map[int64]string
+	// quit is set by readLoop once the client sent the quit command, so
+	// that writeLoop closes the server after sending the reply.
+	quit bool
+}
+
+type message struct {
+	b     []byte
+	event bool
+}
+
+// eventSpacing is the delay after each event written to a connection.
+// The mpv client drops events that arrive while it is still dispatching
+// the previous one, and real mpv rarely sends them back to back.
+const eventSpacing = time.Millisecond
+
+type request struct {
+	Command   []any `json:"command"`
+	RequestID int64 `json:"request_id"`
+	Async     bool  `json:"async"`
+}
+
+// errCommand is returned in the error field of a response.
+type errCommand string
+
+func (e errCommand) Error() string { return string(e) }
+
+const (
+	errInvalidParameter    = errCommand("invalid parameter")
+	errPropertyUnavailable = errCommand("property unavailable")
+	errPropertyNotFound    = errCommand("property not found")
+)
+
+// Listen starts a server on the given socket path.
+func Listen(socketPath string, opts Options) (*Server, error) {
+	opts.applyDefaults()
+
+	if err := os.Remove(socketPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	ln, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		opts: opts,
+		ln:   ln,
+		props: map[string]any{
+			"idle-active":   true,
+			"pause":         false,
+			"volume":        100.0,
+			"mute":          false,
+			"speed":         1.0,
+			"osd-duration":  1000.0,
+			"osd-font-size": 55.0,
+			"osd-width":     1920.0,
+			"osd-height":    1080.0,
+			"playlist-pos":  -1.0,
+		},
+		pos:      -1,
+		conns:    make(map[*conn]struct{}),
+		overlays: make(map[int]string),
+		keys:     make(map[string]string),
+		closed:   make(chan struct{}),
+	}
+
+	s.wg.Add(2)
+	go s.acceptLoop()
+	go s.tickLoop()
+	return s, nil
+}
+
+// Close stops the server and disconnects all clients.
+func (s *Server) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.closed)
+		err = s.ln.Close()
+		s.mu.Lock()
+		for c := range s.conns {
+			c.Close()
+		}
+		s.mu.Unlock()
+		s.wg.Wait()
+	})
+	return err
+}
+
+// Done is closed when the server has been closed, either by Close or by
+// a client sending the quit command.
+func (s *Server) Done() <-chan struct{} {
+	return s.closed
+}
+
+// Started returns the entries that started playing, in order.
+func (s *Server) Started() []Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.started)
+}
+
+// Playlist returns the entries in the current playlist.
+func (s *Server) Playlist() []Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.playlist)
+}
+
+// Commands returns all commands received by the server.
+func (s *Server) Commands() [][]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.commands)
+}
+
+// OSD returns all texts shown with show-text.
+func (s *Server) OSD() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.osd)
+}
+
+// Overlays returns the files shown with overlay-add by overlay ID.
+func (s *Server) Overlays() map[int]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return maps.Clone(s.overlays)
+}
+
+// PressKey runs the command bound to a key with keybind, as if it was
+// pressed in the mpv window. Only script-message commands are supported.
+// Returns false if the key is not bound.
+func (s *Server) PressKey(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	command, ok := s.keys[key]
+	if !ok {
+		return false
+	}
+	fields := strings.Fields(command)
+	if len(fields) == 0 || fields[0] != "script-message" {
+		return false
+	}
+	s.scriptMessage(fields[1:])
+	return true
+}
+
+// Property returns the value of a property and whether it is available.
+func (s *Server) Property(name string) (any, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.props[name]
+	return value, ok
+}
+
+// SetProperty changes a property as if it was changed by a user of the
+// mpv window, notifying observers.
+func (s *Server) SetProperty(name string, value any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setProperty(name, value)
+}
+
+func (s *Server) acceptLoop() {
+	defer s.wg.Done()
+	for {
+		nc, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		c := &conn{
+			Conn:      nc,
+			out:       make(chan message, 1024),
+			observers: make(map[int64]string),
+		}
+		s.mu.Lock()
+		select {
+		case <-s.closed:
+			s.mu.Unlock()
+			nc.Close()
+			return
+		default:
+		}
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(2)
+		go s.readLoop(c)
+		go s.writeLoop(c)
+	}
+}
+
+func (s *Server) readLoop(c *conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		close(c.out)
+	}()
+
+	scanner := bufio.NewScanner(c)
+	for scanner.Scan() {
+		var req request
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			continue
+		}
+
+		s.mu.Lock()
+		data, err := s.handle(c, req.Command)
+		response := map[string]any{
+			"request_id": req.RequestID,
+			"error":      "success",
+		}
+		if err != nil {
+			response["error"] = err.Error()
+		} else if data != nil {
+			response["data"] = data
+		}
+		s.send(c, response)
+		s.mu.Unlock()
+
+		if len(req.Command) > 0 && req.Command[0] == "quit" {
+			c.quit = true
+			return
+		}
+	}
+}
+
+func (s *Server) writeLoop(c *conn) {
+	defer s.wg.Done()
+	for m := range c.out {
+		if _, err := c.Write(m.b); err != nil {
+			c.Close()
+		}
+		if m.event {
+			time.Sleep(eventSpacing)
+		}
+	}
+	if c.quit {
+		go s.Close()
+	}
+}
+
+func (s *Server) tickLoop() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.opts.TickInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.closed:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			s.tick(s.opts.TickInterval)
+			s.mu.Unlock()
+		}
+	}
+}
+
+// tick advances the playback timeline. Must be called with s.mu held.
+func (s *Server) tick(d time.Duration) {
+	if s.pos < 0 || s.props["pause"] == true {
+		return
+	}
+	s.elapsed += d
+	s.setProperty("time-pos", s.elapsed.Seconds())
+	if s.elapsed >= s.playlist[s.pos].endPosition() {
+		s.endFile("eof")
+		s.advance()
+	}
+}
+
+// send queues a message for a connection. Must be called with s.mu held.
+func (s *Server) send(c *conn, msg map[string]any) {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	select {
+	case c.out <- message{b: append(b, '\n'), event: msg["event"] != nil}:
+	default:
+		// Drop messages for clients that are not reading, like mpv.
+	}
+}
+
+// emit sends an event to all connections. Must be called with s.mu held.
+func (s *Server) emit(event string, fields map[string]any) {
+	msg := map[string]any{"event": event}
+	for k, v := range fields {
+		msg[k] = v
+	}
+	for c := range s.conns {
+		s.send(c, msg)
+	}
+}
+
+// scriptMessage broadcasts a client-message event. Must be called with
+// s.mu held.
+func (s *Server) scriptMessage(args []string) {
+	s.emit("client-message", map[string]any{"args": args})
+}
+
+// setProperty changes a property and notifies observers. Must be called
+// with s.mu held.
+func (s *Server) setProperty(name string, value any) {
+	s.props[name] = value
+	s.notify(name)
+}
+
+// removeProperty makes a property unavailable and notifies observers.
+// Must be called with s.mu held.
+func (s *Server) removeProperty(name string) {
+	if _, ok := s.props[name]; !ok {
+		return
+	}
+	delete(s.props, name)
+	s.notify(name)
+}
+
+func (s *Server) notify(name string) {
+	for c := range s.conns {
+		for id, property := range c.observers {
+			if property == name {
+				s.send(c, s.propertyChange(id, name))
+			}
+		}
+	}
+}
+
+func (s *Server) propertyChange(id int64, name string) map[string]any {
+	msg := map[string]any{
+		"event": "property-change",
+		"id":    id,
+		"name":  name,
+	}
+	if value, ok := s.props[name]; ok {
+		msg["data"] = value
+	}
+	return msg
+}
+
+func (s *Server) duration(file, options string) time.Duration {
+	if d, ok := s.opts.Durations[file]; ok {
+		return d
+	}
+	if slices.Contains(imageExtensions, strings.ToLower(filepath.Ext(file))) {
+		if slices.Contains(strings.Split(options, ","), "image-display-duration=inf") {
+			return math.MaxInt64
+		}
+		return s.opts.ImageDuration
+	}
+	return s.opts.DefaultDuration
+}
+
+// subtitleProperty is the property holding the file of the selected
+// subtitle track, which is the last one added.
+const subtitleProperty = "current-tracks/sub/external-filename"
+
+// startFile starts playing the playlist entry at index i. Must be called
+// with s.mu held.
+func (s *Server) startFile(i int) {
+	s.pos = i
+	entry := s.playlist[i]
+	s.elapsed, _ = fileOption(entry.Options, "start")
+	s.started = append(s.started, entry)
+	s.emit("start-file", map[string]any{"playlist_entry_id": entry.ID})
+	s.setProperty("idle-active", false)
+	s.setProperty("playlist-pos", float64(i))
+	s.setProperty("path", entry.File)
+	s.setProperty("filename", filepath.Base(entry.File))
+	s.setProperty("duration", entry.Duration.Seconds())
+	s.setProperty("time-pos", s.elapsed.Seconds())
+	s.emit("file-loaded", nil)
+}
+
+// fileOption returns the position given by a per-file option of a
+// loadfile command, such as start or end. Only positions in seconds are
+// supported.
+func fileOption(options, option string) (time.Duration, bool) {
+	for _, o := range strings.Split(options, ",") {
+		name, value, _ := strings.Cut(o, "=")
+		if name != option {
+			continue
+		}
+		seconds, err := strconv.ParseFloat(value, 64)
+		if err != nil || seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds * float64(time.Second)), true
+	}
+	return 0, false
+}
+
+// endFile ends the current entry. Must be called with s.mu held.
+func (s *Server) endFile(reason string) {
+	if s.pos < 0 {
+		return
+	}
+	s.emit("end-file", map[string]any{
+		"reason":            reason,
+		"playlist_entry_id": s.playlist[s.pos].ID,
+	})
+	for _, name := range []string{"path", "filename", "duration", "time-pos", subtitleProperty} {
+		s.removeProperty(name)
+	}
+}
+
+// advance starts the entry after the current one, or goes idle at the
+// end of the playlist. Must be called with s.mu held.
+func (s *Server) advance() {
+	if s.pos+1 < len(s.playlist) {
+		s.startFile(s.pos + 1)
+		return
+	}
+	s.idle()
+}
+
+func (s *Server) idle() {
+	s.pos = -1
+	s.setProperty("playlist-pos", -1.0)
+	s.setProperty("idle-active", true)
+	s.emit("idle", nil)
+}
+
+// handle runs a command. Must be called with s.mu held.
+func (s *Server) handle(c *conn, args []any) (any, error) {
+	if len(args) == 0 {
+		return nil, errInvalidParameter
+	}
+	s.commands = append(s.commands, args)
+
+	name, _ := args[0].(string)
+	switch name {
+	case "loadfile":
+		return s.loadFile(args[1:])
+	case "stop":
+		s.endFile("stop")
+		s.playlist = nil
+		s.idle()
+		return nil, nil
+	case "playlist-next":
+		if s.pos < 0 {
+			return nil, errInvalidParameter
+		}
+		s.endFile("stop")
+		s.advance()
+		return nil, nil
+	case "playlist-clear":
+		if s.pos >= 0 {
+			s.playlist = s.playlist[s.pos : s.pos+1]
+			s.pos = 0
+		} else {
+			s.playlist = nil
+		}
+		return nil, nil
+	case "seek":
+		return nil, s.seek(args[1:])
+	case "sub-add":
+		if len(args) < 2 || s.pos < 0 {
+			return nil, errInvalidParameter
+		}
+		s.setProperty(subtitleProperty, fmt.Sprint(args[1]))
+		return nil, nil
+	case "show-text":
+		if len(args) < 2 {
+			return nil, errInvalidParameter
+		}
+		s.osd = append(s.osd, fmt.Sprint(args[1]))
+		return nil, nil
+	case "overlay-add":
+		// overlay-add <id> <x> <y> <file> <offset> <fmt> <w> <h> <stride>
+		if len(args) != 10 {
+			return nil, errInvalidParameter
+		}
+		id, ok := args[1].(float64)
+		file, ok2 := args[4].(string)
+		if !ok || !ok2 || args[6] != "bgra" {
+			return nil, errInvalidParameter
+		}
+		s.overlays[int(id)] = file
+		return nil, nil
+	case "overlay-remove":
+		if len(args) != 2 {
+			return nil, errInvalidParameter
+		}
+		id, _ := args[1].(float64)
+		delete(s.overlays, int(id))
+		return nil, nil
+	case "set_property":
+		if len(args) != 3 {
+			return nil, errInvalidParameter
+		}
+		property, _ := args[1].(string)
+		return nil, s.setPropertyCommand(property, args[2])
+	case "get_property":
+		if len(args) != 2 {
+			return nil, errInvalidParameter
+		}
+		property, _ := args[1].(string)
+		value, ok := s.props[property]
+		if !ok {
+			return nil, errPropertyUnavailable
+		}
+		return value, nil
+	case "observe_property":
+		if len(args) != 3 {
+			return nil, errInvalidParameter
+		}
+		id, ok := args[1].(float64)
+		property, ok2 := args[2].(string)
+		if !ok || !ok2 {
+			return nil, errInvalidParameter
+		}
+		c.observers[int64(id)] = property
+		s.send(c, s.propertyChange(int64(id), property))
+		return nil, nil
+	case "unobserve_property":
+		if len(args) != 2 {
+			return nil, errInvalidParameter
+		}
+		id, _ := args[1].(float64)
+		if _, ok := c.observers[int64(id)]; !ok {
+			return nil, errInvalidParameter
+		}
+		delete(c.observers, int64(id))
+		return nil, nil
+	case "keybind":
+		if len(args) != 3 {
+			return nil, errInvalidParameter
+		}
+		key, ok := args[1].(string)
+		command, ok2 := args[2].(string)
+		if !ok || !ok2 {
+			return nil, errInvalidParameter
+		}
+		s.keys[key] = command
+		return nil, nil
+	case "script-message":
+		messageArgs := make([]string, 0, len(args)-1)
+		for _, arg := range args[1:] {
+			messageArgs = append(messageArgs, fmt.Sprint(arg))
+		}
+		s.scriptMessage(messageArgs)
+		return nil, nil
+	case "client_name":
+		return "mpvtest", nil
+	case "quit":
+		return nil, nil
+	default:
+		return nil, errInvalidParameter
+	}
+}
+
+// loadFile handles loadfile <url> [<flags> [<index> [<options>]]].
+func (s *Server) loadFile(args []any) (any, error) {
+	if len(args) == 0 {
+		return nil, errInvalidParameter
+	}
+	file, ok := args[0].(string)
+	if !ok {
+		return nil, errInvalidParameter
+	}
+	mode := "replace"
+	if len(args) > 1 {
+		mode, _ = args[1].(string)
+	}
+	var options string
+	if len(args) > 3 {
+		options, _ = args[3].(string)
+	}
+
+	s.entryID++
+	entry := Entry{
+		ID:       s.entryID,
+		File:     file,
+		Options:  options,
+		Duration: s.duration(file, options),
+	}
+
+	switch mode {
+	case "replace":
+		s.endFile("stop")
+		s.playlist = []Entry{entry}
+		s.startFile(0)
+	case "append", "append-play":
+		s.playlist = append(s.playlist, entry)
+		if mode == "append-play" && s.pos < 0 {
+			s.startFile(len(s.playlist) - 1)
+		}
+	default:
+		return nil, errInvalidParameter
+	}
+
+	return map[string]any{"playlist_entry_id": entry.ID}, nil
+}
+
+func (s *Server) seek(args []any) error {
+	if s.pos < 0 {
+		return errPropertyUnavailable
+	}
+	if len(args) == 0 {
+		return errInvalidParameter
+	}
+	target, ok := args[0].(float64)
+	if !ok {
+		return errInvalidParameter
+	}
+	flags := "relative"
+	if len(args) > 1 {
+		flags, _ = args[1].(string)
+	}
+
+	position := time.Duration(target * float64(time.Second))
+	if strings.Contains(flags, "relative") {
+		position += s.elapsed
+	}
+	return s.seekTo(position)
+}
+
+func (s *Server) seekTo(position time.Duration) error {
+	position = max(position, 0)
+	if position >= s.playlist[s.pos].endPosition() {
+		s.endFile("eof")
+		s.advance()
+		return nil
+	}
+	s.elapsed = position
+	s.setProperty("time-pos", position.Seconds())
+	return nil
+}
+
+func (s *Server) setPropertyCommand(property string, value any) error {
+	switch property {
+	case "time-pos":
+		f, ok := value.(float64)
+		if !ok || s.pos < 0 {
+			return errPropertyUnavailable
+		}
+		return s.seekTo(time.Duration(f * float64(time.Second)))
+	case "idle-active", "path", "filename", "duration", "playlist-pos":
+		return errInvalidParameter
+	case "":
+		return errPropertyNotFound
+	}
+	s.setProperty(property, value)
+	return nil
+}
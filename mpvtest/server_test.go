@@ -0,0 +1,315 @@
+//go:build unix
+
+package mpvtest_test
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xoltia/mdk3/mpvtest"
+	"github.com/xoltia/mpv"
+)
+
+func openServer(t *testing.T, opts mpvtest.Options) (*mpvtest.Server, *mpv.Client) {
+	t.Helper()
+	socketPath := filepath.Join(t.TempDir(), "mpv.sock")
+	s, err := mpvtest.Listen(socketPath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	c, err := mpv.OpenClientWithOptions(mpv.ClientOptions{SocketPath: socketPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return s, c
+}
+
+func waitFor(t *testing.T, description string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", description)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func waitForValue(t *testing.T, values <-chan bool, want bool) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case v := <-values:
+			if v == want {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for %v", want)
+		}
+	}
+}
+
+func TestServerPlaysPlaylist(t *testing.T) {
+	s, c := openServer(t, mpvtest.Options{
+		ImageDuration:   20 * time.Millisecond,
+		DefaultDuration: 50 * time.Millisecond,
+	})
+	ctx := context.Background()
+
+	var mu sync.Mutex
+	var endReasons []string
+	rm := c.AddEventHandlerSync(func(event map[string]any) {
+		if event["event"] == "end-file" {
+			mu.Lock()
+			endReasons = append(endReasons, event["reason"].(string))
+			mu.Unlock()
+		}
+	})
+	defer rm()
+
+	idle := make(chan bool, 16)
+	unobserve, err := c.ObserveProperty(ctx, "idle-active", func(value any) {
+		idle <- value.(bool)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unobserve()
+
+	waitForValue(t, idle, true)
+
+	if err := c.LoadFile(ctx, "poster.png", mpv.LoadFileModeReplace); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Pause(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.LoadFile(ctx, "https://example.com/song", mpv.LoadFileModeAppend); err != nil {
+		t.Fatal(err)
+	}
+	waitForValue(t, idle, false)
+
+	time.Sleep(50 * time.Millisecond)
+	if started := s.Started(); len(started) != 1 {
+		t.Fatalf("expected paused poster to keep playing, got %v", started)
+	}
+
+	if err := c.Play(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	waitForValue(t, idle, true)
+
+	started := s.Started()
+	if len(started) != 2 || started[0].File != "poster.png" || started[1].File != "https://example.com/song" {
+		t.Errorf("unexpected started entries %v", started)
+	}
+
+	waitFor(t, "end-file events", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(endReasons) == 2
+	})
+	if endReasons[0] != "eof" || endReasons[1] != "eof" {
+		t.Errorf("expected eof reasons, got %v", endReasons)
+	}
+}
+
+func TestServerStop(t *testing.T) {
+	s, c := openServer(t, mpvtest.Options{DefaultDuration: time.Hour})
+	ctx := context.Background()
+
+	if err := c.LoadFile(ctx, "https://example.com/song", mpv.LoadFileModeReplace); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Command(ctx, "stop"); err != nil {
+		t.Fatal(err)
+	}
+
+	idle, err := c.GetIdleActive(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !idle {
+		t.Error("expected idle after stop")
+	}
+	if playlist := s.Playlist(); len(playlist) != 0 {
+		t.Errorf("expected empty playlist, got %v", playlist)
+	}
+	if _, err := c.GetPosition(ctx); err == nil {
+		t.Error("expected time-pos to be unavailable when idle")
+	}
+}
+
+func TestServerSeek(t *testing.T) {
+	_, c := openServer(t, mpvtest.Options{DefaultDuration: time.Hour})
+	ctx := context.Background()
+
+	if err := c.LoadFile(ctx, "https://example.com/song", mpv.LoadFileModeReplace); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Pause(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Command(ctx, "seek", 90, "absolute"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Command(ctx, "seek", -30, "relative"); err != nil {
+		t.Fatal(err)
+	}
+
+	position, err := c.GetPosition(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if position != 60 {
+		t.Errorf("expected position 60, got %v", position)
+	}
+}
+
+func TestServerTrimsFile(t *testing.T) {
+	s, c := openServer(t, mpvtest.Options{DefaultDuration: time.Hour})
+	ctx := context.Background()
+
+	if err := c.Pause(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Command(ctx, "loadfile", "https://example.com/song", "replace", -1, "start=30.000,end=60.000"); err != nil {
+		t.Fatal(err)
+	}
+	position, err := c.GetPosition(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if position != 30 {
+		t.Errorf("expected position 30, got %v", position)
+	}
+
+	if _, err := c.Command(ctx, "seek", 45, "absolute"); err != nil {
+		t.Fatal(err)
+	}
+	if playlist := s.Playlist(); len(playlist) != 1 {
+		t.Fatalf("expected song to keep playing before its end, got %v", playlist)
+	}
+	if _, err := c.Command(ctx, "seek", 60, "absolute"); err != nil {
+		t.Fatal(err)
+	}
+	idle, err := c.GetIdleActive(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !idle {
+		t.Error("expected song to end at its end option")
+	}
+}
+
+func TestServerOverlays(t *testing.T) {
+	s, c := openServer(t, mpvtest.Options{})
+	ctx := context.Background()
+
+	if _, err := c.Command(ctx, "overlay-add", 0, 10, 20, "countdown.bgra", 0, "bgra", 4, 4, 16); err != nil {
+		t.Fatal(err)
+	}
+	if overlays := s.Overlays(); overlays[0] != "countdown.bgra" {
+		t.Errorf("expected overlay to be shown, got %v", overlays)
+	}
+	if _, err := c.Command(ctx, "overlay-add", 1, 0, 0, "other.bgra", 0, "rgba", 4, 4, 16); err == nil {
+		t.Error("expected unsupported format to be rejected")
+	}
+	if _, err := c.Command(ctx, "overlay-remove", 0); err != nil {
+		t.Fatal(err)
+	}
+	if overlays := s.Overlays(); len(overlays) != 0 {
+		t.Errorf("expected overlay to be removed, got %v", overlays)
+	}
+}
+
+func TestServerKeyBindings(t *testing.T) {
+	s, c := openServer(t, mpvtest.Options{})
+	ctx := context.Background()
+
+	messages := make(chan []any, 1)
+	rm := c.AddEventHandlerSync(func(event map[string]any) {
+		if event["event"] == "client-message" {
+			args, _ := event["args"].([]any)
+			messages <- args
+		}
+	})
+	defer rm()
+
+	if s.PressKey("n") {
+		t.Error("expected unbound key not to run a command")
+	}
+	if _, err := c.Command(ctx, "keybind", "n", "script-message skip now"); err != nil {
+		t.Fatal(err)
+	}
+	if !s.PressKey("n") {
+		t.Fatal("expected bound key to run its command")
+	}
+	select {
+	case args := <-messages:
+		if len(args) != 2 || args[0] != "skip" || args[1] != "now" {
+			t.Errorf("expected message arguments [skip now], got %v", args)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for client message")
+	}
+}
+
+func TestServerProperties(t *testing.T) {
+	s, c := openServer(t, mpvtest.Options{})
+	ctx := context.Background()
+
+	if err := c.SetVolume(ctx, 42); err != nil {
+		t.Fatal(err)
+	}
+	volume, err := c.GetVolume(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if volume != 42 {
+		t.Errorf("expected volume 42, got %v", volume)
+	}
+
+	if _, err := c.GetProperty(ctx, "duration"); err == nil {
+		t.Error("expected duration to be unavailable when idle")
+	}
+
+	changed := make(chan any, 4)
+	unobserve, err := c.ObserveProperty(ctx, "volume", func(value any) {
+		changed <- value
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unobserve()
+	<-changed
+
+	s.SetProperty("volume", 80.0)
+	select {
+	case v := <-changed:
+		if v != 80.0 {
+			t.Errorf("expected observed volume 80, got %v", v)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for property change")
+	}
+
+	if _, err := c.Command(ctx, "show-text", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if osd := s.OSD(); len(osd) != 1 || osd[0] != "hello" {
+		t.Errorf("unexpected OSD texts %v", osd)
+	}
+
+	if _, err := c.Command(ctx, "not-a-command"); err == nil {
+		t.Error("expected error for unknown command")
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/diamondburned/arikawa/v3/api"
+	"github.com/diamondburned/arikawa/v3/discord"
+	"github.com/diamondburned/arikawa/v3/state"
+	"github.com/xoltia/mdk3/queue"
+)
+
+// discordNotifier notifies users through the Discord channel of a room.
+type discordNotifier struct {
+	s           *state.State
+	guildID     discord.GuildID
+	channelID   discord.ChannelID
+	disablePing bool
+	updates     *nowPlayingUpdates
+}
+
+func newDiscordNotifier(s *state.State, cfg config, channel discord.Snowflake) *discordNotifier {
+	return &discordNotifier{
+		s:           s,
+		guildID:     discord.GuildID(cfg.Discord.Guild),
+		channelID:   discord.ChannelID(channel),
+		disablePing: cfg.DisablePing,
+		updates:     newNowPlayingUpdates(),
+	}
+}
+
+// Run keeps the now playing message up to date until the context is
+// cancelled.
+func (n *discordNotifier) Run(ctx context.Context) {
+	var message nowPlayingMessage
+	n.updates.run(ctx, realClock{}, nowPlayingInterval, func(status nowPlayingStatus) {
+		n.sendNowPlaying(ctx, &message, status)
+	})
+}
+
+func (n *discordNotifier) displayName(ctx context.Context, userID string) string {
+	userSnowflake, err := discord.ParseSnowflake(userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Unable to parse user ID", slog.String("err", err.Error()), slog.String("user_id", userID))
+		return ""
+	}
+
+	member, err := n.s.Member(n.guildID, discord.UserID(userSnowflake))
+	if err != nil {
+		slog.WarnContext(ctx, "Unable to get username", slog.String("err", err.Error()), slog.String("user_id", userID))
+		return ""
+	}
+
+	if member.Nick != "" {
+		return member.Nick
+	}
+	return member.User.DisplayOrUsername()
+}
+
+func (n *discordNotifier) channelName(ctx context.Context) string {
+	channel, err := n.s.Channel(n.channelID)
+	if err != nil {
+		slog.WarnContext(ctx, "Unable to get channel name", slog.String("err", err.Error()))
+		return ""
+	}
+	return channel.Name
+}
+
+func (n *discordNotifier) songUpNext(ctx context.Context, song queue.QueuedSong, startIn, readyWithin time.Duration) error {
+	embed := discord.Embed{
+		Title:       song.Title,
+		Description: fmt.Sprintf("Your song is up next! The song will start in %s unless started manually.", startIn),
+	}
+	if readyWithin <= 0 {
+		_, err := n.s.SendMessage(n.channelID, n.mention(song.UserID), embed)
+		return err
+	}
+
+	embed.Description = fmt.Sprintf("Your song is up next! Let us know you're ready within %s, or your song will be moved back.", readyWithin)
+	now := time.Now().UnixMilli()
+	_, err := n.s.SendMessageComplex(n.channelID, api.SendMessageData{
+		Content: n.mention(song.UserID),
+		Embeds:  []discord.Embed{embed},
+		Components: discord.Components(
+			&discord.ButtonComponent{
+				Label:    "I'm ready",
+				Style:    discord.SuccessButtonStyle(),
+				CustomID: discord.ComponentID(fmt.Sprintf("ready:%d:%d", song.ID, now)),
+			},
+			&discord.ButtonComponent{
+				Label:    "Push me back",
+				Style:    discord.SecondaryButtonStyle(),
+				CustomID: discord.ComponentID(fmt.Sprintf("push_back:%d:%d", song.ID, now)),
+			},
+		),
+	})
+	return err
+}
+
+// mention returns the message content used to ping a user, or an empty
+// string if pings are disabled.
+func (n *discordNotifier) mention(userID string) string {
+	if n.disablePing {
+		return ""
+	}
+	return fmt.Sprintf("<@%s>", userID)
+}
+
+func (n *discordNotifier) songFailed(ctx context.Context, song queue.QueuedSong, reason string) error {
+	_, err := n.s.SendMessageComplex(n.channelID, api.SendMessageData{
+		Content: n.mention(song.UserID),
+		Embeds: []discord.Embed{{
+			Title:       song.Title,
+			Description: fmt.Sprintf("Your song could not be played: %s", reason),
+		}},
+		Components: discord.Components(&discord.ButtonComponent{
+			Label:    "Re-queue",
+			Style:    discord.PrimaryButtonStyle(),
+			CustomID: discord.ComponentID(fmt.Sprintf("requeue_failed:%d:%d", song.ID, time.Now().UnixMilli())),
+		}),
+	})
+	return err
+}
+
+func (n *discordNotifier) notice(_ context.Context, message string) error {
+	_, err := n.s.SendMessage(n.channelID, message)
+	return err
+}
+
+func (n *discordNotifier) nowPlaying(_ context.Context, status nowPlayingStatus) {
+	n.updates.push(status)
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+	"github.com/xoltia/mdk3/queue"
+)
+
+const (
+	// nowPlayingInterval is the minimum time between requests made for
+	// the now playing message, which keeps well within Discord's rate
+	// limits for a channel.
+	nowPlayingInterval = 5 * time.Second
+	// nowPlayingNext is the number of queued songs listed in the now
+	// playing message.
+	nowPlayingNext = 3
+	// progressBarWidth is the number of segments in a progress bar.
+	progressBarWidth = 16
+)
+
+// nowPlayingPhase is the playback phase shown in the now playing message.
+type nowPlayingPhase int
+
+const (
+	nowPlayingCountdown nowPlayingPhase = iota
+	nowPlayingPlaying
+	nowPlayingFinished
+)
+
+// nowPlayingStatus is the state of the current song shown in Discord.
+type nowPlayingStatus struct {
+	song  queue.QueuedSong
+	phase nowPlayingPhase
+	// startAt is when the countdown ends.
+	startAt time.Time
+	// elapsed and length are the progress through the song, not counting
+	// any part that is trimmed off.
+	elapsed time.Duration
+	length  time.Duration
+	// result describes how the song finished.
+	result string
+	next   []queue.QueuedSong
+}
+
+// nowPlayingUpdates coalesces the updates to the now playing message so
+// that only the latest status of each song is sent once the rate limit
+// allows it.
+type nowPlayingUpdates struct {
+	mu      sync.Mutex
+	pending []nowPlayingStatus
+	wake    chan struct{}
+}
+
+func newNowPlayingUpdates() *nowPlayingUpdates {
+	return &nowPlayingUpdates{wake: make(chan struct{}, 1)}
+}
+
+// push replaces the pending status of the same song, or adds it after
+// the statuses of previous songs.
+func (u *nowPlayingUpdates) push(status nowPlayingStatus) {
+	u.mu.Lock()
+	if n := len(u.pending); n > 0 && u.pending[n-1].song.ID == status.song.ID {
+		u.pending[n-1] = status
+	} else {
+		u.pending = append(u.pending, status)
+	}
+	u.mu.Unlock()
+
+	select {
+	case u.wake <- struct{}{}:
+	default:
+	}
+}
+
+// pop removes the oldest pending status.
+func (u *nowPlayingUpdates) pop() (status nowPlayingStatus, ok bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if len(u.pending) == 0 {
+		return status, false
+	}
+	status = u.pending[0]
+	u.pending = u.pending[1:]
+	return status, true
+}
+
+// run sends pending statuses until the context is cancelled, waiting the
+// given interval after each one.
+func (u *nowPlayingUpdates) run(ctx context.Context, c clock, interval time.Duration, send func(nowPlayingStatus)) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-u.wake:
+		}
+		for {
+			status, ok := u.pop()
+			if !ok {
+				break
+			}
+			send(status)
+			select {
+			case <-ctx.Done():
+				return
+			case <-c.After(interval):
+			}
+		}
+	}
+}
+
+// nowPlayingEmbed returns the embed of the now playing message.
+func nowPlayingEmbed(status nowPlayingStatus) discord.Embed {
+	embed := discord.NewEmbed()
+	embed.Title = status.song.Title
+	embed.URL = status.song.SongURL
+	if status.song.ThumbnailURL != "" {
+		embed.Thumbnail = &discord.EmbedThumbnail{URL: status.song.ThumbnailURL}
+	}
+
+	switch status.phase {
+	case nowPlayingCountdown:
+		embed.Author = &discord.EmbedAuthor{Name: "Up Next"}
+		embed.Description = fmt.Sprintf("Starting <t:%d:R>", status.startAt.Unix())
+	case nowPlayingPlaying:
+		embed.Author = &discord.EmbedAuthor{Name: "Now Playing"}
+		if status.length > 0 {
+			embed.Description = fmt.Sprintf("%s `%s / %s`",
+				progressBar(status.elapsed, status.length),
+				formatPlaybackTime(status.elapsed.Seconds()),
+				formatPlaybackTime(status.length.Seconds()))
+		} else {
+			// The length of live streams is unknown.
+			embed.Description = fmt.Sprintf("`%s`", formatPlaybackTime(status.elapsed.Seconds()))
+		}
+	case nowPlayingFinished:
+		embed.Author = &discord.EmbedAuthor{Name: "Finished"}
+		embed.Description = status.result
+	}
+
+	embed.Fields = append(embed.Fields, discord.EmbedField{
+		Name:   "Singer",
+		Value:  fmt.Sprintf("<@%s>", status.song.UserID),
+		Inline: true,
+	})
+	if status.song.IsTrimmed() {
+		embed.Fields = append(embed.Fields, discord.EmbedField{
+			Name:   "Plays",
+			Value:  formatTrim(status.song.NewSong),
+			Inline: true,
+		})
+	}
+
+	next := "The queue is empty."
+	if len(status.next) > 0 {
+		var sb strings.Builder
+		for i, song := range status.next[:min(len(status.next), nowPlayingNext)] {
+			fmt.Fprintf(&sb, "%d. %s (<@%s>)\n", i+1, song.Title, song.UserID)
+		}
+		next = sb.String()
+	}
+	embed.Fields = append(embed.Fields, discord.EmbedField{
+		Name:  "Up Next",
+		Value: next,
+	})
+	return *embed
+}
+
+// progressBar draws the progress through a song with text. The length
+// must be positive.
+func progressBar(elapsed, length time.Duration) string {
+	filled := int(float64(progressBarWidth) * min(max(float64(elapsed)/float64(length), 0), 1))
+	return strings.Repeat("▰", filled) + strings.Repeat("▱", progressBarWidth-filled)
+}
+
+// nowPlayingMessage keeps a single now playing message in a channel,
+// replacing it when the next song comes up.
+type nowPlayingMessage struct {
+	songID  int
+	message discord.MessageID
+}
+
+// sendNowPlaying creates or edits the now playing message for the status
+// of a song.
+func (n *discordNotifier) sendNowPlaying(ctx context.Context, m *nowPlayingMessage, status nowPlayingStatus) {
+	embed := nowPlayingEmbed(status)
+	if m.message.IsValid() && m.songID == status.song.ID {
+		_, err := n.s.EditEmbeds(n.channelID, m.message, embed)
+		if err == nil {
+			return
+		}
+		slog.WarnContext(ctx, "Unable to edit now playing message", slog.String("err", err.Error()))
+	}
+
+	if m.message.IsValid() {
+		if err := n.s.DeleteMessage(n.channelID, m.message, ""); err != nil {
+			slog.WarnContext(ctx, "Unable to delete now playing message", slog.String("err", err.Error()))
+		}
+		m.message = 0
+	}
+	message, err := n.s.SendEmbeds(n.channelID, embed)
+	if err != nil {
+		slog.ErrorContext(ctx, "Unable to send now playing message", slog.String("err", err.Error()))
+		return
+	}
+	m.songID = status.song.ID
+	m.message = message.ID
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xoltia/mdk3/queue"
+)
+
+func TestNowPlayingUpdatesCoalesce(t *testing.T) {
+	u := newNowPlayingUpdates()
+	first := queue.QueuedSong{ID: 1}
+	second := queue.QueuedSong{ID: 2}
+	u.push(nowPlayingStatus{song: first, phase: nowPlayingCountdown})
+	u.push(nowPlayingStatus{song: first, phase: nowPlayingPlaying})
+	u.push(nowPlayingStatus{song: first, phase: nowPlayingFinished})
+	u.push(nowPlayingStatus{song: second, phase: nowPlayingCountdown})
+
+	expected := []nowPlayingStatus{
+		{song: first, phase: nowPlayingFinished},
+		{song: second, phase: nowPlayingCountdown},
+	}
+	for _, e := range expected {
+		status, ok := u.pop()
+		if !ok || status.song.ID != e.song.ID || status.phase != e.phase {
+			t.Errorf("expected song %d in phase %d, got %v", e.song.ID, e.phase, status)
+		}
+	}
+	if status, ok := u.pop(); ok {
+		t.Errorf("expected no more updates, got %v", status)
+	}
+}
+
+func TestNowPlayingUpdatesWaitBetweenRequests(t *testing.T) {
+	u := newNowPlayingUpdates()
+	clock := newFakeClock()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sent := make(chan nowPlayingStatus, 10)
+	go u.run(ctx, clock, nowPlayingInterval, func(status nowPlayingStatus) {
+		sent <- status
+	})
+
+	song := queue.QueuedSong{ID: 1}
+	u.push(nowPlayingStatus{song: song, phase: nowPlayingCountdown})
+	if status := <-sent; status.phase != nowPlayingCountdown {
+		t.Errorf("expected countdown, got %v", status)
+	}
+	u.push(nowPlayingStatus{song: song, phase: nowPlayingPlaying, elapsed: time.Second})
+	u.push(nowPlayingStatus{song: song, phase: nowPlayingPlaying, elapsed: 2 * time.Second})
+	select {
+	case status := <-sent:
+		t.Fatalf("expected update to wait, got %v", status)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	for clock.pendingTimers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(nowPlayingInterval)
+	if status := <-sent; status.elapsed != 2*time.Second {
+		t.Errorf("expected latest progress, got %v", status)
+	}
+}
+
+func TestNowPlayingEmbed(t *testing.T) {
+	song := queue.QueuedSong{ID: 1, NewSong: queue.NewSong{UserID: "7", Title: "Song", Duration: 4 * time.Minute}}
+	next := []queue.QueuedSong{
+		{ID: 2, NewSong: queue.NewSong{UserID: "8", Title: "Second"}},
+		{ID: 3, NewSong: queue.NewSong{UserID: "9", Title: "Third"}},
+		{ID: 4, NewSong: queue.NewSong{UserID: "8", Title: "Fourth"}},
+		{ID: 5, NewSong: queue.NewSong{UserID: "9", Title: "Fifth"}},
+	}
+	embed := nowPlayingEmbed(nowPlayingStatus{
+		song:    song,
+		phase:   nowPlayingPlaying,
+		elapsed: time.Minute,
+		length:  song.Length(),
+		next:    next,
+	})
+
+	if embed.Author == nil || embed.Author.Name != "Now Playing" {
+		t.Errorf("expected now playing, got %v", embed.Author)
+	}
+	if expected := "▰▰▰▰▱▱▱▱▱▱▱▱▱▱▱▱ `1:00 / 4:00`"; embed.Description != expected {
+		t.Errorf("expected description %q, got %q", expected, embed.Description)
+	}
+	upNext := embed.Fields[len(embed.Fields)-1].Value
+	if !strings.Contains(upNext, "3. Fourth (<@8>)") || strings.Contains(upNext, "Fifth") {
+		t.Errorf("expected three songs up next, got %q", upNext)
+	}
+}
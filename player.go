@@ -0,0 +1,1750 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"log/slog"
+	"maps"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xoltia/mdk3/queue"
+	"github.com/xoltia/mpv"
+)
+
+// clock provides the current time and timers to the player.
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// playerNotifier notifies users about the songs handled by the player.
+type playerNotifier interface {
+	// displayName returns the name shown for a user, or an empty string
+	// if it cannot be found.
+	displayName(ctx context.Context, userID string) string
+	// songUpNext tells the requester that their song will start in the
+	// given duration unless started manually. If readyWithin is set, the
+	// requester is asked to confirm they are ready within that time.
+	songUpNext(ctx context.Context, song queue.QueuedSong, startIn, readyWithin time.Duration) error
+	// songFailed tells the requester that their song could not be played
+	// and offers to queue it again.
+	songFailed(ctx context.Context, song queue.QueuedSong, reason string) error
+	// notice sends a message to everyone following the queue.
+	notice(ctx context.Context, message string) error
+	// channelName returns the name of the channel songs are requested
+	// in, or an empty string if it cannot be found.
+	channelName(ctx context.Context) string
+	// nowPlaying updates the message following the current song through
+	// its playback phases. Updates may be delayed and coalesced, so it
+	// does not block.
+	nowPlaying(ctx context.Context, status nowPlayingStatus)
+}
+
+type nopNotifier struct{}
+
+func (nopNotifier) displayName(context.Context, string) string { return "" }
+func (nopNotifier) songUpNext(context.Context, queue.QueuedSong, time.Duration, time.Duration) error {
+	return nil
+}
+func (nopNotifier) songFailed(context.Context, queue.QueuedSong, string) error {
+	return nil
+}
+func (nopNotifier) notice(context.Context, string) error         { return nil }
+func (nopNotifier) channelName(context.Context) string           { return "" }
+func (nopNotifier) nowPlaying(context.Context, nowPlayingStatus) {}
+
+// posterRenderer renders the images shown in mpv before a song starts.
+type posterRenderer interface {
+	renderPreview(song queue.QueuedSong, username string, nextSongs []queue.QueuedSong, thumbnail image.Image) (string, error)
+	renderLoading(thumbnail image.Image) (string, error)
+	renderIdle(screen idleScreen) (string, error)
+	// renderCountdown renders a frame of the countdown overlay, with left
+	// out of total time before the song starts.
+	renderCountdown(username string, left, total time.Duration, frame int) (overlayImage, error)
+	// renderAudioPoster renders the preview poster as an overlay filling
+	// a screen of the given size, shown while a song plays without video.
+	renderAudioPoster(song queue.QueuedSong, username string, nextSongs []queue.QueuedSong, thumbnail image.Image, width, height int) (overlayImage, error)
+	// renderProgress renders a frame of the progress bar overlay shown
+	// over the audio poster.
+	renderProgress(elapsed, length time.Duration, width, frame int) (overlayImage, error)
+}
+
+// mediaSource provides local copies of songs that are played instead of
+// streaming them.
+type mediaSource interface {
+	localFile(url string) (path string, ok bool)
+}
+
+// errNoShow is returned by the countdown when the singer did not confirm
+// that they are ready in time.
+var errNoShow = errors.New("singer did not show up")
+
+// errNotCurrentSong is returned when acting on a song that is no longer
+// being played.
+var errNotCurrentSong = errors.New("not the current song")
+
+// errNothingPlaying is returned when acting on the current song while
+// there is none.
+var errNothingPlaying = errors.New("no song is being played")
+
+// defaultFallbackFormat is the format requested from yt-dlp when a song
+// fails to load with the default one. Pre-merged formats avoid failures
+// in merging separate video and audio streams.
+const defaultFallbackFormat = "best"
+
+// Player dequeues songs and plays them on a media backend. Each song is
+// preceded by a preview poster and a countdown that can be interrupted by
+// unpausing the player manually.
+type Player struct {
+	q              *queue.Queue
+	media          mediaBackend
+	clock          clock
+	notifier       playerNotifier
+	posters        posterRenderer
+	source         mediaSource
+	fetchThumbnail func(ctx context.Context, url string) (image.Image, error)
+	playbackTime   time.Duration
+	pollInterval   time.Duration
+	enabled        atomic.Bool
+	current        currentSong
+	backend        *backendState
+	autoResume     bool
+	interrupted    interruptedSong
+	fallbackFormat string
+	maxPlayTime    time.Duration
+	readyTimeout   time.Duration
+	pushBackBy     int
+	maxNoShows     int
+	hotkeys        map[hotkeyAction]string
+	extendBy       time.Duration
+	audioOnly      bool
+	music          *idleMusic
+	announcer      announcer
+	// idleShown is the state shown on the idle screen, only used by Run.
+	idleShown idleKey
+
+	// propsMu guards props, the properties that are applied again when
+	// the backend restarts.
+	propsMu sync.Mutex
+	props   map[string]any
+}
+
+type playerOption func(*Player)
+
+func withClock(c clock) playerOption {
+	return func(p *Player) {
+		p.clock = c
+	}
+}
+
+func withNotifier(n playerNotifier) playerOption {
+	return func(p *Player) {
+		p.notifier = n
+	}
+}
+
+func withPosterRenderer(r posterRenderer) playerOption {
+	return func(p *Player) {
+		p.posters = r
+	}
+}
+
+// withMediaSource makes the player play local copies of songs when they
+// are available.
+func withMediaSource(s mediaSource) playerOption {
+	return func(p *Player) {
+		p.source = s
+	}
+}
+
+func withThumbnailFetcher(f func(ctx context.Context, url string) (image.Image, error)) playerOption {
+	return func(p *Player) {
+		p.fetchThumbnail = f
+	}
+}
+
+func withCountdown(d time.Duration) playerOption {
+	return func(p *Player) {
+		p.playbackTime = d
+	}
+}
+
+// withAutoResume makes the player resume a song that was interrupted by
+// a restart before dequeuing the next one.
+func withAutoResume(enabled bool) playerOption {
+	return func(p *Player) {
+		p.autoResume = enabled
+	}
+}
+
+// withMaxPlayTime limits how long a song plays before it is faded out,
+// unless it is exempt. Zero means no limit.
+func withMaxPlayTime(d time.Duration) playerOption {
+	return func(p *Player) {
+		p.maxPlayTime = d
+	}
+}
+
+// withReadyCheck makes singers confirm that they are ready within the
+// timeout of the heads up. Songs of singers that do not are moved back by
+// pushBack positions, or dropped once they missed maxNoShows turns.
+func withReadyCheck(timeout time.Duration, pushBack, maxNoShows int) playerOption {
+	return func(p *Player) {
+		p.readyTimeout = timeout
+		p.pushBackBy = pushBack
+		p.maxNoShows = maxNoShows
+	}
+}
+
+// withAudioOnly plays every song without video, as if each was requested
+// to be audio only.
+func withAudioOnly(enabled bool) playerOption {
+	return func(p *Player) {
+		p.audioOnly = enabled
+	}
+}
+
+func newPlayer(q *queue.Queue, media mediaBackend, options ...playerOption) *Player {
+	p := &Player{
+		q:              q,
+		media:          media,
+		clock:          realClock{},
+		notifier:       nopNotifier{},
+		posters:        newFilePosterRenderer(os.TempDir()),
+		fetchThumbnail: downloadThumbnail,
+		playbackTime:   30 * time.Second,
+		pollInterval:   time.Second,
+		fallbackFormat: defaultFallbackFormat,
+		backend:        newBackendState(),
+		props:          map[string]any{"osd-duration": 1100},
+	}
+
+	for _, opt := range options {
+		opt(p)
+	}
+
+	return p
+}
+
+// SetDequeueEnabled enables or disables dequeuing of songs, returning
+// the previous value. Disabling dequeuing does not stop the current song.
+func (p *Player) SetDequeueEnabled(enabled bool) bool {
+	return p.enabled.Swap(enabled)
+}
+
+// ToggleDequeue enables dequeuing if it is disabled and the other way
+// around, returning whether it is now enabled.
+func (p *Player) ToggleDequeue() bool {
+	for {
+		enabled := p.enabled.Load()
+		if p.enabled.CompareAndSwap(enabled, !enabled) {
+			return !enabled
+		}
+	}
+}
+
+// Current returns the song being played, or nil if there is none.
+func (p *Player) Current() *queue.QueuedSong {
+	return p.current.get()
+}
+
+// Skip stops the current song and returns it. Returns nil if there is
+// no song to skip.
+func (p *Player) Skip() *queue.QueuedSong {
+	return p.current.skipSong()
+}
+
+// ExtendCountdown gives the singer of the current song more time before
+// it starts. Returns false if its countdown is not running.
+func (p *Player) ExtendCountdown(d time.Duration) bool {
+	return p.current.extendCountdown(p.clock.Now(), d)
+}
+
+// Ready confirms that the singer of the song with the given ID is ready.
+// Returns false if it is not the current song.
+func (p *Player) Ready(songID int) bool {
+	return p.current.markReady(songID)
+}
+
+// PushBack moves the current song back in the queue at the request of
+// its singer and skips it, returning the requeued song.
+func (p *Player) PushBack(songID int) (queue.QueuedSong, error) {
+	if current := p.Current(); current == nil || current.ID != songID {
+		return queue.QueuedSong{}, errNotCurrentSong
+	}
+	return p.requeueCurrent(songID, p.pushBackBy)
+}
+
+// RequeueCurrent puts the current song back at the head of the queue and
+// skips it, so that it is played again from the start. Returns
+// errNothingPlaying if there is no current song.
+func (p *Player) RequeueCurrent() (queue.QueuedSong, error) {
+	current := p.Current()
+	if current == nil {
+		return queue.QueuedSong{}, errNothingPlaying
+	}
+	return p.requeueCurrent(current.ID, 0)
+}
+
+// requeueCurrent moves the current song to a position in the queue and
+// skips it.
+func (p *Player) requeueCurrent(songID, position int) (queue.QueuedSong, error) {
+	tx := p.q.BeginTxn(true)
+	defer tx.Discard()
+	requeued, err := tx.RequeueAt(songID, position)
+	if err != nil {
+		return queue.QueuedSong{}, err
+	}
+	if err = tx.Commit(); err != nil {
+		return queue.QueuedSong{}, err
+	}
+	p.current.skipID(songID)
+	return requeued, nil
+}
+
+func (p *Player) Pause(ctx context.Context) error {
+	return p.media.SetProperty(ctx, "pause", true)
+}
+
+func (p *Player) Resume(ctx context.Context) error {
+	return p.media.SetProperty(ctx, "pause", false)
+}
+
+// Seek changes the playback position, relative to the current position
+// if relative is set.
+func (p *Player) Seek(ctx context.Context, position time.Duration, relative bool) error {
+	flag := mpv.SeekFlagAbsolute
+	if relative {
+		flag = mpv.SeekFlagRelative
+	}
+	_, err := p.media.Command(ctx, "seek", position.Seconds(), string(flag))
+	return err
+}
+
+func (p *Player) SetVolume(ctx context.Context, volume float64) error {
+	if err := p.media.SetProperty(ctx, "volume", volume); err != nil {
+		return err
+	}
+	p.propsMu.Lock()
+	p.props["volume"] = volume
+	p.propsMu.Unlock()
+	return nil
+}
+
+// RequeueInterrupted puts the song that was interrupted by a restart back
+// at the head of the queue. Returns errNothingInterrupted if there is no
+// such song. The song is kept if it could not be requeued, so that it can
+// be tried again.
+func (p *Player) RequeueInterrupted() (requeued queue.QueuedSong, err error) {
+	// Taking the song keeps the player from resuming it meanwhile.
+	song, position, ok := p.interrupted.take()
+	if !ok {
+		return queue.QueuedSong{}, errNothingInterrupted
+	}
+	defer func() {
+		if err != nil {
+			p.interrupted.set(song, position)
+		}
+	}()
+
+	tx := p.q.BeginTxn(true)
+	defer tx.Discard()
+
+	requeued, err = tx.RequeueAtHead(song.ID)
+	if err != nil {
+		return queue.QueuedSong{}, err
+	}
+	if np, err := tx.NowPlaying(); err == nil && np.SongID == song.ID {
+		if err = tx.ClearNowPlaying(); err != nil {
+			return queue.QueuedSong{}, err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return queue.QueuedSong{}, err
+	}
+	return requeued, nil
+}
+
+// MediaExited makes the player wait for the backend to be restarted.
+func (p *Player) MediaExited(ctx context.Context, requested bool) {
+	p.backend.setExited()
+	if requested {
+		return
+	}
+	if err := p.notifier.notice(ctx, "The player stopped unexpectedly and is being restarted."); err != nil {
+		slog.ErrorContext(ctx, "Unable to send notice", slog.String("err", err.Error()))
+	}
+}
+
+// MediaRestarted restores the properties and key bindings of the
+// restarted backend and continues the current song from its last known
+// position.
+func (p *Player) MediaRestarted(ctx context.Context) {
+	p.applyProperties(ctx)
+	p.bindHotkeys(ctx)
+	p.backend.setRunning()
+
+	message := "The player is back."
+	if song := p.Current(); song != nil {
+		message = fmt.Sprintf("The player is back, continuing %s.", song.Title)
+	}
+	if err := p.notifier.notice(ctx, message); err != nil {
+		slog.ErrorContext(ctx, "Unable to send notice", slog.String("err", err.Error()))
+	}
+}
+
+// applyProperties sets the properties that the backend loses when it
+// restarts.
+func (p *Player) applyProperties(ctx context.Context) {
+	p.propsMu.Lock()
+	props := maps.Clone(p.props)
+	p.propsMu.Unlock()
+
+	for name, value := range props {
+		if err := p.media.SetProperty(ctx, name, value); err != nil {
+			slog.ErrorContext(ctx, "Failed to set property", slog.String("property", name), slog.String("err", err.Error()))
+		}
+	}
+}
+
+// Status describes the current playback state.
+func (p *Player) Status(ctx context.Context) (string, error) {
+	volume, err := getPropertyFloat(ctx, p.media, "volume")
+	if err != nil {
+		return "", err
+	}
+
+	idle, err := getPropertyBool(ctx, p.media, "idle-active")
+	if err != nil {
+		return "", err
+	}
+	if idle {
+		return fmt.Sprintf("Idle, volume %.0f%%.", volume), nil
+	}
+
+	paused, err := getPropertyBool(ctx, p.media, "pause")
+	if err != nil {
+		return "", err
+	}
+
+	state := "Playing"
+	if paused {
+		state = "Paused"
+	}
+	if song := p.Current(); song != nil {
+		state += " " + song.Title
+	}
+
+	// Position and duration are unavailable while a file is loading.
+	position, err := getPropertyFloat(ctx, p.media, "time-pos")
+	if err != nil {
+		return fmt.Sprintf("%s, volume %.0f%%.", state, volume), nil
+	}
+	duration, err := getPropertyFloat(ctx, p.media, "duration")
+	if err != nil {
+		return fmt.Sprintf("%s at %s, volume %.0f%%.", state, formatPlaybackTime(position), volume), nil
+	}
+	return fmt.Sprintf("%s at %s / %s, volume %.0f%%.", state, formatPlaybackTime(position), formatPlaybackTime(duration), volume), nil
+}
+
+// Run plays songs from the queue until the context is cancelled or the
+// queue cannot be read.
+func (p *Player) Run(ctx context.Context) error {
+	p.applyProperties(ctx)
+	p.bindHotkeys(ctx)
+	defer p.watchHotkeys(ctx)()
+	if err := p.loadInterrupted(ctx); err != nil {
+		return err
+	}
+
+	for {
+		if ctx.Err() != nil || !p.waitRunning(ctx) {
+			return nil
+		}
+
+		if !p.enabled.Load() {
+			p.showIdle(ctx, true)
+			if !p.sleep(ctx, p.pollInterval) {
+				return nil
+			}
+			continue
+		}
+
+		if p.autoResume {
+			if song, position, ok := p.interrupted.take(); ok {
+				next, err := p.list()
+				if err != nil {
+					return err
+				}
+				slog.InfoContext(ctx, "Resuming interrupted song", slog.String("title", song.Title), slog.Duration("position", position))
+				p.playSong(ctx, song, next, position)
+				continue
+			}
+		}
+
+		song, next, err := p.dequeue()
+		if errors.Is(err, queue.ErrQueueEmpty) {
+			p.showIdle(ctx, false)
+			if !p.sleep(ctx, p.pollInterval) {
+				return nil
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		p.playSong(ctx, song, next, 0)
+	}
+}
+
+// loadInterrupted looks for a song that was being played when the player
+// last stopped. It is either resumed, or an admin is told about it.
+func (p *Player) loadInterrupted(ctx context.Context) error {
+	tx := p.q.BeginTxn(false)
+	defer tx.Discard()
+
+	np, err := tx.NowPlaying()
+	if errors.Is(err, queue.ErrNothingPlaying) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading song being played: %w", err)
+	}
+	song, err := tx.GetByID(np.SongID)
+	if err != nil {
+		slog.WarnContext(ctx, "Unable to find interrupted song", slog.Int("id", np.SongID), slog.String("err", err.Error()))
+		return nil
+	}
+
+	var position time.Duration
+	if np.Phase == queue.PhasePlaying {
+		position = np.Position
+	}
+	p.interrupted.set(song, position)
+	slog.InfoContext(ctx, "Found interrupted song", slog.String("title", song.Title), slog.Duration("position", position))
+
+	if p.autoResume {
+		return nil
+	}
+	message := fmt.Sprintf("%s was interrupted by a restart. Use /requeue to put it back at the front of the queue.", song.Title)
+	if err := p.notifier.notice(ctx, message); err != nil {
+		slog.ErrorContext(ctx, "Unable to send notice", slog.String("err", err.Error()))
+	}
+	return nil
+}
+
+// waitRunning waits for the backend to be running, returning false if
+// the context was cancelled first.
+func (p *Player) waitRunning(ctx context.Context) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-p.backend.running():
+		return true
+	}
+}
+
+// sleep waits for the given duration, returning false if the context
+// was cancelled first.
+func (p *Player) sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-p.clock.After(d):
+		return true
+	}
+}
+
+// dequeue removes the head song from the queue and records it as being
+// played, also returning the songs queued after it.
+func (p *Player) dequeue() (song queue.QueuedSong, next []queue.QueuedSong, err error) {
+	tx := p.q.BeginTxn(true)
+	defer tx.Discard()
+
+	song, err = tx.Dequeue()
+	if err != nil {
+		return
+	}
+	err = tx.SetNowPlaying(queue.NowPlaying{SongID: song.ID, Phase: queue.PhaseCountdown})
+	if err != nil {
+		err = fmt.Errorf("error recording song being played: %w", err)
+		return
+	}
+	next, err = tx.List(0, 10)
+	if err != nil {
+		err = fmt.Errorf("error listing queue items: %w", err)
+		return
+	}
+	if err = tx.Commit(); err != nil {
+		err = fmt.Errorf("error committing transaction: %w", err)
+	}
+	return
+}
+
+// list returns the songs at the front of the queue.
+func (p *Player) list() ([]queue.QueuedSong, error) {
+	tx := p.q.BeginTxn(false)
+	defer tx.Discard()
+	next, err := tx.List(0, 10)
+	if err != nil {
+		return nil, fmt.Errorf("error listing queue items: %w", err)
+	}
+	return next, nil
+}
+
+// saveNowPlaying records the progress of the current song.
+func (p *Player) saveNowPlaying(ctx context.Context, np queue.NowPlaying) {
+	tx := p.q.BeginTxn(true)
+	defer tx.Discard()
+	err := tx.SetNowPlaying(np)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Unable to record song being played", slog.String("err", err.Error()))
+	}
+}
+
+// clearNowPlaying removes the record of the current song once it has
+// been handled.
+func (p *Player) clearNowPlaying(ctx context.Context) {
+	tx := p.q.BeginTxn(true)
+	defer tx.Discard()
+	err := tx.ClearNowPlaying()
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Unable to clear song being played", slog.String("err", err.Error()))
+	}
+}
+
+// recordTimes updates the play times recorded for a song, which the ETA
+// of queued songs is estimated from.
+func (p *Player) recordTimes(ctx context.Context, songID int, f func(*queue.PlayTimes)) {
+	tx := p.q.BeginTxn(true)
+	defer tx.Discard()
+	_, err := tx.UpdateTimes(songID, f)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Unable to record play times", slog.String("err", err.Error()))
+	}
+}
+
+// reportNowPlaying sends the status of the current song to the notifier
+// along with the songs queued after it.
+func (p *Player) reportNowPlaying(ctx context.Context, song queue.QueuedSong, phase nowPlayingPhase, result string) {
+	length := song.Adjustments.PlayTime(song.Length())
+	status := nowPlayingStatus{song: song, phase: phase, length: length, result: result}
+	switch phase {
+	case nowPlayingCountdown:
+		status.startAt = p.current.countdownDeadline()
+	case nowPlayingPlaying:
+		status.elapsed = song.Adjustments.PlayTime(max(p.current.position()-song.Start, 0))
+		if left, limited := p.current.playTimeLeft(song.Start, p.maxPlayTime); p.maxPlayTime > 0 && limited && status.length > 0 {
+			status.length = min(status.length, status.elapsed+left)
+		}
+	}
+	next, err := p.list()
+	if err != nil {
+		slog.ErrorContext(ctx, "Unable to list songs for now playing message", slog.String("err", err.Error()))
+	}
+	status.next = next[:min(len(next), nowPlayingNext)]
+	p.notifier.nowPlaying(ctx, status)
+}
+
+// playSong shows the preview of a dequeued song, counts down and plays it,
+// returning once the song has finished or was skipped. Playback begins
+// at the given start position. If the backend exits on the way, the song
+// is loaded again once it has restarted.
+//
+// The song is recorded as being played until it has been handled, or
+// until the context is cancelled so that it can be resumed later.
+func (p *Player) playSong(ctx context.Context, song queue.QueuedSong, next []queue.QueuedSong, start time.Duration) {
+	slog.InfoContext(ctx, "Playing next song", slog.String("member", song.UserID), slog.String("title", song.Title), slog.String("url", song.SongURL))
+	// Loading the song replaces any idle music.
+	p.stopIdleMusic()
+
+	songCtx, skip := context.WithCancel(ctx)
+	p.current.set(&song, skip)
+	p.current.setPosition(start)
+	defer p.current.reset()
+	if p.readyTimeout <= 0 || start > song.Start {
+		// The singer was there when the song was interrupted.
+		p.current.markReady(song.ID)
+	}
+
+	events, stopWatching := p.watchSong()
+	defer stopWatching()
+	result := "Finished playing."
+	defer func() {
+		if ctx.Err() != nil {
+			p.saveNowPlaying(context.WithoutCancel(ctx), p.current.nowPlaying())
+			return
+		}
+		p.clearNowPlaying(ctx)
+		now := p.clock.Now()
+		p.recordTimes(ctx, song.ID, func(times *queue.PlayTimes) {
+			times.Ended = now
+		})
+		p.reportNowPlaying(ctx, song, nowPlayingFinished, result)
+	}()
+
+	for first := true; ; first = false {
+		exited := p.backend.exited()
+		started, err := p.startSong(ctx, songCtx, song, next, exited, first)
+		if isClosed(exited) {
+			if !p.waitRunning(songCtx) {
+				return
+			}
+			continue
+		}
+		if errors.Is(err, errNoShow) {
+			slog.InfoContext(ctx, "Singer did not show up", slog.String("member", song.UserID), slog.String("title", song.Title))
+			stopMedia(ctx, p.media)
+			result = p.noShow(ctx, song)
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Unable to start song", slog.String("err", err.Error()))
+			result = "Could not be started."
+			return
+		}
+		if !started {
+			if ctx.Err() == nil {
+				slog.InfoContext(ctx, "Song skipped during countdown", slog.String("title", song.Title))
+				result = "Skipped before it started."
+				stopMedia(ctx, p.media)
+			}
+			return
+		}
+		break
+	}
+	p.current.setPhase(queue.PhasePlaying)
+	p.saveNowPlaying(ctx, p.current.nowPlaying())
+	now := p.clock.Now()
+	p.recordTimes(ctx, song.ID, func(times *queue.PlayTimes) {
+		// Resumed songs keep the time they first started.
+		if times.Started.IsZero() {
+			times.Started = now
+		}
+	})
+	p.reportNowPlaying(ctx, song, nowPlayingPlaying, "")
+	if properties := p.current.changedProperties(); len(properties) > 0 {
+		// The song was adjusted after it was loaded.
+		p.setProperties(ctx, properties)
+	}
+
+	fallback := false
+	for reload := false; ; reload = true {
+		exited := p.backend.exited()
+		var err error
+		if reload {
+			err = p.reloadSong(ctx, song, fallback)
+		}
+		if err == nil {
+			err = p.waitIdle(ctx, songCtx, song, exited, events)
+		}
+		if isClosed(exited) {
+			if !p.waitRunning(songCtx) {
+				return
+			}
+			continue
+		}
+		var failure *loadError
+		if errors.As(err, &failure) {
+			if !fallback {
+				slog.WarnContext(ctx, "Song failed to load, retrying with fallback format", slog.String("title", song.Title), slog.String("reason", failure.reason))
+				fallback = true
+				continue
+			}
+			p.songFailed(ctx, song, failure.reason)
+			result = fmt.Sprintf("Could not be played: %s", failure.reason)
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Unable to wait for song to finish", slog.String("err", err.Error()))
+			result = "Stopped."
+			return
+		}
+		break
+	}
+
+	if songCtx.Err() != nil && ctx.Err() == nil {
+		slog.InfoContext(ctx, "Song skipped", slog.String("title", song.Title))
+		result = "Skipped."
+		stopMedia(ctx, p.media)
+	}
+}
+
+// startSong loads a song and counts down to it, notifying the requester
+// if notify is set.
+func (p *Player) startSong(ctx, songCtx context.Context, song queue.QueuedSong, next []queue.QueuedSong, exited <-chan struct{}, notify bool) (started bool, err error) {
+	username := p.notifier.displayName(ctx, song.UserID)
+	if err := p.loadSong(ctx, song, username, next, p.current.position()); err != nil {
+		return false, err
+	}
+
+	if notify {
+		var readyWithin time.Duration
+		if !p.current.isReady() {
+			readyWithin = max(p.readyTimeout, p.playbackTime)
+		}
+		if err := p.notifier.songUpNext(ctx, song, p.playbackTime, readyWithin); err != nil {
+			slog.ErrorContext(ctx, "Unable to send heads up message", slog.String("err", err.Error()))
+		}
+	}
+
+	return p.countdown(ctx, songCtx, song, username, exited)
+}
+
+// noShow moves a song whose singer did not show up back in the queue, or
+// drops it once they missed too many turns, returning what happened.
+func (p *Player) noShow(ctx context.Context, song queue.QueuedSong) string {
+	tx := p.q.BeginTxn(true)
+	defer tx.Discard()
+
+	song, err := tx.RecordNoShow(song.ID)
+	dropped := err == nil && p.maxNoShows > 0 && song.NoShows >= p.maxNoShows
+	if err == nil && !dropped {
+		_, err = tx.RequeueAt(song.ID, p.pushBackBy)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Unable to record no-show", slog.String("err", err.Error()))
+		return "The singer did not show up."
+	}
+
+	message := fmt.Sprintf("%s was moved back in the queue because its singer did not show up.", song.Title)
+	result := "Moved back in the queue because the singer did not show up."
+	if dropped {
+		message = fmt.Sprintf("%s was removed after its singer missed %d turns.", song.Title, song.NoShows)
+		result = "Removed because the singer did not show up."
+	}
+	if err := p.notifier.notice(ctx, message); err != nil {
+		slog.ErrorContext(ctx, "Unable to send notice", slog.String("err", err.Error()))
+	}
+	return result
+}
+
+// songEvents are the backend events concerning the current song.
+type songEvents struct {
+	// failed receives the reason the song failed to load.
+	failed <-chan string
+	// loaded receives the playlist entry ID of the last file loaded.
+	loaded <-chan int
+}
+
+// watchSong watches the backend events for the current song until stop
+// is called.
+func (p *Player) watchSong() (events songEvents, stop func()) {
+	failed := make(chan string, 1)
+	loaded := make(chan int, 1)
+	var starting atomic.Int64
+	// The handler runs before observers are notified, so a failure is
+	// seen before the backend reports being idle.
+	stop = p.media.AddEventHandlerSync(func(event map[string]any) {
+		switch event["event"] {
+		case "start-file":
+			id, _ := event["playlist_entry_id"].(float64)
+			starting.Store(int64(id))
+		case "file-loaded":
+			// Only the latest file matters. The entry ID of the song may
+			// not be known yet, so it is compared by the receiver.
+			select {
+			case <-loaded:
+			default:
+			}
+			loaded <- int(starting.Load())
+		}
+		if reason, ok := endFileError(event, p.current.entryID()); ok {
+			select {
+			case failed <- reason:
+			default:
+			}
+		}
+	})
+	return songEvents{failed, loaded}, stop
+}
+
+// addSubtitles adds the subtitles of a song to the loaded song.
+func (p *Player) addSubtitles(ctx context.Context, song queue.QueuedSong) {
+	if song.Subtitles == "" {
+		return
+	}
+	_, err := p.media.Command(ctx, "sub-add", song.Subtitles, "select", "Lyrics", song.SubtitleLanguage)
+	if err != nil {
+		slog.ErrorContext(ctx, "Unable to add subtitles", slog.String("path", song.Subtitles), slog.String("err", err.Error()))
+	}
+}
+
+// reloadSong loads a song that was interrupted by a backend restart or
+// failed to load, starting from its last known position. If fallback is
+// set, the fallback format is requested instead of the default one.
+func (p *Player) reloadSong(ctx context.Context, song queue.QueuedSong, fallback bool) error {
+	position := p.current.position()
+	slog.InfoContext(ctx, "Reloading song", slog.String("title", song.Title), slog.Duration("position", position), slog.Bool("fallback", fallback))
+
+	// A local copy that fails to play is not retried, the song is
+	// streamed with the fallback format instead.
+	file := song.SongURL
+	options := p.songOptions(song, position)
+	if fallback {
+		options["ytdl-format"] = p.fallbackFormat
+	} else {
+		file = p.songFile(song)
+	}
+	entryID, err := loadFileWithOptions(ctx, p.media, file, mpv.LoadFileModeReplace, options)
+	if err != nil {
+		return fmt.Errorf("error reloading song URL to mpv: %w", err)
+	}
+	p.current.setEntry(entryID, file)
+	return p.Resume(ctx)
+}
+
+// songFailed marks a song that could not be played and tells the
+// requester about it.
+func (p *Player) songFailed(ctx context.Context, song queue.QueuedSong, reason string) {
+	slog.ErrorContext(ctx, "Song could not be played", slog.String("title", song.Title), slog.String("url", song.SongURL), slog.String("reason", reason))
+
+	tx := p.q.BeginTxn(true)
+	defer tx.Discard()
+	err := tx.MarkFailed(song.ID, reason)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Unable to mark song as failed", slog.String("err", err.Error()))
+	}
+
+	if err := p.notifier.songFailed(ctx, song, reason); err != nil {
+		slog.ErrorContext(ctx, "Unable to send failure message", slog.String("err", err.Error()))
+	}
+}
+
+// loadError is returned when the backend ends a song with an error.
+type loadError struct {
+	reason string
+}
+
+func (e *loadError) Error() string {
+	return "unable to load song: " + e.reason
+}
+
+// loadSong loads the preview poster, announcement, loading poster and song
+// into the paused media backend. The song starts at the given position,
+// and is only announced if it starts from the beginning.
+func (p *Player) loadSong(ctx context.Context, song queue.QueuedSong, username string, next []queue.QueuedSong, start time.Duration) error {
+	p.current.setEntry(0, "")
+
+	thumbnail, err := p.fetchThumbnail(ctx, song.ThumbnailURL)
+	if err != nil {
+		thumbnail = image.Black
+		slog.WarnContext(ctx, "Unable to download thumbnail", slog.String("err", err.Error()), slog.String("url", song.ThumbnailURL))
+	}
+	if p.isAudioOnly(song) {
+		p.renderAudioPoster(ctx, song, username, next, thumbnail)
+	}
+
+	hasPoster := false
+	previewLocation, err := p.posters.renderPreview(song, username, next, thumbnail)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error writing preview poster", slog.String("err", err.Error()))
+	} else {
+		if err = p.media.LoadFile(ctx, previewLocation, mpv.LoadFileModeReplace); err != nil {
+			return fmt.Errorf("error loading preview poster file to mpv: %w", err)
+		}
+		hasPoster = true
+		if start <= song.Start {
+			p.loadAnnouncement(ctx, song, username, previewLocation)
+		}
+	}
+
+	if err = p.Pause(ctx); err != nil {
+		return fmt.Errorf("error pausing mpv: %w", err)
+	}
+
+	loadingLocation, err := p.posters.renderLoading(thumbnail)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error writing loading poster", slog.String("err", err.Error()))
+	} else {
+		if err = p.media.LoadFile(ctx, loadingLocation, mpv.LoadFileModeAppend); err != nil {
+			return fmt.Errorf("error sending loading poster file to mpv: %w", err)
+		}
+	}
+
+	mode := mpv.LoadFileModeAppend
+	if !hasPoster {
+		mode = mpv.LoadFileModeReplace
+	}
+	file := p.songFile(song)
+	entryID, err := loadFileWithOptions(ctx, p.media, file, mode, p.songOptions(song, start))
+	if err != nil {
+		return fmt.Errorf("error loading song URL to mpv: %w", err)
+	}
+	p.current.setEntry(entryID, file)
+	return nil
+}
+
+// songFile returns the local copy of a song if there is one, or its URL.
+func (p *Player) songFile(song queue.QueuedSong) string {
+	if p.source != nil {
+		if path, ok := p.source.localFile(song.SongURL); ok {
+			return path
+		}
+	}
+	return song.SongURL
+}
+
+// startOptions returns the per-file options starting a file at the given
+// position.
+func startOptions(position time.Duration) map[string]string {
+	options := make(map[string]string)
+	if position > 0 {
+		options["start"] = strconv.FormatFloat(position.Seconds(), 'f', 3, 64)
+	}
+	return options
+}
+
+// songOptions returns the per-file options for the current song, starting
+// it at the given position or where the song is trimmed to start.
+func (p *Player) songOptions(song queue.QueuedSong, position time.Duration) map[string]string {
+	options := startOptions(max(position, song.Start))
+	if song.End > 0 {
+		options["end"] = strconv.FormatFloat(song.End.Seconds(), 'f', 3, 64)
+	}
+	maps.Copy(options, p.current.loadAudio().options())
+	if p.isAudioOnly(song) {
+		audioOnlyOptions(options)
+	}
+	return options
+}
+
+// UpdateSong applies the gain and adjustments of a song if it is the
+// current one. They are applied right away if the song is playing,
+// otherwise when the song starts.
+func (p *Player) UpdateSong(ctx context.Context, song queue.QueuedSong) error {
+	p.current.setUnlimited(song.ID, song.Unlimited)
+	if !p.current.setAudio(song.ID, songAudioSettings(song)) {
+		return nil
+	}
+	return p.setProperties(ctx, p.current.changedProperties())
+}
+
+func (p *Player) setProperties(ctx context.Context, properties map[string]any) error {
+	for name, value := range properties {
+		if err := p.media.SetProperty(ctx, name, value); err != nil {
+			slog.ErrorContext(ctx, "Unable to set song property", slog.String("property", name), slog.String("err", err.Error()))
+			return err
+		}
+	}
+	return nil
+}
+
+// countdown waits for the countdown to finish or for the backend to be
+// unpaused manually. If the singer has not confirmed they are ready by
+// then, it waits for them until the ready check times out and returns
+// errNoShow. The countdown can be extended while it runs. Returns false
+// if the song context was cancelled before the song started.
+func (p *Player) countdown(ctx, songCtx context.Context, song queue.QueuedSong, username string, exited <-chan struct{}) (started bool, err error) {
+	unpausedCh := make(chan struct{})
+	var once sync.Once
+	unobserve, err := p.media.ObserveProperty(ctx, "pause", func(value any) {
+		if paused, ok := value.(bool); ok && !paused {
+			slog.DebugContext(ctx, "Detected false pause state, continuing")
+			once.Do(func() { close(unpausedCh) })
+		}
+	})
+	if err != nil {
+		return false, fmt.Errorf("unable to observe pause property: %w", err)
+	}
+	defer func() {
+		if err := unobserve(); err != nil {
+			slog.DebugContext(ctx, "Unable to unobserve pause property", slog.String("err", err.Error()))
+		}
+	}()
+
+	now := p.clock.Now()
+	p.recordTimes(ctx, song.ID, func(times *queue.PlayTimes) {
+		if times.Countdown.IsZero() {
+			times.Countdown = now
+		}
+	})
+
+	// The overlay is updated separately from waiting, and removed once it
+	// can no longer be shown again.
+	extended := p.current.startCountdown(now.Add(p.playbackTime))
+	defer p.current.endCountdown()
+	p.reportNowPlaying(ctx, song, nowPlayingCountdown, "")
+	displayCtx, stopDisplay := context.WithCancel(songCtx)
+	displayDone := make(chan struct{})
+	go func() {
+		defer close(displayDone)
+		p.runCountdownDisplay(displayCtx, username, p.current.countdownDeadline)
+	}()
+	defer func() {
+		stopDisplay()
+		<-displayDone
+		p.hideCountdown(ctx)
+	}()
+
+	ready := p.current.readyChan()
+	countdownDone := p.clock.After(p.current.countdownDeadline().Sub(p.clock.Now()))
+	var noShow <-chan time.Time
+	for {
+		select {
+		case <-songCtx.Done():
+			return false, nil
+		case <-exited:
+			return false, errMPVNotRunning
+		case <-unpausedCh:
+			return true, nil
+		case <-ready:
+			ready = nil
+			if countdownDone != nil {
+				continue
+			}
+		case <-countdownDone:
+			if left := p.current.countdownDeadline().Sub(p.clock.Now()); left > 0 {
+				// The countdown was extended.
+				countdownDone = p.clock.After(left)
+				continue
+			}
+			countdownDone = nil
+			if ready != nil {
+				slog.DebugContext(ctx, "Waiting for singer to be ready")
+				noShow = p.clock.After(max(p.readyTimeout-p.playbackTime, 0))
+				continue
+			}
+		case <-noShow:
+			return false, errNoShow
+		case <-extended:
+			// The singer gets the extra time even if they were already
+			// being waited for.
+			countdownDone = p.clock.After(p.current.countdownDeadline().Sub(p.clock.Now()))
+			noShow = nil
+			p.reportNowPlaying(ctx, song, nowPlayingCountdown, "")
+			continue
+		}
+		if err = p.Resume(ctx); err != nil {
+			return false, fmt.Errorf("unable to set pause state: %w", err)
+		}
+		return true, nil
+	}
+}
+
+// runCountdownDisplay shows the time left until the deadline on the
+// countdown overlay until the context is cancelled. Frames are shown as
+// each second is reached, so the display does not drift. The deadline
+// is read for every frame, as the countdown can be extended.
+func (p *Player) runCountdownDisplay(ctx context.Context, username string, deadline func() time.Time) {
+	for frame := 0; ; frame++ {
+		left := max(deadline().Sub(p.clock.Now()), 0)
+		p.showCountdown(ctx, username, left, frame)
+		next := left % time.Second
+		if next == 0 {
+			next = time.Second
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.clock.After(next):
+		}
+	}
+}
+
+// countdownOverlayID is the mpv overlay slot used by the countdown.
+const countdownOverlayID = 0
+
+// showCountdown shows a frame of the countdown overlay, centered near the
+// bottom of the screen.
+func (p *Player) showCountdown(ctx context.Context, username string, left time.Duration, frame int) {
+	overlay, err := p.posters.renderCountdown(username, left, p.playbackTime, frame)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error rendering countdown", slog.String("err", err.Error()))
+		return
+	}
+	width, height := p.osdSize(ctx)
+	x := max(width-overlay.width, 0) / 2
+	y := max(int(float64(height)*0.9)-overlay.height, 0)
+	_, err = p.media.Command(ctx, "overlay-add", countdownOverlayID, x, y, overlay.path, 0, "bgra", overlay.width, overlay.height, overlay.width*4)
+	if err != nil && ctx.Err() == nil {
+		slog.ErrorContext(ctx, "Unable to show countdown", slog.String("err", err.Error()))
+	}
+}
+
+// osdSize returns the size of the screen overlays are drawn on, assuming
+// 1080p if it is unknown.
+func (p *Player) osdSize(ctx context.Context) (width, height int) {
+	width, height = 1920, 1080
+	if w, err := getPropertyFloat(ctx, p.media, "osd-width"); err == nil && w > 0 {
+		width = int(w)
+	}
+	if h, err := getPropertyFloat(ctx, p.media, "osd-height"); err == nil && h > 0 {
+		height = int(h)
+	}
+	return width, height
+}
+
+func (p *Player) hideCountdown(ctx context.Context) {
+	if _, err := p.media.Command(ctx, "overlay-remove", countdownOverlayID); err != nil {
+		slog.DebugContext(ctx, "Unable to remove countdown", slog.String("err", err.Error()))
+	}
+}
+
+// waitIdle waits for the backend to become idle after a song has been
+// played, or for the song context to be cancelled. The playback position
+// of the song is recorded while waiting and its subtitles are added once
+// it is loaded. Returns a loadError if the song fails to load.
+func (p *Player) waitIdle(ctx, songCtx context.Context, song queue.QueuedSong, exited <-chan struct{}, events songEvents) error {
+	continueCh := make(chan struct{})
+	var once sync.Once
+	unobserve, err := p.media.ObserveProperty(ctx, "idle-active", func(value any) {
+		idle, _ := value.(bool)
+		slog.DebugContext(ctx, "Observed change in idle-active state", slog.Bool("idle-active", idle))
+		if idle {
+			once.Do(func() { close(continueCh) })
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("unable to observe idle-active property: %w", err)
+	}
+
+	audioOnly := p.isAudioOnly(song)
+	if audioOnly {
+		p.showAudioPoster(songCtx, song)
+		defer p.hideAudioPoster(ctx)
+	}
+
+	var failure error
+wait:
+	for frame := 1; ; frame++ {
+		select {
+		case <-continueCh:
+			select {
+			case reason := <-events.failed:
+				failure = &loadError{reason}
+			default:
+			}
+			break wait
+		case reason := <-events.failed:
+			failure = &loadError{reason}
+			break wait
+		case id := <-events.loaded:
+			if id != 0 && id == p.current.entryID() {
+				p.addSubtitles(songCtx, song)
+			}
+		case <-songCtx.Done():
+			break wait
+		case <-exited:
+			// The observer went away with the backend.
+			return errMPVNotRunning
+		case <-p.clock.After(p.pollInterval):
+			p.updatePosition(songCtx)
+			p.reportNowPlaying(songCtx, song, nowPlayingPlaying, "")
+			if audioOnly {
+				p.showProgress(songCtx, song, frame)
+			}
+			if p.checkPlayTime(songCtx, song) {
+				slog.InfoContext(ctx, "Song reached the maximum play time", slog.String("title", song.Title))
+				p.fadeOut(ctx, songCtx)
+			}
+		}
+	}
+
+	if err = unobserve(); err != nil {
+		slog.ErrorContext(ctx, "Unable to unobserve idle-active property", slog.String("err", err.Error()))
+	}
+	return failure
+}
+
+const (
+	// playTimeWarning is how long before the maximum play time is
+	// reached that a warning is shown.
+	playTimeWarning = 30 * time.Second
+	// fadeOutDuration is how long a song takes to fade out once it
+	// reaches the maximum play time.
+	fadeOutDuration = 5 * time.Second
+	fadeOutSteps    = 10
+)
+
+// checkPlayTime warns when the current song is about to reach the maximum
+// play time and reports whether it has reached it.
+func (p *Player) checkPlayTime(ctx context.Context, song queue.QueuedSong) bool {
+	if p.maxPlayTime <= 0 {
+		return false
+	}
+	left, limited := p.current.playTimeLeft(song.Start, p.maxPlayTime)
+	if !limited {
+		return false
+	}
+	if left <= 0 {
+		return true
+	}
+	if left <= playTimeWarning && p.current.warn() {
+		message := fmt.Sprintf("Time limit reached in %s", formatPlaybackTime(left.Seconds()))
+		if _, err := p.media.Command(ctx, "show-text", message, playTimeWarning.Milliseconds()); err != nil {
+			slog.ErrorContext(ctx, "Unable to show time limit warning", slog.String("err", err.Error()))
+		}
+	}
+	return false
+}
+
+// fadeOut lowers the volume to silence and stops the song, restoring the
+// volume afterwards. The fade ends early if the song is skipped.
+func (p *Player) fadeOut(ctx, songCtx context.Context) {
+	volume, err := getPropertyFloat(ctx, p.media, "volume")
+	if err != nil {
+		slog.ErrorContext(ctx, "Unable to get volume to fade out", slog.String("err", err.Error()))
+		stopMedia(ctx, p.media)
+		return
+	}
+	for step := fadeOutSteps - 1; step >= 0; step-- {
+		if !p.sleep(songCtx, fadeOutDuration/fadeOutSteps) {
+			break
+		}
+		if err := p.media.SetProperty(ctx, "volume", volume*float64(step)/fadeOutSteps); err != nil {
+			break
+		}
+	}
+	stopMedia(ctx, p.media)
+	if err := p.media.SetProperty(ctx, "volume", volume); err != nil {
+		slog.ErrorContext(ctx, "Unable to restore volume after fading out", slog.String("err", err.Error()))
+	}
+}
+
+// positionSaveInterval is how often the playback position is persisted.
+const positionSaveInterval = 5 * time.Second
+
+// updatePosition records the playback position of the current song if it
+// is the file being played.
+func (p *Player) updatePosition(ctx context.Context) {
+	path, err := p.media.GetProperty(ctx, "path")
+	if err != nil || path != p.current.file() {
+		return
+	}
+	position, err := getPropertyFloat(ctx, p.media, "time-pos")
+	if err != nil {
+		return
+	}
+	p.current.setPosition(time.Duration(position * float64(time.Second)))
+
+	if now := p.clock.Now(); now.Sub(p.current.savedAt()) >= positionSaveInterval {
+		p.current.setSavedAt(now)
+		p.saveNowPlaying(ctx, p.current.nowPlaying())
+	}
+}
+
+// currentSong tracks the song that is being handled by the player so
+// that commands can refer to and skip it.
+type currentSong struct {
+	mu       sync.Mutex
+	song     *queue.QueuedSong
+	skip     context.CancelFunc
+	phase    queue.PlaybackPhase
+	playedTo time.Duration
+	saved    time.Time
+	entry    int
+	loaded   string
+	// audio are the audio settings of the song, and loadedAudio the
+	// settings it was loaded or last updated with.
+	audio       audioSettings
+	loadedAudio audioSettings
+	// unlimited exempts the song from the maximum play time, and warned
+	// is set once the time limit warning has been shown.
+	unlimited bool
+	warned    bool
+	// ready is closed once the singer has confirmed they are ready.
+	ready chan struct{}
+	// countdownEnd is when the running countdown ends, and extended
+	// receives a value when it is moved.
+	countdownEnd time.Time
+	extended     chan struct{}
+	// audioPoster is shown while the song plays without video.
+	audioPoster overlayImage
+}
+
+func (c *currentSong) set(song *queue.QueuedSong, skip context.CancelFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.song = song
+	c.skip = skip
+	c.ready = make(chan struct{})
+	c.extended = make(chan struct{}, 1)
+	if song != nil {
+		c.audio = songAudioSettings(*song)
+		c.unlimited = song.Unlimited
+	}
+}
+
+// reset releases the skip function of the previous song and clears it.
+func (c *currentSong) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.skip != nil {
+		c.skip()
+	}
+	c.song = nil
+	c.skip = nil
+	c.phase = queue.PhaseCountdown
+	c.playedTo = 0
+	c.saved = time.Time{}
+	c.entry = 0
+	c.loaded = ""
+	c.audio = audioSettings{}
+	c.loadedAudio = audioSettings{}
+	c.unlimited = false
+	c.warned = false
+	c.ready = nil
+	c.countdownEnd = time.Time{}
+	c.extended = nil
+	c.audioPoster = overlayImage{}
+}
+
+// markReady records that the singer of the song with the given ID is
+// ready, returning false if it is not the current song.
+func (c *currentSong) markReady(id int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.song == nil || c.song.ID != id {
+		return false
+	}
+	select {
+	case <-c.ready:
+	default:
+		close(c.ready)
+	}
+	return true
+}
+
+// readyChan returns a channel that is closed once the singer is ready.
+func (c *currentSong) readyChan() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ready
+}
+
+func (c *currentSong) isReady() bool {
+	select {
+	case <-c.readyChan():
+		return true
+	default:
+		return false
+	}
+}
+
+// startCountdown records when the countdown ends, returning a channel
+// that receives a value whenever it is extended.
+func (c *currentSong) startCountdown(end time.Time) <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.countdownEnd = end
+	return c.extended
+}
+
+// endCountdown stops the countdown from being extended.
+func (c *currentSong) endCountdown() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.countdownEnd = time.Time{}
+}
+
+func (c *currentSong) countdownDeadline() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.countdownEnd
+}
+
+// extendCountdown moves the end of the running countdown back by d, or
+// to d from now if the singer is already being waited for. Returns false
+// if no countdown is running.
+func (c *currentSong) extendCountdown(now time.Time, d time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.countdownEnd.IsZero() {
+		return false
+	}
+	if now.After(c.countdownEnd) {
+		c.countdownEnd = now
+	}
+	c.countdownEnd = c.countdownEnd.Add(d)
+	select {
+	case c.extended <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+func (c *currentSong) setPoster(poster overlayImage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.audioPoster = poster
+}
+
+func (c *currentSong) poster() overlayImage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.audioPoster
+}
+
+// loadAudio returns the audio settings of the song, remembering them as
+// the settings the song is loaded with.
+func (c *currentSong) loadAudio() audioSettings {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.loadedAudio = c.audio
+	return c.audio
+}
+
+// setAudio changes the audio settings of the song with the given ID.
+// Returns false if it is not the current song.
+func (c *currentSong) setAudio(id int, audio audioSettings) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.song == nil || c.song.ID != id {
+		return false
+	}
+	c.audio = audio
+	return true
+}
+
+// setUnlimited changes whether the song with the given ID is exempt from
+// the maximum play time.
+func (c *currentSong) setUnlimited(id int, unlimited bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.song != nil && c.song.ID == id {
+		c.unlimited = unlimited
+	}
+}
+
+// playTimeLeft returns how long the song can play until it reaches
+// maxPlayTime, having started at start. The time played is counted at the
+// tempo of the song rather than by its position. Returns false if it is
+// exempt.
+func (c *currentSong) playTimeLeft(start, maxPlayTime time.Duration) (left time.Duration, limited bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.unlimited {
+		return 0, false
+	}
+	return maxPlayTime - c.audio.adjustments.PlayTime(c.playedTo-start), true
+}
+
+// warn reports whether the time limit warning should be shown, which is
+// only the first time it is asked.
+func (c *currentSong) warn() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	warned := c.warned
+	c.warned = true
+	return !warned
+}
+
+// changedProperties returns the properties to set for the audio settings
+// to take effect. Settings only change once the song is playing, before
+// that they are applied when it starts.
+func (c *currentSong) changedProperties() map[string]any {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.phase != queue.PhasePlaying {
+		return nil
+	}
+	properties := c.audio.changedProperties(c.loadedAudio)
+	c.loadedAudio = c.audio
+	return properties
+}
+
+// setEntry sets the playlist entry ID and file of the song in the
+// backend.
+func (c *currentSong) setEntry(id int, file string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entry = id
+	c.loaded = file
+}
+
+func (c *currentSong) file() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.loaded
+}
+
+func (c *currentSong) entryID() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.entry
+}
+
+func (c *currentSong) setPhase(phase queue.PlaybackPhase) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.phase = phase
+}
+
+// nowPlaying returns the persisted form of the current song.
+func (c *currentSong) nowPlaying() queue.NowPlaying {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	np := queue.NowPlaying{Phase: c.phase, Position: c.playedTo}
+	if c.song != nil {
+		np.SongID = c.song.ID
+	}
+	return np
+}
+
+// savedAt returns when the position was last persisted.
+func (c *currentSong) savedAt() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.saved
+}
+
+func (c *currentSong) setSavedAt(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.saved = t
+}
+
+func (c *currentSong) setPosition(position time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.playedTo = position
+}
+
+// position returns the last recorded playback position of the song.
+func (c *currentSong) position() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.playedTo
+}
+
+// get returns the current song, or nil if no song is being played.
+func (c *currentSong) get() *queue.QueuedSong {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.song
+}
+
+// skipID cancels the current song if it has the given ID.
+func (c *currentSong) skipID(id int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.skip != nil && c.song != nil && c.song.ID == id {
+		c.skip()
+	}
+}
+
+// skipSong cancels the current song and returns it. Returns nil if
+// there is no song to skip.
+func (c *currentSong) skipSong() *queue.QueuedSong {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.skip != nil {
+		c.skip()
+	}
+	return c.song
+}
+
+// backendState tracks whether the media backend is running so that the
+// player can wait for it to be restarted.
+type backendState struct {
+	mu        sync.Mutex
+	exitedCh  chan struct{} // closed once the backend exits
+	runningCh chan struct{} // closed while the backend is running
+}
+
+func newBackendState() *backendState {
+	running := make(chan struct{})
+	close(running)
+	return &backendState{
+		exitedCh:  make(chan struct{}),
+		runningCh: running,
+	}
+}
+
+func (b *backendState) exited() <-chan struct{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.exitedCh
+}
+
+func (b *backendState) running() <-chan struct{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.runningCh
+}
+
+func (b *backendState) setExited() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if isClosed(b.exitedCh) {
+		return
+	}
+	close(b.exitedCh)
+	b.runningCh = make(chan struct{})
+}
+
+func (b *backendState) setRunning() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if isClosed(b.runningCh) {
+		return
+	}
+	close(b.runningCh)
+	b.exitedCh = make(chan struct{})
+}
+
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+var errNothingInterrupted = errors.New("no song was interrupted")
+
+// interruptedSong holds a song that was interrupted when the player last
+// stopped, along with the position it had reached.
+type interruptedSong struct {
+	mu       sync.Mutex
+	song     *queue.QueuedSong
+	position time.Duration
+}
+
+func (i *interruptedSong) set(song queue.QueuedSong, position time.Duration) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.song = &song
+	i.position = position
+}
+
+// take returns the interrupted song and forgets it.
+func (i *interruptedSong) take() (song queue.QueuedSong, position time.Duration, ok bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.song == nil {
+		return
+	}
+	song, position, ok = *i.song, i.position, true
+	i.song = nil
+	return
+}
@@ -0,0 +1,1943 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xoltia/mdk3/queue"
+	"github.com/xoltia/mpv"
+)
+
+var errPropertyUnavailable = errors.New("mpv: command failed: property unavailable")
+
+type fakeObserver struct {
+	property string
+	fn       func(any)
+}
+
+// fakeMedia is an in-memory media backend that keeps a playlist and a
+// property map, mimicking the parts of mpv used by the player.
+type fakeMedia struct {
+	mu        sync.Mutex
+	props     map[string]any
+	playlist  []string
+	commands  []string
+	osd       []string
+	observers map[int]fakeObserver
+	nextID    int
+	loadErrs  map[string]error
+	options   map[string]string
+	entries   map[string]int
+	nextEntry int
+	handlers  map[int]func(map[string]any)
+	subtitles []string
+	keys      map[string]string
+	overlays  map[int]string
+}
+
+func newFakeMedia() *fakeMedia {
+	return &fakeMedia{
+		props:     defaultFakeProps(),
+		observers: make(map[int]fakeObserver),
+		loadErrs:  make(map[string]error),
+		options:   make(map[string]string),
+		entries:   make(map[string]int),
+		handlers:  make(map[int]func(map[string]any)),
+		keys:      make(map[string]string),
+		overlays:  make(map[int]string),
+	}
+}
+
+func defaultFakeProps() map[string]any {
+	return map[string]any{
+		"idle-active": true,
+		"pause":       false,
+		"volume":      100.0,
+	}
+}
+
+// restart simulates mpv being restarted by a supervisor, losing its
+// state along with any observers.
+func (m *fakeMedia) restart() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.props = defaultFakeProps()
+	m.playlist = nil
+	m.observers = make(map[int]fakeObserver)
+}
+
+func (m *fakeMedia) Command(_ context.Context, command string, args ...any) (any, error) {
+	m.mu.Lock()
+	m.commands = append(m.commands, command)
+	switch command {
+	case "show-text":
+		m.osd = append(m.osd, args[0].(string))
+	case "sub-add":
+		m.subtitles = append(m.subtitles, args[0].(string))
+	case "keybind":
+		m.keys[args[0].(string)] = args[1].(string)
+	case "overlay-add":
+		m.overlays[args[0].(int)] = args[3].(string)
+	case "overlay-remove":
+		delete(m.overlays, args[0].(int))
+	case "loadfile":
+		file := args[0].(string)
+		m.options[file] = ""
+		if len(args) > 3 {
+			m.options[file] = args[3].(string)
+		}
+		m.mu.Unlock()
+		if err := m.LoadFile(context.Background(), file, mpv.LoadFileMode(args[1].(string))); err != nil {
+			return nil, err
+		}
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		return map[string]any{"playlist_entry_id": float64(m.entries[file])}, nil
+	case "stop":
+		m.playlist = nil
+		m.mu.Unlock()
+		m.set("idle-active", true)
+		return nil, nil
+	}
+	m.mu.Unlock()
+	return nil, nil
+}
+
+func (m *fakeMedia) LoadFile(_ context.Context, file string, mode mpv.LoadFileMode) error {
+	m.mu.Lock()
+	if err := m.loadErrs[file]; err != nil {
+		m.mu.Unlock()
+		return err
+	}
+	m.nextEntry++
+	m.entries[file] = m.nextEntry
+	if mode == mpv.LoadFileModeReplace {
+		m.playlist = []string{file}
+	} else {
+		m.playlist = append(m.playlist, file)
+	}
+	m.mu.Unlock()
+	m.set("idle-active", false)
+	return nil
+}
+
+// start simulates the playlist reaching a file.
+func (m *fakeMedia) start(file string) {
+	m.mu.Lock()
+	id := m.entries[file]
+	m.mu.Unlock()
+	m.emit(map[string]any{"event": "start-file", "playlist_entry_id": float64(id)})
+	m.emit(map[string]any{"event": "file-loaded"})
+}
+
+// emit sends an event to the event handlers.
+func (m *fakeMedia) emit(event map[string]any) {
+	m.mu.Lock()
+	handlers := slices.Collect(maps.Values(m.handlers))
+	m.mu.Unlock()
+	for _, fn := range handlers {
+		fn(event)
+	}
+}
+
+// press runs the script-message command bound to a key, returning false
+// if the key is not bound.
+func (m *fakeMedia) press(key string) bool {
+	m.mu.Lock()
+	command, ok := m.keys[key]
+	m.mu.Unlock()
+	fields := strings.Fields(command)
+	if !ok || len(fields) == 0 || fields[0] != "script-message" {
+		return false
+	}
+	var args []any
+	for _, field := range fields[1:] {
+		args = append(args, field)
+	}
+	m.emit(map[string]any{"event": "client-message", "args": args})
+	return true
+}
+
+func (m *fakeMedia) SetProperty(_ context.Context, property string, value any) error {
+	m.set(property, value)
+	return nil
+}
+
+func (m *fakeMedia) GetProperty(_ context.Context, property string) (any, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	value, ok := m.props[property]
+	if !ok {
+		return nil, errPropertyUnavailable
+	}
+	return value, nil
+}
+
+func (m *fakeMedia) ObserveProperty(_ context.Context, property string, fn func(any)) (func() error, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nextID++
+	id := m.nextID
+	m.observers[id] = fakeObserver{property, fn}
+	// mpv reports the current value right after observing.
+	go fn(m.props[property])
+	return func() error {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		delete(m.observers, id)
+		return nil
+	}, nil
+}
+
+func (m *fakeMedia) AddEventHandlerSync(fn func(map[string]any)) func() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nextID++
+	id := m.nextID
+	m.handlers[id] = fn
+	return func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		delete(m.handlers, id)
+	}
+}
+
+// fail simulates a file failing to load, which ends the playlist.
+func (m *fakeMedia) fail(file, reason string) {
+	m.mu.Lock()
+	event := map[string]any{
+		"event":             "end-file",
+		"reason":            "error",
+		"playlist_entry_id": float64(m.entries[file]),
+		"file_error":        reason,
+	}
+	m.playlist = nil
+	m.mu.Unlock()
+	m.emit(event)
+	m.set("idle-active", true)
+}
+
+func (m *fakeMedia) set(property string, value any) {
+	m.mu.Lock()
+	m.props[property] = value
+	var fns []func(any)
+	for _, o := range m.observers {
+		if o.property == property {
+			fns = append(fns, o.fn)
+		}
+	}
+	m.mu.Unlock()
+	for _, fn := range fns {
+		go fn(value)
+	}
+}
+
+// finish simulates the end of the playlist.
+func (m *fakeMedia) finish() {
+	m.mu.Lock()
+	m.playlist = nil
+	m.mu.Unlock()
+	m.set("idle-active", true)
+}
+
+func (m *fakeMedia) shownOverlays() map[int]string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return maps.Clone(m.overlays)
+}
+
+func (m *fakeMedia) loadOptions(file string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.options[file]
+}
+
+func (m *fakeMedia) addedSubtitles() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.subtitles)
+}
+
+func (m *fakeMedia) getPlaylist() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.playlist)
+}
+
+func (m *fakeMedia) get(property string) any {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.props[property]
+}
+
+func (m *fakeMedia) commandCount(command string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	count := 0
+	for _, c := range m.commands {
+		if c == command {
+			count++
+		}
+	}
+	return count
+}
+
+func (m *fakeMedia) osdContains(text string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Contains(m.osd, text)
+}
+
+func (m *fakeMedia) observing(property string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, o := range m.observers {
+		if o.property == property {
+			return true
+		}
+	}
+	return false
+}
+
+type fakeTimer struct {
+	deadline time.Time
+	ch       chan time.Time
+}
+
+// fakeClock is a clock that only moves when advanced.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []fakeTimer
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 20, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.timers = append(c.timers, fakeTimer{c.now.Add(d), ch})
+	return ch
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	pending := c.timers[:0]
+	for _, t := range c.timers {
+		if t.deadline.After(c.now) {
+			pending = append(pending, t)
+			continue
+		}
+		t.ch <- c.now
+	}
+	c.timers = pending
+}
+
+func (c *fakeClock) pendingTimers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+type fakeNotifier struct {
+	mu      sync.Mutex
+	next    []queue.QueuedSong
+	notices []string
+	failed  []queue.QueuedSong
+	status  []nowPlayingStatus
+	// readyWithin is the ready check time of the last heads up.
+	readyWithin time.Duration
+}
+
+func (n *fakeNotifier) displayName(_ context.Context, userID string) string {
+	return "user " + userID
+}
+
+func (n *fakeNotifier) songUpNext(_ context.Context, song queue.QueuedSong, _, readyWithin time.Duration) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.next = append(n.next, song)
+	n.readyWithin = readyWithin
+	return nil
+}
+
+func (n *fakeNotifier) songFailed(_ context.Context, song queue.QueuedSong, reason string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	song.PlayError = reason
+	n.failed = append(n.failed, song)
+	return nil
+}
+
+func (n *fakeNotifier) failures() []queue.QueuedSong {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return slices.Clone(n.failed)
+}
+
+func (n *fakeNotifier) notice(_ context.Context, message string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.notices = append(n.notices, message)
+	return nil
+}
+
+func (n *fakeNotifier) channelName(context.Context) string {
+	return "karaoke"
+}
+
+func (n *fakeNotifier) nowPlaying(_ context.Context, status nowPlayingStatus) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.status = append(n.status, status)
+}
+
+func (n *fakeNotifier) statuses() []nowPlayingStatus {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return slices.Clone(n.status)
+}
+
+func (n *fakeNotifier) noticed() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return slices.Clone(n.notices)
+}
+
+func (n *fakeNotifier) notified() []queue.QueuedSong {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return slices.Clone(n.next)
+}
+
+type fakePosters struct {
+	previewErr error
+
+	mu        sync.Mutex
+	idle      []idleScreen
+	countdown []time.Duration
+	progress  []time.Duration
+}
+
+func (r *fakePosters) renderPreview(queue.QueuedSong, string, []queue.QueuedSong, image.Image) (string, error) {
+	if r.previewErr != nil {
+		return "", r.previewErr
+	}
+	return "preview.png", nil
+}
+
+func (r *fakePosters) renderLoading(image.Image) (string, error) {
+	return "loading.png", nil
+}
+
+func (r *fakePosters) renderIdle(screen idleScreen) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.idle = append(r.idle, screen)
+	return "idle.png", nil
+}
+
+func (r *fakePosters) idleScreens() []idleScreen {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.idle)
+}
+
+func (r *fakePosters) renderCountdown(_ string, left, _ time.Duration, _ int) (overlayImage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.countdown = append(r.countdown, left)
+	return overlayImage{path: "countdown.bgra", width: 10, height: 10}, nil
+}
+
+func (r *fakePosters) renderAudioPoster(queue.QueuedSong, string, []queue.QueuedSong, image.Image, int, int) (overlayImage, error) {
+	return overlayImage{path: "poster.bgra", width: 1920, height: 1080}, nil
+}
+
+func (r *fakePosters) renderProgress(elapsed, _ time.Duration, _, _ int) (overlayImage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.progress = append(r.progress, elapsed)
+	return overlayImage{path: "progress.bgra", width: 10, height: 10}, nil
+}
+
+func (r *fakePosters) progressFrames() []time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.progress)
+}
+
+func (r *fakePosters) countdownFrames() []time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.countdown)
+}
+
+type playerTest struct {
+	t        *testing.T
+	q        *queue.Queue
+	media    *fakeMedia
+	clock    *fakeClock
+	notifier *fakeNotifier
+	posters  *fakePosters
+	player   *Player
+}
+
+func newPlayerTest(t *testing.T) *playerTest {
+	t.Helper()
+	q, err := queue.OpenQueue(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { q.Close() })
+
+	pt := &playerTest{
+		t:        t,
+		q:        q,
+		media:    newFakeMedia(),
+		clock:    newFakeClock(),
+		notifier: &fakeNotifier{},
+		posters:  &fakePosters{},
+	}
+	pt.player = newPlayer(
+		q, pt.media,
+		withClock(pt.clock),
+		withNotifier(pt.notifier),
+		withPosterRenderer(pt.posters),
+		withThumbnailFetcher(func(context.Context, string) (image.Image, error) {
+			return image.Black, nil
+		}),
+		withCountdown(30*time.Second),
+	)
+	return pt
+}
+
+func (pt *playerTest) enqueue(title, url string) {
+	pt.t.Helper()
+	tx := pt.q.BeginTxn(true)
+	defer tx.Discard()
+	if _, err := tx.Enqueue(queue.NewSong{UserID: "1", Title: title, SongURL: url}); err != nil {
+		pt.t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		pt.t.Fatal(err)
+	}
+}
+
+func (pt *playerTest) run() (cancel context.CancelFunc, done <-chan error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		errCh <- pt.player.Run(ctx)
+	}()
+	pt.t.Cleanup(func() {
+		cancel()
+		<-finished
+	})
+	return cancel, errCh
+}
+
+func (pt *playerTest) waitFor(description string, cond func() bool) {
+	pt.t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			pt.t.Fatalf("timed out waiting for %s", description)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func (pt *playerTest) waitForPlaylist(expected ...string) {
+	pt.t.Helper()
+	pt.waitFor(fmt.Sprint("playlist ", expected), func() bool {
+		return slices.Equal(pt.media.getPlaylist(), expected)
+	})
+}
+
+// waitForCountdown waits until the countdown timers have been started.
+func (pt *playerTest) waitForCountdown() {
+	pt.t.Helper()
+	pt.waitFor("countdown", func() bool {
+		return pt.clock.pendingTimers() >= 2
+	})
+}
+
+func TestPlayerWaitsForStart(t *testing.T) {
+	pt := newPlayerTest(t)
+	pt.enqueue("Song", "https://example.com/song")
+	pt.run()
+
+	pt.waitForPlaylist("idle.png")
+	screens := pt.posters.idleScreens()
+	if len(screens) != 1 || !screens[0].waiting || screens[0].queued != 1 || screens[0].channel != "karaoke" {
+		t.Errorf("expected waiting idle screen with 1 queued song, got %+v", screens)
+	}
+
+	tx := pt.q.BeginTxn(false)
+	defer tx.Discard()
+	count, err := tx.Count()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("expected 1 song in queue, got %d", count)
+	}
+}
+
+func TestPlayerRefreshesIdleScreen(t *testing.T) {
+	pt := newPlayerTest(t)
+	pt.enqueue("Song", "https://example.com/song")
+	pt.player.SetDequeueEnabled(true)
+	pt.run()
+
+	pt.waitForCountdown()
+	pt.clock.Advance(30 * time.Second)
+	pt.waitFor("idle observer", func() bool {
+		return pt.media.observing("idle-active")
+	})
+	pt.media.finish()
+	pt.waitForPlaylist("idle.png")
+
+	screens := pt.posters.idleScreens()
+	if len(screens) != 1 || screens[0].waiting {
+		t.Fatalf("expected empty queue screen, got %+v", screens)
+	}
+	if len(screens[0].recent) != 1 || screens[0].recent[0].Title != "Song" {
+		t.Errorf("expected song in recent plays, got %v", screens[0].recent)
+	}
+	if len(screens[0].requesters) != 1 || screens[0].requesters[0].songs != 1 {
+		t.Errorf("expected requester with 1 song, got %v", screens[0].requesters)
+	}
+
+	// The screen is only rendered again once the queue changes.
+	pt.clock.Advance(time.Second)
+	pt.clock.Advance(time.Second)
+	pt.player.SetDequeueEnabled(false)
+	pt.waitFor("waiting screen", func() bool {
+		pt.clock.Advance(time.Second)
+		screens = pt.posters.idleScreens()
+		return len(screens) > 1
+	})
+	if len(screens) != 2 || !screens[1].waiting {
+		t.Errorf("expected one waiting screen after stopping, got %+v", screens)
+	}
+}
+
+func TestPlayerPlaysIdleMusic(t *testing.T) {
+	pt := newPlayerTest(t)
+	withIdleMusic([]idleTrack{
+		{title: "Lounge", file: "/music/lounge.mp3"},
+		{title: "Jazz", file: "https://example.com/jazz", stream: true},
+	}, -12)(pt.player)
+	pt.run()
+
+	pt.waitForPlaylist("/music/lounge.mp3")
+	expected := "cover-art-files=idle.png,volume-gain=-12.00"
+	if options := pt.media.loadOptions("/music/lounge.mp3"); options != expected {
+		t.Errorf("expected options %q, got %q", expected, options)
+	}
+
+	// The screen is updated without interrupting the track.
+	pt.enqueue("Song", "https://example.com/song")
+	pt.waitFor("idle screen update", func() bool {
+		pt.clock.Advance(time.Second)
+		return pt.media.commandCount("video-add") == 1
+	})
+	if playlist := pt.media.getPlaylist(); !slices.Equal(playlist, []string{"/music/lounge.mp3"}) {
+		t.Errorf("expected track to keep playing, got %v", playlist)
+	}
+
+	pt.media.finish()
+	pt.waitFor("next track", func() bool {
+		pt.clock.Advance(time.Second)
+		return slices.Equal(pt.media.getPlaylist(), []string{"https://example.com/jazz"})
+	})
+	expected = "cover-art-files=idle.png,volume-gain=-12.00,ytdl-format=bestaudio/best"
+	if options := pt.media.loadOptions("https://example.com/jazz"); options != expected {
+		t.Errorf("expected options %q, got %q", expected, options)
+	}
+
+	// Songs replace the music as soon as they are dequeued.
+	pt.player.SetDequeueEnabled(true)
+	pt.waitFor("song", func() bool {
+		pt.clock.Advance(time.Second)
+		return pt.player.Current() != nil
+	})
+	pt.waitForCountdown()
+	np, err := pt.nowPlaying()
+	if err != nil || np.SongID != pt.player.Current().ID {
+		t.Errorf("expected song to be recorded as playing, got %+v (%v)", np, err)
+	}
+
+	pt.clock.Advance(30 * time.Second)
+	pt.waitFor("idle observer", func() bool {
+		return pt.media.observing("idle-active")
+	})
+	pt.media.finish()
+	pt.waitFor("music after song", func() bool {
+		pt.clock.Advance(time.Second)
+		return slices.Equal(pt.media.getPlaylist(), []string{"/music/lounge.mp3"})
+	})
+	if screens := pt.posters.idleScreens(); len(screens[len(screens)-1].recent) != 1 {
+		t.Errorf("expected only the song in recent plays, got %+v", screens[len(screens)-1].recent)
+	}
+}
+
+func TestPlayerPlaysSongAfterCountdown(t *testing.T) {
+	pt := newPlayerTest(t)
+	pt.enqueue("Song", "https://example.com/song")
+	pt.player.SetDequeueEnabled(true)
+	pt.run()
+
+	pt.waitForPlaylist("preview.png", "loading.png", "https://example.com/song")
+	pt.waitForCountdown()
+
+	if paused := pt.media.get("pause"); paused != true {
+		t.Errorf("expected paused during countdown, got %v", paused)
+	}
+	if current := pt.player.Current(); current == nil || current.Title != "Song" {
+		t.Errorf("expected current song, got %v", current)
+	}
+	notified := pt.notifier.notified()
+	if len(notified) != 1 || notified[0].Title != "Song" {
+		t.Errorf("expected heads up for song, got %v", notified)
+	}
+
+	pt.clock.Advance(30 * time.Second)
+	pt.waitFor("idle observer", func() bool {
+		return pt.media.observing("idle-active")
+	})
+	if paused := pt.media.get("pause"); paused != false {
+		t.Errorf("expected unpaused after countdown, got %v", paused)
+	}
+	if pt.media.commandCount("overlay-add") == 0 {
+		t.Error("expected countdown overlay to be shown")
+	}
+	if pt.media.commandCount("overlay-remove") != 1 {
+		t.Error("expected countdown overlay to be removed")
+	}
+
+	pt.media.finish()
+	pt.waitFor("song to finish", func() bool {
+		return pt.player.Current() == nil
+	})
+
+	tx := pt.q.BeginTxn(false)
+	defer tx.Discard()
+	last, err := tx.LastDequeued()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last.Title != "Song" {
+		t.Errorf("expected last dequeued to be Song, got %s", last.Title)
+	}
+}
+
+func TestPlayerStartsWhenUnpausedManually(t *testing.T) {
+	pt := newPlayerTest(t)
+	pt.enqueue("Song", "https://example.com/song")
+	pt.player.SetDequeueEnabled(true)
+	pt.run()
+
+	pt.waitForPlaylist("preview.png", "loading.png", "https://example.com/song")
+	pt.waitForCountdown()
+
+	if !pt.media.observing("pause") {
+		t.Error("expected pause to be observed during countdown")
+	}
+	// The unpause is noticed without the clock moving.
+	pt.media.SetProperty(context.Background(), "pause", false)
+	pt.waitFor("idle observer", func() bool {
+		return pt.media.observing("idle-active")
+	})
+	if pt.media.observing("pause") {
+		t.Error("expected pause to be unobserved after countdown")
+	}
+}
+
+func TestPlayerCountdownDisplayFollowsDeadline(t *testing.T) {
+	pt := newPlayerTest(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		deadline := pt.clock.Now().Add(2500 * time.Millisecond)
+		pt.player.runCountdownDisplay(ctx, "singer", func() time.Time { return deadline })
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// Frames are shown when each second is reached, however late the
+	// previous frame was.
+	for _, d := range []time.Duration{700 * time.Millisecond, 800 * time.Millisecond, time.Second} {
+		pt.waitFor("frame timer", func() bool { return pt.clock.pendingTimers() == 1 })
+		pt.clock.Advance(d)
+	}
+	expected := []time.Duration{2500 * time.Millisecond, 1800 * time.Millisecond, time.Second, 0}
+	pt.waitFor("frames", func() bool { return len(pt.posters.countdownFrames()) == len(expected) })
+	if frames := pt.posters.countdownFrames(); !slices.Equal(frames, expected) {
+		t.Errorf("expected frames %v, got %v", expected, frames)
+	}
+}
+
+func TestPlayerLoadsSongWithoutPoster(t *testing.T) {
+	pt := newPlayerTest(t)
+	pt.posters.previewErr = errors.New("render failed")
+	pt.enqueue("Song", "https://example.com/song")
+	pt.player.SetDequeueEnabled(true)
+	pt.run()
+
+	pt.waitForPlaylist("https://example.com/song")
+	pt.waitForCountdown()
+}
+
+func TestPlayerSkipsSongThatFailsToLoad(t *testing.T) {
+	pt := newPlayerTest(t)
+	pt.media.loadErrs["https://example.com/broken"] = errors.New("load failed")
+	pt.enqueue("Broken", "https://example.com/broken")
+	pt.enqueue("Song", "https://example.com/song")
+	pt.player.SetDequeueEnabled(true)
+	pt.run()
+
+	pt.waitForPlaylist("preview.png", "loading.png", "https://example.com/song")
+	if notified := pt.notifier.notified(); len(notified) != 1 || notified[0].Title != "Song" {
+		t.Errorf("expected heads up only for working song, got %v", notified)
+	}
+}
+
+func TestPlayerSkipDuringCountdown(t *testing.T) {
+	pt := newPlayerTest(t)
+	pt.enqueue("First", "https://example.com/first")
+	pt.enqueue("Second", "https://example.com/second")
+	pt.player.SetDequeueEnabled(true)
+	pt.run()
+
+	pt.waitForPlaylist("preview.png", "loading.png", "https://example.com/first")
+	pt.waitForCountdown()
+
+	skipped := pt.player.Skip()
+	if skipped == nil || skipped.Title != "First" {
+		t.Fatalf("expected to skip First, got %v", skipped)
+	}
+
+	pt.waitForPlaylist("preview.png", "loading.png", "https://example.com/second")
+	if count := pt.media.commandCount("stop"); count != 1 {
+		t.Errorf("expected 1 stop command, got %d", count)
+	}
+}
+
+func TestPlayerSkipDuringPlayback(t *testing.T) {
+	pt := newPlayerTest(t)
+	pt.enqueue("Song", "https://example.com/song")
+	pt.player.SetDequeueEnabled(true)
+	pt.run()
+
+	pt.waitForCountdown()
+	pt.clock.Advance(30 * time.Second)
+	pt.waitFor("idle observer", func() bool {
+		return pt.media.observing("idle-active")
+	})
+
+	pt.player.Skip()
+	pt.waitFor("song to be skipped", func() bool {
+		return pt.player.Current() == nil
+	})
+	if count := pt.media.commandCount("stop"); count != 1 {
+		t.Errorf("expected song to be stopped, got %d stop commands", count)
+	}
+	pt.waitForPlaylist("idle.png")
+	if pt.media.observing("idle-active") {
+		t.Error("expected idle-active to be unobserved")
+	}
+}
+
+func TestPlayerReportsNowPlaying(t *testing.T) {
+	pt := newPlayerTest(t)
+	pt.enqueue("First", "https://example.com/first")
+	pt.enqueue("Second", "https://example.com/second")
+	pt.player.SetDequeueEnabled(true)
+	pt.run()
+
+	pt.waitForCountdown()
+	statuses := pt.notifier.statuses()
+	if len(statuses) != 1 || statuses[0].phase != nowPlayingCountdown {
+		t.Fatalf("expected countdown status, got %v", statuses)
+	}
+	if !statuses[0].startAt.Equal(pt.clock.Now().Add(30 * time.Second)) {
+		t.Errorf("expected song to start in 30s, got %v", statuses[0].startAt)
+	}
+	if next := statuses[0].next; len(next) != 1 || next[0].Title != "Second" {
+		t.Errorf("expected Second up next, got %v", next)
+	}
+
+	pt.clock.Advance(30 * time.Second)
+	pt.waitFor("idle observer", func() bool {
+		return pt.media.observing("idle-active")
+	})
+	pt.media.set("path", "https://example.com/first")
+	pt.media.set("time-pos", 12.0)
+	pt.clock.Advance(time.Second)
+	pt.waitFor("progress", func() bool {
+		statuses := pt.notifier.statuses()
+		last := statuses[len(statuses)-1]
+		return last.phase == nowPlayingPlaying && last.elapsed == 12*time.Second
+	})
+
+	pt.media.finish()
+	pt.waitForPlaylist("preview.png", "loading.png", "https://example.com/second")
+	pt.waitForCountdown()
+	var phases []nowPlayingPhase
+	for _, status := range pt.notifier.statuses() {
+		if status.song.Title == "First" && status.phase == nowPlayingFinished {
+			if status.result != "Finished playing." {
+				t.Errorf("expected song to have finished, got %q", status.result)
+			}
+		}
+		if len(phases) == 0 || phases[len(phases)-1] != status.phase {
+			phases = append(phases, status.phase)
+		}
+	}
+	expected := []nowPlayingPhase{nowPlayingCountdown, nowPlayingPlaying, nowPlayingFinished, nowPlayingCountdown}
+	if !slices.Equal(phases, expected) {
+		t.Errorf("expected phases %v, got %v", expected, phases)
+	}
+}
+
+func TestPlayerSkipWithoutSong(t *testing.T) {
+	pt := newPlayerTest(t)
+	if song := pt.player.Skip(); song != nil {
+		t.Errorf("expected nothing to skip, got %v", song)
+	}
+}
+
+func TestPlayerStopsOnCancel(t *testing.T) {
+	pt := newPlayerTest(t)
+	pt.enqueue("Song", "https://example.com/song")
+	pt.player.SetDequeueEnabled(true)
+	cancel, done := pt.run()
+
+	pt.waitForCountdown()
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("expected nil error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("player did not stop")
+	}
+	if count := pt.media.commandCount("stop"); count != 0 {
+		t.Errorf("expected no stop command on shutdown, got %d", count)
+	}
+}
+
+func TestPlayerResumesAfterRestart(t *testing.T) {
+	pt := newPlayerTest(t)
+	pt.enqueue("Song", "https://example.com/song")
+	pt.player.SetDequeueEnabled(true)
+	pt.run()
+
+	pt.waitForCountdown()
+	pt.clock.Advance(30 * time.Second)
+	pt.waitFor("idle observer", func() bool {
+		return pt.media.observing("idle-active")
+	})
+
+	pt.media.set("path", "https://example.com/song")
+	pt.media.set("time-pos", 83.5)
+	pt.clock.Advance(time.Second)
+	pt.waitFor("position to be recorded", func() bool {
+		return pt.player.current.position() == 83500*time.Millisecond
+	})
+
+	ctx := context.Background()
+	pt.player.SetVolume(ctx, 80)
+	pt.player.MediaExited(ctx, false)
+	pt.media.restart()
+	pt.player.MediaRestarted(ctx)
+
+	pt.waitForPlaylist("https://example.com/song")
+	if options := pt.media.loadOptions("https://example.com/song"); options != "start=83.500" {
+		t.Errorf("expected song to resume at last position, got options %q", options)
+	}
+	if volume := pt.media.get("volume"); volume != 80.0 {
+		t.Errorf("expected volume to be restored, got %v", volume)
+	}
+	if duration := pt.media.get("osd-duration"); duration != 1100 {
+		t.Errorf("expected OSD duration to be restored, got %v", duration)
+	}
+	if paused := pt.media.get("pause"); paused != false {
+		t.Errorf("expected resumed song to play, got pause %v", paused)
+	}
+	if notices := pt.notifier.noticed(); len(notices) != 2 || notices[1] != "The player is back, continuing Song." {
+		t.Errorf("unexpected notices %v", notices)
+	}
+
+	pt.waitFor("idle observer", func() bool {
+		return pt.media.observing("idle-active")
+	})
+	pt.media.finish()
+	pt.waitFor("song to finish", func() bool {
+		return pt.player.Current() == nil
+	})
+}
+
+func TestPlayerRestartsCountdownAfterRestart(t *testing.T) {
+	pt := newPlayerTest(t)
+	pt.enqueue("Song", "https://example.com/song")
+	pt.player.SetDequeueEnabled(true)
+	pt.run()
+
+	pt.waitForCountdown()
+
+	ctx := context.Background()
+	pt.player.MediaExited(ctx, false)
+	pt.media.restart()
+	pt.player.MediaRestarted(ctx)
+
+	pt.waitForPlaylist("preview.png", "loading.png", "https://example.com/song")
+	if paused := pt.media.get("pause"); paused != true {
+		t.Errorf("expected paused during countdown, got %v", paused)
+	}
+	if notified := pt.notifier.notified(); len(notified) != 1 {
+		t.Errorf("expected a single heads up, got %v", notified)
+	}
+}
+
+func (pt *playerTest) nowPlaying() (queue.NowPlaying, error) {
+	tx := pt.q.BeginTxn(false)
+	defer tx.Discard()
+	return tx.NowPlaying()
+}
+
+// interrupt dequeues a song and records it as interrupted at the given
+// position, as if the player had been stopped while playing it.
+func (pt *playerTest) interrupt(position time.Duration) queue.QueuedSong {
+	pt.t.Helper()
+	tx := pt.q.BeginTxn(true)
+	defer tx.Discard()
+	song, err := tx.Dequeue()
+	if err != nil {
+		pt.t.Fatal(err)
+	}
+	np := queue.NowPlaying{SongID: song.ID, Phase: queue.PhasePlaying, Position: position}
+	if err := tx.SetNowPlaying(np); err != nil {
+		pt.t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		pt.t.Fatal(err)
+	}
+	return song
+}
+
+func TestPlayerRecordsNowPlaying(t *testing.T) {
+	pt := newPlayerTest(t)
+	pt.enqueue("Song", "https://example.com/song")
+	pt.player.SetDequeueEnabled(true)
+	pt.run()
+
+	pt.waitForCountdown()
+	np, err := pt.nowPlaying()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if np.Phase != queue.PhaseCountdown {
+		t.Errorf("expected countdown phase, got %v", np.Phase)
+	}
+
+	pt.clock.Advance(30 * time.Second)
+	pt.waitFor("playing phase", func() bool {
+		np, err := pt.nowPlaying()
+		return err == nil && np.Phase == queue.PhasePlaying
+	})
+
+	pt.media.finish()
+	pt.waitFor("record to be cleared", func() bool {
+		_, err := pt.nowPlaying()
+		return errors.Is(err, queue.ErrNothingPlaying)
+	})
+}
+
+func TestPlayerKeepsNowPlayingOnShutdown(t *testing.T) {
+	pt := newPlayerTest(t)
+	pt.enqueue("Song", "https://example.com/song")
+	pt.player.SetDequeueEnabled(true)
+	cancel, done := pt.run()
+
+	pt.waitForCountdown()
+	pt.clock.Advance(30 * time.Second)
+	pt.waitFor("idle observer", func() bool {
+		return pt.media.observing("idle-active")
+	})
+	pt.media.set("path", "https://example.com/song")
+	pt.media.set("time-pos", 42.0)
+	pt.clock.Advance(time.Second)
+	pt.waitFor("position to be recorded", func() bool {
+		return pt.player.current.position() == 42*time.Second
+	})
+
+	cancel()
+	<-done
+
+	np, err := pt.nowPlaying()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if np.Phase != queue.PhasePlaying || np.Position != 42*time.Second {
+		t.Errorf("unexpected record after shutdown: %+v", np)
+	}
+}
+
+func TestPlayerAutoResumesInterruptedSong(t *testing.T) {
+	pt := newPlayerTest(t)
+	pt.player.autoResume = true
+	pt.enqueue("Interrupted", "https://example.com/interrupted")
+	pt.enqueue("Next", "https://example.com/next")
+	pt.interrupt(83 * time.Second)
+	pt.player.SetDequeueEnabled(true)
+	pt.run()
+
+	pt.waitForPlaylist("preview.png", "loading.png", "https://example.com/interrupted")
+	if options := pt.media.loadOptions("https://example.com/interrupted"); options != "start=83.000" {
+		t.Errorf("expected song to start at interrupted position, got options %q", options)
+	}
+	if notices := pt.notifier.noticed(); len(notices) != 0 {
+		t.Errorf("expected no notices when resuming automatically, got %v", notices)
+	}
+
+	pt.waitForCountdown()
+	pt.clock.Advance(30 * time.Second)
+	pt.waitFor("idle observer", func() bool {
+		return pt.media.observing("idle-active")
+	})
+	pt.media.finish()
+	pt.waitForPlaylist("preview.png", "loading.png", "https://example.com/next")
+}
+
+func TestPlayerKeepsInterruptedSongIfRequeueFails(t *testing.T) {
+	pt := newPlayerTest(t)
+	// The song is not in the queue, so it cannot be requeued.
+	pt.player.interrupted.set(queue.QueuedSong{ID: 42}, 83*time.Second)
+
+	if _, err := pt.player.RequeueInterrupted(); err == nil {
+		t.Fatal("expected requeuing a missing song to fail")
+	}
+	song, position, ok := pt.player.interrupted.take()
+	if !ok || song.ID != 42 || position != 83*time.Second {
+		t.Errorf("expected interrupted song to be kept, got %v %v %v", song.ID, position, ok)
+	}
+}
+
+func TestPlayerRequeuesInterruptedSong(t *testing.T) {
+	pt := newPlayerTest(t)
+	pt.enqueue("Interrupted", "https://example.com/interrupted")
+	pt.enqueue("Next", "https://example.com/next")
+	pt.interrupt(83 * time.Second)
+	pt.run()
+
+	pt.waitFor("notice", func() bool {
+		return len(pt.notifier.noticed()) == 1
+	})
+
+	song, err := pt.player.RequeueInterrupted()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if song.Title != "Interrupted" {
+		t.Errorf("expected Interrupted to be requeued, got %s", song.Title)
+	}
+	if _, err := pt.player.RequeueInterrupted(); !errors.Is(err, errNothingInterrupted) {
+		t.Errorf("expected %v, got %v", errNothingInterrupted, err)
+	}
+	if _, err := pt.nowPlaying(); !errors.Is(err, queue.ErrNothingPlaying) {
+		t.Errorf("expected record to be cleared, got %v", err)
+	}
+
+	pt.player.SetDequeueEnabled(true)
+	pt.waitFor("waiting timer", func() bool {
+		return pt.clock.pendingTimers() == 1
+	})
+	pt.clock.Advance(time.Second)
+	pt.waitForPlaylist("preview.png", "loading.png", "https://example.com/interrupted")
+	if options := pt.media.loadOptions("https://example.com/interrupted"); options != "" {
+		t.Errorf("expected requeued song to start from the beginning, got options %q", options)
+	}
+}
+
+func TestPlayerRetriesFailedSongWithFallbackFormat(t *testing.T) {
+	pt := newPlayerTest(t)
+	pt.enqueue("Song", "https://example.com/song")
+	pt.player.SetDequeueEnabled(true)
+	pt.run()
+
+	pt.waitForCountdown()
+	pt.clock.Advance(30 * time.Second)
+	pt.waitFor("idle observer", func() bool {
+		return pt.media.observing("idle-active")
+	})
+
+	pt.media.fail("https://example.com/song", "merging of formats failed")
+	pt.waitFor("fallback format", func() bool {
+		return pt.media.loadOptions("https://example.com/song") == "ytdl-format=best"
+	})
+	pt.waitFor("idle observer", func() bool {
+		return pt.media.observing("idle-active")
+	})
+	if paused := pt.media.get("pause"); paused != false {
+		t.Errorf("expected retried song to play, got pause %v", paused)
+	}
+
+	pt.media.finish()
+	pt.waitFor("song to finish", func() bool {
+		return pt.player.Current() == nil
+	})
+	if failures := pt.notifier.failures(); len(failures) != 0 {
+		t.Errorf("expected no failures, got %v", failures)
+	}
+}
+
+func TestPlayerMarksSongFailed(t *testing.T) {
+	pt := newPlayerTest(t)
+	pt.enqueue("Song", "https://example.com/song")
+	pt.player.SetDequeueEnabled(true)
+	pt.run()
+
+	pt.waitForCountdown()
+	pt.clock.Advance(30 * time.Second)
+	pt.waitFor("idle observer", func() bool {
+		return pt.media.observing("idle-active")
+	})
+	pt.media.fail("https://example.com/song", "video unavailable")
+	pt.waitFor("fallback format", func() bool {
+		return pt.media.loadOptions("https://example.com/song") == "ytdl-format=best"
+	})
+	pt.waitFor("idle observer", func() bool {
+		return pt.media.observing("idle-active")
+	})
+	pt.media.fail("https://example.com/song", "video unavailable")
+
+	pt.waitFor("song to finish", func() bool {
+		return pt.player.Current() == nil
+	})
+	failures := pt.notifier.failures()
+	if len(failures) != 1 || failures[0].PlayError != "video unavailable" {
+		t.Fatalf("expected requester to be told about the failure, got %v", failures)
+	}
+
+	tx := pt.q.BeginTxn(false)
+	defer tx.Discard()
+	song, err := tx.GetByID(failures[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !song.IsFailed() || song.PlayError != "video unavailable" {
+		t.Errorf("expected song to be marked failed, got %q", song.PlayError)
+	}
+}
+
+type fakeSource map[string]string
+
+func (s fakeSource) localFile(url string) (string, bool) {
+	path, ok := s[url]
+	return path, ok
+}
+
+func TestPlayerPlaysLocalCopy(t *testing.T) {
+	pt := newPlayerTest(t)
+	withMediaSource(fakeSource{"https://example.com/song": "/cache/song.webm"})(pt.player)
+	pt.enqueue("Song", "https://example.com/song")
+	pt.player.SetDequeueEnabled(true)
+	pt.run()
+
+	pt.waitForPlaylist("preview.png", "loading.png", "/cache/song.webm")
+	pt.waitForCountdown()
+	pt.clock.Advance(30 * time.Second)
+	pt.waitFor("idle observer", func() bool {
+		return pt.media.observing("idle-active")
+	})
+
+	pt.media.set("path", "/cache/song.webm")
+	pt.media.set("time-pos", 12.0)
+	pt.clock.Advance(time.Second)
+	pt.waitFor("position to be recorded", func() bool {
+		return pt.player.current.position() == 12*time.Second
+	})
+
+	pt.media.fail("/cache/song.webm", "invalid data")
+	pt.waitForPlaylist("https://example.com/song")
+	if options := pt.media.loadOptions("https://example.com/song"); options != "start=12.000,ytdl-format=best" {
+		t.Errorf("expected failed local copy to be streamed instead, got options %q", options)
+	}
+}
+
+func TestPlayerAppliesSongGain(t *testing.T) {
+	pt := newPlayerTest(t)
+	pt.enqueue("Song", "https://example.com/song")
+	tx := pt.q.BeginTxn(true)
+	song, err := tx.Peek()
+	if err == nil {
+		err = tx.SetGain(song.ID, -4.5)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	tx.Discard()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pt.player.SetDequeueEnabled(true)
+	pt.run()
+
+	pt.waitForCountdown()
+	if options := pt.media.loadOptions("https://example.com/song"); options != "volume-gain=-4.50" {
+		t.Errorf("expected song to be loaded with its gain, got options %q", options)
+	}
+
+	song.Gain = -4.5
+	song.GainAdjust = 2
+	ctx := context.Background()
+	if err := pt.player.UpdateSong(ctx, song); err != nil {
+		t.Fatal(err)
+	}
+	if gain := pt.media.get("volume-gain"); gain != nil {
+		t.Errorf("expected gain to wait for the countdown, got %v", gain)
+	}
+	pt.clock.Advance(30 * time.Second)
+	pt.waitFor("adjusted gain", func() bool {
+		return pt.media.get("volume-gain") == -2.5
+	})
+
+	song.GainAdjust = 3
+	if err := pt.player.UpdateSong(ctx, song); err != nil {
+		t.Fatal(err)
+	}
+	if gain := pt.media.get("volume-gain"); gain != -1.5 {
+		t.Errorf("expected gain to be applied while playing, got %v", gain)
+	}
+}
+
+func TestPlayerAppliesAdjustments(t *testing.T) {
+	pt := newPlayerTest(t)
+	tx := pt.q.BeginTxn(true)
+	_, err := tx.Enqueue(queue.NewSong{
+		UserID:      "1",
+		Title:       "Song",
+		SongURL:     "https://example.com/song",
+		Adjustments: queue.Adjustments{Pitch: 2, Tempo: 1.1},
+	})
+	if err == nil {
+		err = tx.Commit()
+	}
+	tx.Discard()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pt.player.SetDequeueEnabled(true)
+	pt.run()
+
+	pt.waitForCountdown()
+	expected := "af=rubberband=pitch-scale=1.122462,speed=1.10"
+	if options := pt.media.loadOptions("https://example.com/song"); options != expected {
+		t.Errorf("expected options %q, got %q", expected, options)
+	}
+}
+
+func TestPlayerTrimsSong(t *testing.T) {
+	pt := newPlayerTest(t)
+	tx := pt.q.BeginTxn(true)
+	_, err := tx.Enqueue(queue.NewSong{
+		UserID:   "1",
+		Title:    "Song",
+		SongURL:  "https://example.com/song",
+		Duration: 4 * time.Minute,
+		Start:    45 * time.Second,
+		End:      3 * time.Minute,
+	})
+	if err == nil {
+		err = tx.Commit()
+	}
+	tx.Discard()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pt.player.SetDequeueEnabled(true)
+	pt.run()
+
+	pt.waitForCountdown()
+	expected := "end=180.000,start=45.000"
+	if options := pt.media.loadOptions("https://example.com/song"); options != expected {
+		t.Errorf("expected options %q, got %q", expected, options)
+	}
+}
+
+func TestPlayerPlaysAudioOnly(t *testing.T) {
+	pt := newPlayerTest(t)
+	tx := pt.q.BeginTxn(true)
+	_, err := tx.Enqueue(queue.NewSong{
+		UserID:    "1",
+		Title:     "Song",
+		SongURL:   "https://example.com/song",
+		Duration:  4 * time.Minute,
+		AudioOnly: true,
+	})
+	if err == nil {
+		err = tx.Commit()
+	}
+	tx.Discard()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pt.player.SetDequeueEnabled(true)
+	pt.run()
+
+	pt.waitForCountdown()
+	expected := "vid=no,ytdl-format=bestaudio/best"
+	if options := pt.media.loadOptions("https://example.com/song"); options != expected {
+		t.Errorf("expected options %q, got %q", expected, options)
+	}
+
+	pt.clock.Advance(30 * time.Second)
+	pt.playAt("https://example.com/song", time.Minute)
+	pt.waitFor("progress bar", func() bool {
+		frames := pt.posters.progressFrames()
+		return len(frames) > 0 && frames[len(frames)-1] == time.Minute
+	})
+	overlays := pt.media.shownOverlays()
+	if overlays[audioPosterOverlayID] != "poster.bgra" || overlays[progressOverlayID] != "progress.bgra" {
+		t.Errorf("expected poster and progress bar to be shown, got %v", overlays)
+	}
+
+	pt.media.finish()
+	pt.waitFor("song to finish", func() bool {
+		return pt.player.Current() == nil
+	})
+	if overlays := pt.media.shownOverlays(); len(overlays) != 0 {
+		t.Errorf("expected overlays to be removed, got %v", overlays)
+	}
+}
+
+func TestPlayerPlaysEverySongAudioOnly(t *testing.T) {
+	pt := newPlayerTest(t)
+	withAudioOnly(true)(pt.player)
+	pt.enqueue("Song", "https://example.com/song")
+	pt.player.SetDequeueEnabled(true)
+	pt.run()
+
+	pt.waitForCountdown()
+	expected := "vid=no,ytdl-format=bestaudio/best"
+	if options := pt.media.loadOptions("https://example.com/song"); options != expected {
+		t.Errorf("expected options %q, got %q", expected, options)
+	}
+}
+
+func TestPlayerRecordsPlayTimes(t *testing.T) {
+	pt := newPlayerTest(t)
+	pt.enqueue("Song", "https://example.com/song")
+	pt.player.SetDequeueEnabled(true)
+	pt.run()
+
+	pt.waitForCountdown()
+	countdown := pt.clock.Now()
+	pt.clock.Advance(30 * time.Second)
+	pt.waitFor("idle observer", func() bool {
+		return pt.media.observing("idle-active")
+	})
+	pt.clock.Advance(3 * time.Minute)
+	pt.media.finish()
+
+	var song queue.QueuedSong
+	pt.waitFor("end time", func() bool {
+		tx := pt.q.BeginTxn(false)
+		defer tx.Discard()
+		var err error
+		song, err = tx.LastDequeued()
+		return err == nil && !song.Times.Ended.IsZero()
+	})
+	times := song.Times
+	if !times.Countdown.Equal(countdown) {
+		t.Errorf("expected countdown at %v, got %v", countdown, times.Countdown)
+	}
+	if d := times.Started.Sub(times.Countdown); d != 30*time.Second {
+		t.Errorf("expected song to start 30s after the countdown, got %v", d)
+	}
+	if d := times.Ended.Sub(times.Started); d < 3*time.Minute {
+		t.Errorf("expected song to end 3m after it started, got %v", d)
+	}
+}
+
+// fakeAnnouncer names clips after the text spoken in them.
+type fakeAnnouncer struct {
+	mu    sync.Mutex
+	texts []string
+}
+
+func (a *fakeAnnouncer) speak(_ context.Context, text string) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.texts = append(a.texts, text)
+	return "/clips/" + text + ".wav", nil
+}
+
+func TestPlayerAnnouncesSong(t *testing.T) {
+	pt := newPlayerTest(t)
+	withAnnouncer(&fakeAnnouncer{})(pt.player)
+	pt.enqueue("Song", "https://example.com/song")
+	pt.player.SetDequeueEnabled(true)
+	pt.run()
+
+	clip := "/clips/Next up: user 1 with Song.wav"
+	pt.waitForPlaylist("preview.png", clip, "loading.png", "https://example.com/song")
+	if options := pt.media.loadOptions(clip); options != "cover-art-files=preview.png" {
+		t.Errorf("expected poster to be shown during announcement, got %q", options)
+	}
+}
+
+func TestPlayerSkipsAnnouncementForOptedOutSinger(t *testing.T) {
+	pt := newPlayerTest(t)
+	announcer := &fakeAnnouncer{}
+	withAnnouncer(announcer)(pt.player)
+	tx := pt.q.BeginTxn(true)
+	if err := tx.SetUserSettings("1", queue.UserSettings{NoAnnouncement: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	pt.enqueue("Song", "https://example.com/song")
+	pt.player.SetDequeueEnabled(true)
+	pt.run()
+
+	pt.waitForPlaylist("preview.png", "loading.png", "https://example.com/song")
+	announcer.mu.Lock()
+	defer announcer.mu.Unlock()
+	if len(announcer.texts) != 0 {
+		t.Errorf("expected no announcement, got %q", announcer.texts)
+	}
+}
+
+// playAt reports the song at url to be playing at the given position,
+// waiting until the player has polled it.
+func (pt *playerTest) playAt(url string, position time.Duration) {
+	pt.t.Helper()
+	pt.media.set("path", url)
+	pt.media.set("time-pos", position.Seconds())
+	pt.waitFor("position to be recorded", func() bool {
+		pt.clock.Advance(time.Second)
+		return pt.player.current.position() == position
+	})
+}
+
+// queuedTitles returns the titles of the songs in the queue.
+func (pt *playerTest) queuedTitles() []string {
+	pt.t.Helper()
+	songs, err := pt.player.list()
+	if err != nil {
+		pt.t.Fatal(err)
+	}
+	var titles []string
+	for _, song := range songs {
+		titles = append(titles, song.Title)
+	}
+	return titles
+}
+
+func TestPlayerWaitsForReadySinger(t *testing.T) {
+	pt := newPlayerTest(t)
+	withReadyCheck(time.Minute, 1, 2)(pt.player)
+	pt.enqueue("Song", "https://example.com/song")
+	pt.player.SetDequeueEnabled(true)
+	pt.run()
+
+	pt.waitForCountdown()
+	if readyWithin := pt.notifier.readyWithin; readyWithin != time.Minute {
+		t.Errorf("expected heads up to ask for the singer within 1m, got %v", readyWithin)
+	}
+
+	pt.clock.Advance(30 * time.Second)
+	pt.waitForCountdown()
+	if paused := pt.media.get("pause"); paused != true {
+		t.Errorf("expected song to wait for the singer, got pause %v", paused)
+	}
+
+	if pt.player.Ready(pt.player.Current().ID + 1) {
+		t.Error("expected another song not to be marked ready")
+	}
+	if !pt.player.Ready(pt.player.Current().ID) {
+		t.Fatal("expected current song to be marked ready")
+	}
+	pt.waitFor("idle observer", func() bool {
+		return pt.media.observing("idle-active")
+	})
+	if paused := pt.media.get("pause"); paused != false {
+		t.Errorf("expected song to start once the singer is ready, got pause %v", paused)
+	}
+}
+
+func TestPlayerMovesBackNoShows(t *testing.T) {
+	pt := newPlayerTest(t)
+	withReadyCheck(time.Minute, 1, 2)(pt.player)
+	pt.enqueue("First", "https://example.com/first")
+	pt.enqueue("Second", "https://example.com/second")
+	pt.enqueue("Third", "https://example.com/third")
+	pt.player.SetDequeueEnabled(true)
+	pt.run()
+
+	// The clock keeps moving until the next song is up.
+	missTurn := func(next string) {
+		t.Helper()
+		expected := []string{"preview.png", "loading.png", next}
+		pt.waitFor("turn to be missed", func() bool {
+			pt.clock.Advance(time.Second)
+			return slices.Equal(pt.media.getPlaylist(), expected)
+		})
+	}
+
+	pt.waitForPlaylist("preview.png", "loading.png", "https://example.com/first")
+	missTurn("https://example.com/second")
+	if titles := pt.queuedTitles(); !slices.Equal(titles, []string{"First", "Third"}) {
+		t.Errorf("expected First to be moved back by one, got %v", titles)
+	}
+
+	pt.player.Skip()
+	pt.waitForPlaylist("preview.png", "loading.png", "https://example.com/first")
+	missTurn("https://example.com/third")
+	if titles := pt.queuedTitles(); len(titles) != 0 {
+		t.Errorf("expected First to be removed, got %v", titles)
+	}
+
+	expected := []string{
+		"First was moved back in the queue because its singer did not show up.",
+		"First was removed after its singer missed 2 turns.",
+	}
+	if notices := pt.notifier.noticed(); !slices.Equal(notices, expected) {
+		t.Errorf("expected notices %q, got %q", expected, notices)
+	}
+
+	tx := pt.q.BeginTxn(false)
+	defer tx.Discard()
+	stats, err := tx.GetUserStats("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.NoShowCount != 2 {
+		t.Errorf("expected 2 no-shows, got %d", stats.NoShowCount)
+	}
+}
+
+func TestPlayerPushesBackSong(t *testing.T) {
+	pt := newPlayerTest(t)
+	withReadyCheck(time.Minute, 1, 2)(pt.player)
+	pt.enqueue("First", "https://example.com/first")
+	pt.enqueue("Second", "https://example.com/second")
+	pt.enqueue("Third", "https://example.com/third")
+	pt.player.SetDequeueEnabled(true)
+	pt.run()
+
+	pt.waitForCountdown()
+	if _, err := pt.player.PushBack(pt.player.Current().ID + 1); !errors.Is(err, errNotCurrentSong) {
+		t.Errorf("expected %v, got %v", errNotCurrentSong, err)
+	}
+	requeued, err := pt.player.PushBack(pt.player.Current().ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requeued.Title != "First" {
+		t.Errorf("expected First to be requeued, got %v", requeued)
+	}
+
+	pt.waitForPlaylist("preview.png", "loading.png", "https://example.com/second")
+	if titles := pt.queuedTitles(); !slices.Equal(titles, []string{"First", "Third"}) {
+		t.Errorf("expected First to be moved back by one, got %v", titles)
+	}
+}
+
+func TestPlayerHotkeysExtendCountdown(t *testing.T) {
+	pt := newPlayerTest(t)
+	withHotkeys(map[hotkeyAction]string{hotkeyExtend: "e"}, 30*time.Second)(pt.player)
+	pt.enqueue("Song", "https://example.com/song")
+	pt.player.SetDequeueEnabled(true)
+	pt.run()
+
+	pt.waitForCountdown()
+	start := pt.clock.Now()
+	pt.waitFor("extend hotkey", func() bool { return pt.media.press("e") })
+	pt.waitFor("countdown to be extended", func() bool {
+		return pt.media.osdContains("Countdown extended by 30s.")
+	})
+	pt.waitFor("now playing update", func() bool {
+		statuses := pt.notifier.statuses()
+		last := statuses[len(statuses)-1]
+		return last.phase == nowPlayingCountdown && last.startAt.Equal(start.Add(time.Minute))
+	})
+
+	pt.clock.Advance(30 * time.Second)
+	pt.waitForCountdown()
+	if paused := pt.media.get("pause"); paused != true {
+		t.Errorf("expected countdown to be extended, got pause %v", paused)
+	}
+	pt.clock.Advance(30 * time.Second)
+	pt.waitFor("idle observer", func() bool {
+		return pt.media.observing("idle-active")
+	})
+	if paused := pt.media.get("pause"); paused != false {
+		t.Errorf("expected song to start after the extended countdown, got pause %v", paused)
+	}
+
+	pt.media.press("e")
+	pt.waitFor("extend result", func() bool {
+		return pt.media.osdContains("There is no countdown to extend.")
+	})
+}
+
+func TestPlayerHotkeysRequeueAndSkip(t *testing.T) {
+	pt := newPlayerTest(t)
+	withHotkeys(map[hotkeyAction]string{hotkeyRequeue: "r", hotkeySkip: "n", hotkeyToggle: "t"}, 30*time.Second)(pt.player)
+	pt.enqueue("First", "https://example.com/first")
+	pt.enqueue("Second", "https://example.com/second")
+	pt.player.SetDequeueEnabled(true)
+	pt.run()
+
+	pt.waitForCountdown()
+	first := pt.player.Current().ID
+	pt.waitFor("requeue hotkey", func() bool { return pt.media.press("r") })
+	pt.waitFor("song to be requeued", func() bool {
+		return pt.media.osdContains("Requeued First at the front of the queue.")
+	})
+	pt.waitFor("song to be played again", func() bool {
+		current := pt.player.Current()
+		return current != nil && current.ID != first && current.Title == "First"
+	})
+	if titles := pt.queuedTitles(); !slices.Equal(titles, []string{"Second"}) {
+		t.Errorf("expected only Second to be queued, got %v", titles)
+	}
+
+	pt.media.press("t")
+	pt.media.press("n")
+	pt.waitFor("song to be skipped", func() bool {
+		return pt.media.osdContains("Skipped First.")
+	})
+	pt.waitFor("queue to be stopped", func() bool {
+		return pt.media.osdContains("Queue playback stopped.")
+	})
+	pt.waitFor("song to be stopped", func() bool {
+		return pt.player.Current() == nil
+	})
+	if titles := pt.queuedTitles(); !slices.Equal(titles, []string{"Second"}) {
+		t.Errorf("expected Second to stay queued, got %v", titles)
+	}
+
+	pt.media.press("t")
+	pt.waitFor("queue to be started", func() bool {
+		return pt.media.osdContains("Queue playback started.")
+	})
+}
+
+func TestPlayerEnforcesMaxPlayTime(t *testing.T) {
+	pt := newPlayerTest(t)
+	withMaxPlayTime(2 * time.Minute)(pt.player)
+	pt.enqueue("Song", "https://example.com/song")
+	pt.player.SetDequeueEnabled(true)
+	pt.run()
+
+	pt.waitForCountdown()
+	pt.clock.Advance(30 * time.Second)
+	pt.playAt("https://example.com/song", 100*time.Second)
+	pt.waitFor("time limit warning", func() bool {
+		return pt.media.osdContains("Time limit reached in 0:20")
+	})
+	if pt.media.commandCount("stop") != 0 {
+		t.Fatal("expected song to keep playing before the time limit")
+	}
+
+	pt.playAt("https://example.com/song", 2*time.Minute)
+	pt.waitFor("song to fade out", func() bool {
+		pt.clock.Advance(fadeOutDuration / fadeOutSteps)
+		return pt.player.Current() == nil
+	})
+	if pt.media.commandCount("stop") != 1 {
+		t.Error("expected song to be stopped")
+	}
+	if volume := pt.media.get("volume"); volume != 100.0 {
+		t.Errorf("expected volume to be restored, got %v", volume)
+	}
+}
+
+func TestPlayerCountsMaxPlayTimeAtTempo(t *testing.T) {
+	pt := newPlayerTest(t)
+	withMaxPlayTime(2 * time.Minute)(pt.player)
+	tx := pt.q.BeginTxn(true)
+	_, err := tx.Enqueue(queue.NewSong{
+		UserID:      "1",
+		Title:       "Song",
+		SongURL:     "https://example.com/song",
+		Adjustments: queue.Adjustments{Tempo: 0.5},
+	})
+	if err == nil {
+		err = tx.Commit()
+	}
+	tx.Discard()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pt.player.SetDequeueEnabled(true)
+	pt.run()
+
+	pt.waitForCountdown()
+	pt.clock.Advance(30 * time.Second)
+	// At half speed, 50 seconds of the song take 100 seconds to play.
+	pt.playAt("https://example.com/song", 50*time.Second)
+	pt.waitFor("time limit warning", func() bool {
+		return pt.media.osdContains("Time limit reached in 0:20")
+	})
+	if pt.media.commandCount("stop") != 0 {
+		t.Fatal("expected song to keep playing before the time limit")
+	}
+
+	pt.playAt("https://example.com/song", time.Minute)
+	pt.waitFor("song to fade out", func() bool {
+		pt.clock.Advance(fadeOutDuration / fadeOutSteps)
+		return pt.player.Current() == nil
+	})
+}
+
+func TestPlayerExemptsSongFromMaxPlayTime(t *testing.T) {
+	pt := newPlayerTest(t)
+	withMaxPlayTime(2 * time.Minute)(pt.player)
+	pt.enqueue("Song", "https://example.com/song")
+	pt.player.SetDequeueEnabled(true)
+	pt.run()
+
+	pt.waitForCountdown()
+	song := *pt.player.Current()
+	song.Unlimited = true
+	if err := pt.player.UpdateSong(context.Background(), song); err != nil {
+		t.Fatal(err)
+	}
+	pt.clock.Advance(30 * time.Second)
+	pt.playAt("https://example.com/song", 3*time.Minute)
+	pt.playAt("https://example.com/song", 3*time.Minute+time.Second)
+
+	if pt.media.commandCount("stop") != 0 || pt.player.Current() == nil {
+		t.Error("expected exempt song to keep playing")
+	}
+}
+
+func TestPlayerAddsSubtitles(t *testing.T) {
+	pt := newPlayerTest(t)
+	tx := pt.q.BeginTxn(true)
+	_, err := tx.Enqueue(queue.NewSong{
+		UserID:           "1",
+		Title:            "Song",
+		SongURL:          "https://example.com/song",
+		Subtitles:        "subtitles/song.en.vtt",
+		SubtitleLanguage: "en",
+	})
+	if err == nil {
+		err = tx.Commit()
+	}
+	tx.Discard()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pt.player.SetDequeueEnabled(true)
+	pt.run()
+
+	pt.waitForCountdown()
+	pt.media.start("preview.png")
+	pt.clock.Advance(30 * time.Second)
+	pt.media.start("loading.png")
+	pt.media.start("https://example.com/song")
+	pt.waitFor("subtitles", func() bool {
+		return slices.Equal(pt.media.addedSubtitles(), []string{"subtitles/song.en.vtt"})
+	})
+}
+
+func TestPlayerStatus(t *testing.T) {
+	pt := newPlayerTest(t)
+
+	status, err := pt.player.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != "Idle, volume 100%." {
+		t.Errorf("unexpected idle status %q", status)
+	}
+
+	pt.media.set("idle-active", false)
+	pt.media.set("pause", true)
+	pt.media.set("time-pos", 83.0)
+	pt.media.set("duration", 3725.0)
+	status, err = pt.player.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != "Paused at 1:23 / 1:02:05, volume 100%." {
+		t.Errorf("unexpected paused status %q", status)
+	}
+}
+
+func TestParseSeekPosition(t *testing.T) {
+	tests := []struct {
+		input    string
+		position time.Duration
+		relative bool
+		err      bool
+	}{
+		{"90", 90 * time.Second, false, false},
+		{"1:30", 90 * time.Second, false, false},
+		{"1:02:03", time.Hour + 2*time.Minute + 3*time.Second, false, false},
+		{"+10", 10 * time.Second, true, false},
+		{"-1:00", -time.Minute, true, false},
+		{"", 0, false, true},
+		{"1:2:3:4", 0, false, true},
+		{"abc", 0, false, true},
+	}
+
+	for _, test := range tests {
+		position, relative, err := parseSeekPosition(test.input)
+		if (err != nil) != test.err {
+			t.Errorf("%q: unexpected error %v", test.input, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if position != test.position || relative != test.relative {
+			t.Errorf("%q: expected %v (relative %v), got %v (relative %v)", test.input, test.position, test.relative, position, relative)
+		}
+	}
+}
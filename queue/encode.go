@@ -4,6 +4,7 @@ import (
 	"encoding"
 	"encoding/binary"
 	"errors"
+	"math"
 	"time"
 )
 
@@ -26,20 +27,62 @@ func (qtx *QueueTx) setMarshaledValue(k []byte, v encoding.BinaryMarshaler) (err
 }
 
 func (s UserStats) MarshalBinary() (b []byte, err error) {
-	b = make([]byte, 6)
+	b = make([]byte, 8)
 	binary.BigEndian.PutUint16(b[0:2], s.QueuedCount)
 	binary.BigEndian.PutUint16(b[2:4], s.DequeuedCount)
 	binary.BigEndian.PutUint16(b[4:6], s.DeletedCount)
+	binary.BigEndian.PutUint16(b[6:8], s.NoShowCount)
 	return
 }
 
+// UnmarshalBinary also accepts stats written before NoShowCount existed.
 func (s *UserStats) UnmarshalBinary(b []byte) error {
-	if len(b) != 6 {
+	if len(b) != 6 && len(b) != 8 {
 		return errors.New("invalid length")
 	}
 	s.QueuedCount = binary.BigEndian.Uint16(b[0:2])
 	s.DequeuedCount = binary.BigEndian.Uint16(b[2:4])
 	s.DeletedCount = binary.BigEndian.Uint16(b[4:6])
+	if len(b) == 8 {
+		s.NoShowCount = binary.BigEndian.Uint16(b[6:8])
+	}
+	return nil
+}
+
+// User settings are stored as a byte of flags.
+const userSettingNoAnnouncement byte = 1 << iota
+
+func (s UserSettings) MarshalBinary() (b []byte, err error) {
+	var flags byte
+	if s.NoAnnouncement {
+		flags |= userSettingNoAnnouncement
+	}
+	return []byte{flags}, nil
+}
+
+func (s *UserSettings) UnmarshalBinary(b []byte) error {
+	if len(b) != 1 {
+		return errors.New("invalid length")
+	}
+	s.NoAnnouncement = b[0]&userSettingNoAnnouncement != 0
+	return nil
+}
+
+func (np NowPlaying) MarshalBinary() (b []byte, err error) {
+	b = make([]byte, 17)
+	binary.BigEndian.PutUint64(b[0:8], uint64(np.SongID))
+	b[8] = byte(np.Phase)
+	binary.BigEndian.PutUint64(b[9:17], uint64(np.Position))
+	return
+}
+
+func (np *NowPlaying) UnmarshalBinary(b []byte) error {
+	if len(b) != 17 {
+		return errors.New("invalid length")
+	}
+	np.SongID = int(binary.BigEndian.Uint64(b[0:8]))
+	np.Phase = PlaybackPhase(b[8])
+	np.Position = time.Duration(binary.BigEndian.Uint64(b[9:17]))
 	return nil
 }
 
@@ -63,6 +106,40 @@ func (qs *QueuedSong) MarshalBinary() ([]byte, error) {
 	}
 	binary.BigEndian.PutUint64(buf[40:], uint64(qs.Duration))
 	writeStrings(buf[48:], qs.UserID, qs.Title, qs.SongURL, qs.ThumbnailURL, qs.Slug)
+	if qs.PlayError != "" {
+		buf = appendField(buf, fieldPlayError, []byte(qs.PlayError))
+	}
+	if qs.GainMeasured {
+		buf = appendField(buf, fieldGain, binary.BigEndian.AppendUint64(nil, math.Float64bits(qs.Gain)))
+	}
+	if !qs.Adjustments.IsZero() {
+		buf = appendField(buf, fieldAdjustments, qs.Adjustments.appendBinary(nil))
+	}
+	if qs.Subtitles != "" {
+		buf = appendField(buf, fieldSubtitles, []byte(qs.Subtitles))
+	}
+	if qs.SubtitleLanguage != "" {
+		buf = appendField(buf, fieldSubtitleLanguage, []byte(qs.SubtitleLanguage))
+	}
+	if qs.IsTrimmed() {
+		trim := binary.BigEndian.AppendUint64(nil, uint64(qs.Start))
+		buf = appendField(buf, fieldTrim, binary.BigEndian.AppendUint64(trim, uint64(qs.End)))
+	}
+	if qs.Unlimited {
+		buf = appendField(buf, fieldUnlimited, nil)
+	}
+	if qs.GainAdjust != 0 {
+		buf = appendField(buf, fieldGainAdjust, binary.BigEndian.AppendUint64(nil, math.Float64bits(qs.GainAdjust)))
+	}
+	if qs.NoShows != 0 {
+		buf = appendField(buf, fieldNoShows, binary.BigEndian.AppendUint32(nil, uint32(qs.NoShows)))
+	}
+	if qs.AudioOnly {
+		buf = appendField(buf, fieldAudioOnly, nil)
+	}
+	if !qs.Times.IsZero() {
+		buf = appendField(buf, fieldTimes, qs.Times.appendBinary(nil))
+	}
 	return buf, nil
 }
 
@@ -75,7 +152,128 @@ func (qs *QueuedSong) UnmarshalBinary(data []byte) error {
 		return err
 	}
 	qs.Duration = time.Duration(binary.BigEndian.Uint64(data[40:48]))
-	readStrings(data[48:], &qs.UserID, &qs.Title, &qs.SongURL, &qs.ThumbnailURL, &qs.Slug)
+	n := readStrings(data[48:], &qs.UserID, &qs.Title, &qs.SongURL, &qs.ThumbnailURL, &qs.Slug)
+	return readFields(data[48+n:], func(field songField, value []byte) {
+		switch field {
+		case fieldPlayError:
+			qs.PlayError = string(value)
+		case fieldGain:
+			qs.Gain, qs.GainMeasured = readFloat(value)
+		case fieldGainAdjust:
+			qs.GainAdjust, _ = readFloat(value)
+		case fieldAdjustments:
+			qs.Adjustments.readBinary(value)
+		case fieldSubtitles:
+			qs.Subtitles = string(value)
+		case fieldSubtitleLanguage:
+			qs.SubtitleLanguage = string(value)
+		case fieldUnlimited:
+			qs.Unlimited = true
+		case fieldAudioOnly:
+			qs.AudioOnly = true
+		case fieldTimes:
+			qs.Times.readBinary(value)
+		case fieldNoShows:
+			if len(value) == 4 {
+				qs.NoShows = int(binary.BigEndian.Uint32(value))
+			}
+		case fieldTrim:
+			if len(value) == 16 {
+				qs.Start = time.Duration(binary.BigEndian.Uint64(value[0:8]))
+				qs.End = time.Duration(binary.BigEndian.Uint64(value[8:16]))
+			}
+		}
+	})
+}
+
+// songField identifies an optional field stored after the fixed part of
+// an encoded QueuedSong. Fields that are not set are omitted, so songs
+// written before a field existed decode with its zero value.
+type songField uint8
+
+const (
+	fieldPlayError songField = iota + 1
+	fieldGain
+	fieldGainAdjust
+	fieldAdjustments
+	fieldSubtitles
+	fieldSubtitleLanguage
+	fieldTrim
+	fieldUnlimited
+	fieldNoShows
+	fieldAudioOnly
+	fieldTimes
+)
+
+func (a Adjustments) appendBinary(buf []byte) []byte {
+	buf = binary.BigEndian.AppendUint32(buf, uint32(int32(a.Pitch)))
+	buf = binary.BigEndian.AppendUint64(buf, math.Float64bits(a.Tempo))
+	if a.VocalReduction {
+		return append(buf, 1)
+	}
+	return append(buf, 0)
+}
+
+func (a *Adjustments) readBinary(value []byte) {
+	if len(value) != 13 {
+		return
+	}
+	a.Pitch = int(int32(binary.BigEndian.Uint32(value[0:4])))
+	a.Tempo = math.Float64frombits(binary.BigEndian.Uint64(value[4:12]))
+	a.VocalReduction = value[12] != 0
+}
+
+// Play times are stored as Unix nanoseconds, zero for times not reached.
+func (t PlayTimes) appendBinary(buf []byte) []byte {
+	for _, tt := range []time.Time{t.Predicted, t.Countdown, t.Started, t.Ended} {
+		var nanos int64
+		if !tt.IsZero() {
+			nanos = tt.UnixNano()
+		}
+		buf = binary.BigEndian.AppendUint64(buf, uint64(nanos))
+	}
+	return buf
+}
+
+func (t *PlayTimes) readBinary(value []byte) {
+	if len(value) != 32 {
+		return
+	}
+	for i, tt := range []*time.Time{&t.Predicted, &t.Countdown, &t.Started, &t.Ended} {
+		if nanos := int64(binary.BigEndian.Uint64(value[i*8:])); nanos != 0 {
+			*tt = time.Unix(0, nanos)
+		}
+	}
+}
+
+func readFloat(value []byte) (float64, bool) {
+	if len(value) != 8 {
+		return 0, false
+	}
+	return math.Float64frombits(binary.BigEndian.Uint64(value)), true
+}
+
+func appendField(buf []byte, field songField, value []byte) []byte {
+	buf = append(buf, byte(field))
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(value)))
+	return append(buf, value...)
+}
+
+// readFields calls f for each optional field. Unknown fields are passed
+// on as well and should be ignored.
+func readFields(buf []byte, f func(field songField, value []byte)) error {
+	for len(buf) > 0 {
+		if len(buf) < 5 {
+			return errors.New("invalid field header")
+		}
+		field := songField(buf[0])
+		l := int(binary.BigEndian.Uint32(buf[1:5]))
+		if len(buf) < 5+l {
+			return errors.New("invalid field length")
+		}
+		f(field, buf[5:5+l])
+		buf = buf[5+l:]
+	}
 	return nil
 }
 
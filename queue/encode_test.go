@@ -83,3 +83,112 @@ func TestEncoding(t *testing.T) {
 		t.Fatalf("expected zero, got %v", s4.DequeuedAt)
 	}
 }
+
+func TestEncodingOptionalFields(t *testing.T) {
+	s := queue.QueuedSong{
+		NewSong: queue.NewSong{
+			UserID: "user",
+			Title:  "title",
+			Adjustments: queue.Adjustments{
+				Pitch:          -2,
+				Tempo:          0.9,
+				VocalReduction: true,
+			},
+			Subtitles:        "/subtitles/song.ja.vtt",
+			SubtitleLanguage: "ja",
+			Start:            45 * time.Second,
+			End:              3 * time.Minute,
+			AudioOnly:        true,
+		},
+		ID:           1,
+		Slug:         "slug",
+		PlayError:    "loading failed",
+		Gain:         -3.5,
+		GainMeasured: true,
+		GainAdjust:   2,
+		Unlimited:    true,
+		NoShows:      2,
+		Times: queue.PlayTimes{
+			Predicted: time.Unix(1000, 0),
+			Countdown: time.Unix(1100, 0),
+			Started:   time.Unix(1130, 500),
+		},
+	}
+
+	b, err := s.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var s2 queue.QueuedSong
+	if err := s2.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	if s2.PlayError != s.PlayError {
+		t.Fatalf("expected %s, got %s", s.PlayError, s2.PlayError)
+	}
+	if s2.Slug != s.Slug {
+		t.Fatalf("expected %s, got %s", s.Slug, s2.Slug)
+	}
+	if s2.Subtitles != s.Subtitles || s2.SubtitleLanguage != s.SubtitleLanguage {
+		t.Fatalf("expected subtitles %s (%s), got %s (%s)", s.Subtitles, s.SubtitleLanguage, s2.Subtitles, s2.SubtitleLanguage)
+	}
+	if !s2.Unlimited {
+		t.Fatal("expected song to be unlimited")
+	}
+	if !s2.AudioOnly {
+		t.Fatal("expected song to be audio only")
+	}
+	if s2.NoShows != s.NoShows {
+		t.Fatalf("expected %d no-shows, got %d", s.NoShows, s2.NoShows)
+	}
+	if !s2.Times.Predicted.Equal(s.Times.Predicted) || !s2.Times.Countdown.Equal(s.Times.Countdown) ||
+		!s2.Times.Started.Equal(s.Times.Started) || !s2.Times.Ended.IsZero() {
+		t.Fatalf("expected times %+v, got %+v", s.Times, s2.Times)
+	}
+	if s2.Start != s.Start || s2.End != s.End {
+		t.Fatalf("expected trim %v-%v, got %v-%v", s.Start, s.End, s2.Start, s2.End)
+	}
+	if s2.Adjustments != s.Adjustments {
+		t.Fatalf("expected %+v, got %+v", s.Adjustments, s2.Adjustments)
+	}
+	if s2.Gain != s.Gain || !s2.GainMeasured || s2.GainAdjust != s.GainAdjust {
+		t.Fatalf("expected gain %v%+v, got %v%+v", s.Gain, s.GainAdjust, s2.Gain, s2.GainAdjust)
+	}
+
+	// Songs encoded before optional fields existed end after the slug.
+	s.PlayError = ""
+	s.GainMeasured = false
+	s.GainAdjust = 0
+	s.Unlimited = false
+	s.AudioOnly = false
+	s.Adjustments = queue.Adjustments{Tempo: 1}
+	b, err = s.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s3 queue.QueuedSong
+	if err := s3.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	if s3.PlayError != "" {
+		t.Fatalf("expected no error, got %s", s3.PlayError)
+	}
+	if s3.GainMeasured || s3.Gain != 0 {
+		t.Fatalf("expected no gain, got %v", s3.Gain)
+	}
+	if s3.Adjustments != (queue.Adjustments{}) {
+		t.Fatalf("expected no adjustments, got %+v", s3.Adjustments)
+	}
+
+	// Unknown fields written by newer versions are skipped.
+	b = append(b, 0xff, 0, 0, 0, 2, 'h', 'i')
+	var s4 queue.QueuedSong
+	if err := s4.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s4.UnmarshalBinary(b[:len(b)-1]); err == nil {
+		t.Fatal("expected error for truncated field")
+	}
+}
@@ -0,0 +1,48 @@
+package queue
+
+import (
+	"errors"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// PlaybackPhase is how far playback of a song has progressed.
+type PlaybackPhase uint8
+
+const (
+	// PhaseCountdown is set while the preview and countdown are shown.
+	PhaseCountdown PlaybackPhase = iota
+	// PhasePlaying is set once the song has started.
+	PhasePlaying
+)
+
+// NowPlaying records the song being played so that it can be resumed
+// if playback is interrupted.
+type NowPlaying struct {
+	SongID   int
+	Phase    PlaybackPhase
+	Position time.Duration
+}
+
+var ErrNothingPlaying = errors.New("nothing is playing")
+
+// NowPlaying returns the song being played. Returns ErrNothingPlaying
+// if no song is being played.
+func (qtx *QueueTx) NowPlaying() (np NowPlaying, err error) {
+	err = qtx.getUnmarshaledValue([]byte{byte(recordTypeNowPlaying)}, &np)
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		err = ErrNothingPlaying
+	}
+	return
+}
+
+// SetNowPlaying records the song being played.
+func (qtx *QueueTx) SetNowPlaying(np NowPlaying) error {
+	return qtx.setMarshaledValue([]byte{byte(recordTypeNowPlaying)}, np)
+}
+
+// ClearNowPlaying removes the record of the song being played.
+func (qtx *QueueTx) ClearNowPlaying() error {
+	return qtx.txn.Delete([]byte{byte(recordTypeNowPlaying)})
+}
@@ -1,6 +1,7 @@
 package queue_test
 
 import (
+	"slices"
 	"testing"
 	"time"
 
@@ -117,6 +118,61 @@ func TestQueueUpdate(t *testing.T) {
 	}
 }
 
+func TestQueueUpdateURLClearsGain(t *testing.T) {
+	q, err := queue.OpenQueue(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	tx := q.BeginTxn(true)
+	defer tx.Discard()
+
+	id, err := tx.Enqueue(tests[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.SetGain(id, -6); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.AdjustGain(id, 2, 15); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.MarkFailed(id, "video unavailable"); err != nil {
+		t.Fatal(err)
+	}
+
+	song, err := tx.GetByID(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adjusted := song.NewSong
+	adjusted.Title = "Adjusted"
+	if err := tx.Update(id, adjusted); err != nil {
+		t.Fatal(err)
+	}
+	if song, err = tx.GetByID(id); err != nil {
+		t.Fatal(err)
+	}
+	if !song.GainMeasured || song.Gain != -6 || song.PlayError == "" {
+		t.Errorf("expected gain and play error to be kept for the same URL, got %v (measured %v), %q", song.Gain, song.GainMeasured, song.PlayError)
+	}
+
+	swapped := song.NewSong
+	swapped.SongURL = "https://youtu.be/YKEhO5jhP3g"
+	if err := tx.Update(id, swapped); err != nil {
+		t.Fatal(err)
+	}
+	if song, err = tx.GetByID(id); err != nil {
+		t.Fatal(err)
+	}
+	if song.GainMeasured || song.Gain != 0 || song.PlayError != "" {
+		t.Errorf("expected gain and play error of the old URL to be cleared, got %v (measured %v), %q", song.Gain, song.GainMeasured, song.PlayError)
+	}
+	if song.GainAdjust != 2 {
+		t.Errorf("expected manual gain adjustment to be kept, got %v", song.GainAdjust)
+	}
+}
 func TestQueueLastDequeued(t *testing.T) {
 	q, err := queue.OpenQueue(":memory:")
 	if err != nil {
@@ -353,6 +409,262 @@ func TestUserStats(t *testing.T) {
 	}
 }
 
+func TestNowPlaying(t *testing.T) {
+	q, err := queue.OpenQueue(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	tx := q.BeginTxn(true)
+	defer tx.Discard()
+
+	_, err = tx.NowPlaying()
+	if err != queue.ErrNothingPlaying {
+		t.Errorf("expected %v, got %v", queue.ErrNothingPlaying, err)
+	}
+
+	expected := queue.NowPlaying{
+		SongID:   3,
+		Phase:    queue.PhasePlaying,
+		Position: 83 * time.Second,
+	}
+	if err = tx.SetNowPlaying(expected); err != nil {
+		t.Fatal(err)
+	}
+
+	np, err := tx.NowPlaying()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if np != expected {
+		t.Errorf("expected %v, got %v", expected, np)
+	}
+
+	if err = tx.ClearNowPlaying(); err != nil {
+		t.Fatal(err)
+	}
+	_, err = tx.NowPlaying()
+	if err != queue.ErrNothingPlaying {
+		t.Errorf("expected %v, got %v", queue.ErrNothingPlaying, err)
+	}
+}
+
+func TestRequeueAtHead(t *testing.T) {
+	q, err := queue.OpenQueue(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	tx := q.BeginTxn(true)
+	defer tx.Discard()
+
+	for _, song := range tests[:3] {
+		if _, err := tx.Enqueue(song); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dequeued, err := tx.Dequeue()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = tx.RequeueAtHead(1)
+	if err != queue.ErrSongNotDequeued {
+		t.Errorf("expected %v, got %v", queue.ErrSongNotDequeued, err)
+	}
+
+	requeued, err := tx.RequeueAtHead(dequeued.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requeued.Title != dequeued.Title || requeued.IsDequeued() {
+		t.Errorf("unexpected requeued song: %v", requeued)
+	}
+
+	songs, err := tx.List(0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(songs) != 3 {
+		t.Fatalf("expected 3 songs, got %d", len(songs))
+	}
+	for i, song := range songs {
+		if song.Title != tests[i].Title {
+			t.Errorf("expected %s at position %d, got %s", tests[i].Title, i, song.Title)
+		}
+	}
+}
+
+func TestRequeueAtKeepsSongSettings(t *testing.T) {
+	q, err := queue.OpenQueue(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	tx := q.BeginTxn(true)
+	defer tx.Discard()
+
+	for _, song := range tests[:2] {
+		if _, err := tx.Enqueue(song); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dequeued, err := tx.Dequeue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.SetGain(dequeued.ID, -4.5); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.AdjustGain(dequeued.ID, 2, 15); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.SetUnlimited(dequeued.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.RecordNoShow(dequeued.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	requeued, err := tx.RequeueAt(dequeued.ID, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !requeued.GainMeasured || requeued.Gain != -4.5 || requeued.GainAdjust != 2 {
+		t.Errorf("expected gain -4.5%+.1f to be kept, got %v%+.1f (measured %v)", 2.0, requeued.Gain, requeued.GainAdjust, requeued.GainMeasured)
+	}
+	if !requeued.Unlimited {
+		t.Error("expected exemption from the maximum play time to be kept")
+	}
+	if requeued.NoShows != 1 {
+		t.Errorf("expected 1 no-show, got %d", requeued.NoShows)
+	}
+}
+
+func TestRequeueAt(t *testing.T) {
+	q, err := queue.OpenQueue(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	tx := q.BeginTxn(true)
+	defer tx.Discard()
+
+	for _, song := range tests[:4] {
+		if _, err := tx.Enqueue(song); err != nil {
+			t.Fatal(err)
+		}
+	}
+	first, err := tx.Dequeue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first, err = tx.RecordNoShow(first.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	requeued, err := tx.RequeueAt(first.ID, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requeued.Title != first.Title || requeued.NoShows != 1 {
+		t.Errorf("expected copy with 1 no-show, got %+v", requeued)
+	}
+	second, err := tx.Dequeue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Positions past the end of the queue requeue the song last.
+	if _, err := tx.RequeueAt(second.ID, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	songs, err := tx.List(0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{tests[2].Title, tests[0].Title, tests[3].Title, tests[1].Title}
+	var titles []string
+	for _, song := range songs {
+		titles = append(titles, song.Title)
+	}
+	if !slices.Equal(titles, expected) {
+		t.Errorf("expected %v, got %v", expected, titles)
+	}
+
+	stats, err := tx.GetUserStats(first.UserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.NoShowCount != 1 {
+		t.Errorf("expected 1 no-show, got %d", stats.NoShowCount)
+	}
+}
+
+func TestRequeueFailed(t *testing.T) {
+	q, err := queue.OpenQueue(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	tx := q.BeginTxn(true)
+	defer tx.Discard()
+
+	for _, song := range tests[:2] {
+		if _, err := tx.Enqueue(song); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	failed, err := tx.Dequeue()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = tx.RequeueFailed(failed.ID)
+	if err != queue.ErrSongNotFailed {
+		t.Errorf("expected %v, got %v", queue.ErrSongNotFailed, err)
+	}
+
+	if err = tx.MarkFailed(failed.ID, "loading failed"); err != nil {
+		t.Fatal(err)
+	}
+	song, err := tx.GetByID(failed.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if song.PlayError != "loading failed" {
+		t.Errorf("expected play error to be set, got %q", song.PlayError)
+	}
+
+	requeued, err := tx.RequeueFailed(failed.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requeued.Title != failed.Title || requeued.IsFailed() || requeued.IsDequeued() {
+		t.Errorf("unexpected requeued song: %v", requeued)
+	}
+
+	songs, err := tx.List(0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(songs) != 2 || songs[1].ID != requeued.ID {
+		t.Errorf("expected requeued song at the end of the queue, got %v", songs)
+	}
+
+	_, err = tx.RequeueFailed(failed.ID)
+	if err != queue.ErrSongNotFailed {
+		t.Errorf("expected %v, got %v", queue.ErrSongNotFailed, err)
+	}
+}
+
 func TestMoveForwardPosition(t *testing.T) {
 	testMove(t, 5, 0, 10)
 }
@@ -1130,3 +1442,151 @@ var tests = []queue.NewSong{
 		UserID:       "173979233725448192",
 	},
 }
+
+func TestSongGain(t *testing.T) {
+	q, err := queue.OpenQueue(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	tx := q.BeginTxn(true)
+	defer tx.Discard()
+
+	id, err := tx.Enqueue(tests[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.SetGain(id, -4); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tx.AdjustGain(id, 1.5, 15); err != nil {
+		t.Fatal(err)
+	}
+	song, err := tx.AdjustGain(id, 1.5, 15)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !song.GainMeasured || song.Gain != -4 || song.GainAdjust != 3 {
+		t.Errorf("unexpected gain %v (measured %v), adjustment %v", song.Gain, song.GainMeasured, song.GainAdjust)
+	}
+	if song.TotalGain() != -1 {
+		t.Errorf("expected total gain -1, got %v", song.TotalGain())
+	}
+
+	if song, err = tx.AdjustGain(id, 14, 15); err != nil {
+		t.Fatal(err)
+	}
+	if song.GainAdjust != 15 {
+		t.Errorf("expected adjustment to be limited to 15, got %v", song.GainAdjust)
+	}
+	if song, err = tx.AdjustGain(id, -40, 15); err != nil {
+		t.Fatal(err)
+	}
+	if song.GainAdjust != -15 {
+		t.Errorf("expected adjustment to be limited to -15, got %v", song.GainAdjust)
+	}
+}
+
+func TestSetUnlimited(t *testing.T) {
+	q, err := queue.OpenQueue(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	tx := q.BeginTxn(true)
+	defer tx.Discard()
+
+	id, err := tx.Enqueue(tests[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tx.SetUnlimited(id, true); err != nil {
+		t.Fatal(err)
+	}
+	song, err := tx.GetByID(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !song.Unlimited {
+		t.Error("expected song to be unlimited")
+	}
+	if song, err = tx.SetUnlimited(id, false); err != nil {
+		t.Fatal(err)
+	}
+	if song.Unlimited {
+		t.Error("expected song to be limited again")
+	}
+}
+
+func TestUserSettings(t *testing.T) {
+	q, err := queue.OpenQueue(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	tx := q.BeginTxn(true)
+	defer tx.Discard()
+
+	settings, err := tx.GetUserSettings("user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.NoAnnouncement {
+		t.Error("expected announcements by default")
+	}
+
+	if err := tx.SetUserSettings("user", queue.UserSettings{NoAnnouncement: true}); err != nil {
+		t.Fatal(err)
+	}
+	if settings, err = tx.GetUserSettings("user"); err != nil {
+		t.Fatal(err)
+	}
+	if !settings.NoAnnouncement {
+		t.Error("expected announcements to be disabled")
+	}
+	if settings, err = tx.GetUserSettings("other"); err != nil || settings.NoAnnouncement {
+		t.Errorf("expected other user to keep defaults, got %+v (%v)", settings, err)
+	}
+}
+
+func TestUpdateTimes(t *testing.T) {
+	q, err := queue.OpenQueue(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	tx := q.BeginTxn(true)
+	defer tx.Discard()
+
+	if _, err := tx.Enqueue(tests[0]); err != nil {
+		t.Fatal(err)
+	}
+	song, err := tx.Dequeue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	countdown := time.Now()
+	if _, err := tx.UpdateTimes(song.ID, func(times *queue.PlayTimes) {
+		times.Countdown = countdown
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if song, err = tx.GetByID(song.ID); err != nil {
+		t.Fatal(err)
+	}
+	if !song.Times.Countdown.Equal(countdown) {
+		t.Errorf("expected countdown at %v, got %v", countdown, song.Times.Countdown)
+	}
+
+	requeued, err := tx.RequeueAtHead(song.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !requeued.Times.IsZero() {
+		t.Errorf("expected requeued song without times, got %+v", requeued.Times)
+	}
+}
@@ -26,6 +26,10 @@ const (
 	recordTypeVersion
 	// recordTypeUserStats is a record type for storing a user's queue stats for quick retrieval.
 	recordTypeUserStats
+	// recordTypeNowPlaying is a record type for storing the song being played.
+	recordTypeNowPlaying
+	// recordTypeUserSettings is a record type for storing a user's preferences.
+	recordTypeUserSettings
 )
 
 const headNilID = -1
@@ -35,6 +39,8 @@ var (
 	ErrQueueEmpty      = errors.New("queue is empty")
 	ErrMoveOutOfBounds = errors.New("move out of bounds")
 	ErrSongDequeued    = errors.New("song has already been dequeued")
+	ErrSongNotDequeued = errors.New("song has not been dequeued")
+	ErrSongNotFailed   = errors.New("song has not failed")
 )
 
 type QueueTx struct {
@@ -97,6 +103,153 @@ func (qtx *QueueTx) Dequeue() (headSong QueuedSong, err error) {
 	return
 }
 
+// RequeueAtHead enqueues a copy of a dequeued song and moves it to the
+// head of the queue, returning the requeued song.
+func (qtx *QueueTx) RequeueAtHead(id int) (song QueuedSong, err error) {
+	return qtx.RequeueAt(id, 0)
+}
+
+// RequeueAt enqueues a copy of a dequeued song at the given position, or
+// at the end if the queue is shorter, returning the requeued song. The
+// gain, exemption from the maximum play time and number of no-shows are
+// carried over to the copy.
+func (qtx *QueueTx) RequeueAt(id, position int) (song QueuedSong, err error) {
+	song, err = qtx.GetByID(id)
+	if err != nil {
+		return
+	}
+	if !song.IsDequeued() {
+		err = ErrSongNotDequeued
+		return
+	}
+
+	newID, err := qtx.Enqueue(song.NewSong)
+	if err != nil {
+		return
+	}
+	count, err := qtx.Count()
+	if err != nil {
+		return
+	}
+	position = min(position, count-1)
+	if err = qtx.Move(newID, position); err != nil {
+		return
+	}
+	// Moving reassigns IDs, so the copy is found by its position.
+	requeuedID, err := qtx.idRelativeToHead(position)
+	if err != nil {
+		return
+	}
+	requeued, err := qtx.GetByID(requeuedID)
+	if err != nil {
+		return requeued, err
+	}
+	requeued.Gain = song.Gain
+	requeued.GainMeasured = song.GainMeasured
+	requeued.GainAdjust = song.GainAdjust
+	requeued.Unlimited = song.Unlimited
+	requeued.NoShows = song.NoShows
+	return requeued, qtx.set(requeuedID, requeued)
+}
+
+// RecordNoShow records that the singer did not show up for a dequeued
+// song, returning the updated song.
+func (qtx *QueueTx) RecordNoShow(id int) (song QueuedSong, err error) {
+	song, err = qtx.GetByID(id)
+	if err != nil {
+		return
+	}
+	song.NoShows++
+	if err = qtx.set(id, song); err != nil {
+		return
+	}
+	err = qtx.updateUserStats(song.UserID, func(s *UserStats) {
+		s.NoShowCount++
+	})
+	return
+}
+
+// MarkFailed records that a dequeued song could not be played.
+func (qtx *QueueTx) MarkFailed(id int, reason string) error {
+	song, err := qtx.GetByID(id)
+	if err != nil {
+		return err
+	}
+	song.PlayError = reason
+	return qtx.set(id, song)
+}
+
+// RequeueFailed enqueues a copy of a song that could not be played and
+// clears its error, so that it is only requeued once. Returns the new
+// song.
+func (qtx *QueueTx) RequeueFailed(id int) (song QueuedSong, err error) {
+	failed, err := qtx.GetByID(id)
+	if err != nil {
+		return
+	}
+	if !failed.IsFailed() {
+		err = ErrSongNotFailed
+		return
+	}
+
+	failed.PlayError = ""
+	if err = qtx.set(id, failed); err != nil {
+		return
+	}
+	newID, err := qtx.Enqueue(failed.NewSong)
+	if err != nil {
+		return
+	}
+	return qtx.GetByID(newID)
+}
+
+// SetGain records the measured loudness normalization gain of a song.
+func (qtx *QueueTx) SetGain(id int, gain float64) error {
+	song, err := qtx.GetByID(id)
+	if err != nil {
+		return err
+	}
+	song.Gain = gain
+	song.GainMeasured = true
+	return qtx.set(id, song)
+}
+
+// AdjustGain changes the manual gain adjustment of a song by delta dB,
+// keeping it within ±limit dB, and returns the updated song.
+func (qtx *QueueTx) AdjustGain(id int, delta, limit float64) (song QueuedSong, err error) {
+	song, err = qtx.GetByID(id)
+	if err != nil {
+		return
+	}
+	song.GainAdjust = min(max(song.GainAdjust+delta, -limit), limit)
+	err = qtx.set(id, song)
+	return
+}
+
+// SetUnlimited sets whether a song is exempt from the maximum play time,
+// returning the updated song.
+func (qtx *QueueTx) SetUnlimited(id int, unlimited bool) (song QueuedSong, err error) {
+	song, err = qtx.GetByID(id)
+	if err != nil {
+		return
+	}
+	song.Unlimited = unlimited
+	err = qtx.set(id, song)
+	return
+}
+
+// UpdateTimes changes the play times recorded for a song, returning the
+// updated song.
+func (qtx *QueueTx) UpdateTimes(id int, f func(*PlayTimes)) (song QueuedSong, err error) {
+	song, err = qtx.GetByID(id)
+	if err != nil {
+		return
+	}
+	f(&song.Times)
+	err = qtx.set(id, song)
+	return
+}
+
 // Peek returns the head song without touching the head pointer.
 func (qtx *QueueTx) Peek() (headSong QueuedSong, err error) {
 	return qtx.headSong()
@@ -150,13 +303,19 @@ func (qtx *QueueTx) Remove(id int) (err error) {
 	return
 }
 
-// Update changes a previously queued song by ID.
+// Update changes a previously queued song by ID. If the URL changes, the
+// measured gain and play error of the old file are cleared.
 func (qtx *QueueTx) Update(id int, song NewSong) error {
 	oldSong, err := qtx.GetByID(id)
 	if err != nil {
 		return err
 	}
 
+	if song.SongURL != oldSong.SongURL {
+		oldSong.Gain = 0
+		oldSong.GainMeasured = false
+		oldSong.PlayError = ""
+	}
 	oldSong.NewSong = song
 	return qtx.set(id, oldSong)
 }
@@ -11,6 +11,61 @@ type NewSong struct {
 	SongURL      string
 	ThumbnailURL string
 	Duration     time.Duration
+	// Start and End trim the song. A zero End plays it to the end.
+	Start       time.Duration
+	End         time.Duration
+	Adjustments Adjustments
+	// Subtitles is the path of a subtitle or lyrics file shown with the
+	// song, in the language SubtitleLanguage if it is known.
+	Subtitles        string
+	SubtitleLanguage string
+	// AudioOnly plays only the audio of the song, keeping its poster on
+	// screen instead of the video.
+	AudioOnly bool
+}
+
+// Length returns the duration of the song once trimmed.
+func (s NewSong) Length() time.Duration {
+	end := s.Duration
+	if s.End > 0 && (end == 0 || s.End < end) {
+		end = s.End
+	}
+	return max(end-s.Start, 0)
+}
+
+// IsTrimmed reports whether only part of the song is played.
+func (s NewSong) IsTrimmed() bool {
+	return s.Start > 0 || s.End > 0
+}
+
+// Adjustments change how a song is played.
+type Adjustments struct {
+	// Pitch shifts the key of the song in semitones.
+	Pitch int
+	// Tempo is the playback speed, 1 being the original speed. Zero is
+	// treated as 1.
+	Tempo float64
+	// VocalReduction removes the center channel, where vocals usually
+	// are.
+	VocalReduction bool
+}
+
+// Speed returns the playback speed.
+func (a Adjustments) Speed() float64 {
+	if a.Tempo == 0 {
+		return 1
+	}
+	return a.Tempo
+}
+
+// PlayTime returns how long playing d of the song takes at its tempo.
+func (a Adjustments) PlayTime(d time.Duration) time.Duration {
+	return time.Duration(float64(d) / a.Speed())
+}
+
+// IsZero reports whether the song is played as is.
+func (a Adjustments) IsZero() bool {
+	return a.Pitch == 0 && a.Speed() == 1 && !a.VocalReduction
 }
 
 type QueuedSong struct {
@@ -19,8 +74,53 @@ type QueuedSong struct {
 	Slug       string
 	QueuedAt   time.Time
 	DequeuedAt time.Time
+	// PlayError is set when the song could not be played.
+	PlayError string
+	// Gain is the volume change in dB that normalizes the loudness of
+	// the song. It is only set if GainMeasured is.
+	Gain         float64
+	GainMeasured bool
+	// GainAdjust is a manual volume change in dB applied on top of Gain.
+	GainAdjust float64
+	// Unlimited exempts the song from the maximum play time.
+	Unlimited bool
+	// NoShows is the number of times the singer did not show up for the
+	// song, carried over when it is requeued.
+	NoShows int
+	// Times are when the song was expected to and did go through
+	// playback. They are not carried over when it is requeued.
+	Times PlayTimes
+}
+
+// PlayTimes are when a song was predicted to start and when it went
+// through each step of playback. Steps that were not reached are zero.
+type PlayTimes struct {
+	// Predicted is when the countdown to the song was expected to begin
+	// when it was enqueued.
+	Predicted time.Time
+	// Countdown is when the countdown to the song began.
+	Countdown time.Time
+	// Started is when the song started playing.
+	Started time.Time
+	// Ended is when the song was done with, whether it finished, was
+	// skipped or could not be played.
+	Ended time.Time
+}
+
+// IsZero reports whether no time was recorded.
+func (t PlayTimes) IsZero() bool {
+	return t.Predicted.IsZero() && t.Countdown.IsZero() && t.Started.IsZero() && t.Ended.IsZero()
 }
 
 func (qs *QueuedSong) IsDequeued() bool {
 	return !qs.DequeuedAt.IsZero()
 }
+
+func (qs *QueuedSong) IsFailed() bool {
+	return qs.PlayError != ""
+}
+
+// TotalGain returns the volume change in dB to apply to the song.
+func (qs *QueuedSong) TotalGain() float64 {
+	return qs.Gain + qs.GainAdjust
+}
@@ -0,0 +1,36 @@
+package queue
+
+import (
+	"errors"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// UserSettings are the preferences of a user, kept across nights.
+type UserSettings struct {
+	// NoAnnouncement is set when the user does not want their songs to
+	// be announced.
+	NoAnnouncement bool
+}
+
+// GetUserSettings returns the settings of a user, which are the zero
+// value if they never changed them.
+func (qtx *QueueTx) GetUserSettings(userID string) (settings UserSettings, err error) {
+	err = qtx.getUnmarshaledValue(userSettingsKey(userID), &settings)
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		err = nil
+	}
+	return
+}
+
+// SetUserSettings replaces the settings of a user.
+func (qtx *QueueTx) SetUserSettings(userID string, settings UserSettings) error {
+	return qtx.setMarshaledValue(userSettingsKey(userID), settings)
+}
+
+func userSettingsKey(userID string) (k []byte) {
+	k = make([]byte, 0, len(userID)+1)
+	k = append(k, byte(recordTypeUserSettings))
+	k = append(k, userID...)
+	return
+}
@@ -10,6 +10,9 @@ type UserStats struct {
 	QueuedCount   uint16
 	DequeuedCount uint16
 	DeletedCount  uint16
+	// NoShowCount is the number of times the user did not show up when
+	// their song was up.
+	NoShowCount uint16
 }
 
 var ErrUserStatsNotFound = errors.New("no user stats found")
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/diamondburned/arikawa/v3/api"
+	"github.com/diamondburned/arikawa/v3/discord"
+	"github.com/diamondburned/arikawa/v3/state"
+	"github.com/diamondburned/arikawa/v3/utils/json/option"
+	"github.com/xoltia/mdk3/queue"
+)
+
+// interactionHandler handles the interactions of a room.
+type interactionHandler interface {
+	HandleInteraction(ev *discord.InteractionEvent) *api.InteractionResponse
+}
+
+// room is a karaoke room, with its own queue played by its own mpv
+// process and announced in its own channel.
+type room struct {
+	name      string
+	channelID discord.ChannelID
+	q         *queue.Queue
+	mpv       *mpvSupervisor
+	notifier  *discordNotifier
+	cache     *mediaCache
+	player    *Player
+	handler   interactionHandler
+}
+
+// openRoom opens the queue of a room and starts its mpv process. The
+// room must be closed once it is no longer used.
+func openRoom(ctx context.Context, s *state.State, cfg config, rc namedRoom, music []idleTrack, announce announcer) (r *room, err error) {
+	r = &room{name: rc.name, channelID: discord.ChannelID(rc.Channel)}
+	defer func() {
+		if err != nil {
+			r.Close()
+		}
+	}()
+
+	launcher := newMPVLauncher(cfg.Binary.MPVPath, rc.MPV, ytdlpOptions)
+	if *startProfile != "" {
+		if err := launcher.SetProfile(*startProfile); err != nil {
+			return nil, fmt.Errorf("unable to select mpv profile %q: %w", *startProfile, err)
+		}
+	}
+	r.mpv = newMPVSupervisor(launcher.newProcess)
+	if err := r.mpv.Start(); err != nil {
+		return nil, fmt.Errorf("unable to open mpv client: %w", err)
+	}
+	slog.InfoContext(ctx, "Connected to MPV", slog.String("room", r.name))
+
+	r.q, err = queue.OpenQueue(rc.QueuePath)
+	if err != nil {
+		if errors.Is(err, queue.ErrVersionMismatch) {
+			slog.ErrorContext(ctx, "The current queue data was created with an incompatible version, move/delete it or set a different location in the configuration file", slog.String("room", r.name))
+		}
+		return nil, fmt.Errorf("error opening queue database: %w", err)
+	}
+	go func() {
+		if err := r.q.GC(); err != nil {
+			slog.WarnContext(ctx, "Error calling GC on queue database", slog.String("err", err.Error()), slog.String("room", r.name))
+		}
+	}()
+
+	r.notifier = newDiscordNotifier(s, cfg, rc.Channel)
+	playerOptions := []playerOption{
+		withNotifier(r.notifier),
+		withCountdown(cfg.PlaybackTime),
+		withAutoResume(cfg.AutoResume),
+		withMaxPlayTime(cfg.MaxPlayTime),
+		withAudioOnly(cfg.AudioOnly),
+		withReadyCheck(cfg.ReadyCheck.Timeout, cfg.ReadyCheck.PushBack, cfg.ReadyCheck.MaxNoShows),
+		withHotkeys(cfg.Hotkeys.bindings(), cfg.Hotkeys.ExtendBy),
+		withIdleMusic(music, cfg.IdleMusic.Gain),
+		withAnnouncer(announce),
+	}
+	handlerOptions := []queueCommandHandlerOption{
+		withRoom(r.name),
+		withUserLimit(cfg.UserLimit),
+		withAdminRoles(rc.AdminRoles),
+		withPlaybackTime(cfg.PlaybackTime),
+		withPlayTimeLimit(cfg.MaxPlayTime),
+		withSubtitleFinder(newSubtitleFinder(cfg.Subtitles.Dir, cfg.Subtitles.LyricsDir, cfg.Subtitles.Language)),
+		withMPVProfiles(launcher, r.mpv),
+	}
+	if r.name != "" {
+		// The posters of each room are written to files of their own.
+		dir := filepath.Join(os.TempDir(), "mdk3-"+r.name)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+		playerOptions = append(playerOptions, withPosterRenderer(newFilePosterRenderer(dir)))
+	}
+	if cfg.Cache.Dir != "" {
+		var cacheOptions []mediaCacheOption
+		if cfg.Cache.Normalize {
+			cacheOptions = append(cacheOptions, withLoudnessTarget(cfg.Cache.TargetLoudness))
+		}
+		r.cache = newMediaCache(r.q, filepath.Join(cfg.Cache.Dir, r.name), cfg.Cache.MaxSizeMB<<20, cfg.Cache.Prefetch, cacheOptions...)
+		if err := r.cache.Load(); err != nil {
+			return nil, fmt.Errorf("unable to load media cache: %w", err)
+		}
+		playerOptions = append(playerOptions, withMediaSource(r.cache))
+		handlerOptions = append(handlerOptions, withMediaCache(r.cache))
+	}
+
+	r.player = newPlayer(r.q, r.mpv, playerOptions...)
+	if cfg.StartImmediately {
+		slog.DebugContext(ctx, "Start immediately flag set", slog.String("room", r.name))
+		r.player.SetDequeueEnabled(true)
+	}
+	r.handler = newHandler(s, r.q, append(handlerOptions, withPlayer(r.player))...)
+	return r, nil
+}
+
+// Run plays the queue of the room until the context is cancelled.
+func (r *room) Run(ctx context.Context) {
+	if r.cache != nil {
+		go r.cache.Run(ctx)
+	}
+	go r.notifier.Run(ctx)
+	go r.mpv.Run(ctx, r.player)
+	if err := r.player.Run(ctx); err != nil {
+		slog.ErrorContext(ctx, "Player stopped", slog.String("err", err.Error()), slog.String("room", r.name))
+	}
+}
+
+// Close stops the mpv process of the room and closes its queue.
+func (r *room) Close() {
+	if r.mpv != nil {
+		r.mpv.Close()
+	}
+	if r.q != nil {
+		r.q.Close()
+	}
+}
+
+// roomRouter sends each interaction to the handler of its room. The room
+// is chosen with the room option of commands, or is the one whose channel
+// the interaction happened in.
+type roomRouter struct {
+	rooms []*room
+}
+
+func (rr *roomRouter) HandleInteraction(ev *discord.InteractionEvent) *api.InteractionResponse {
+	r := rr.find(ev)
+	if r == nil {
+		return &api.InteractionResponse{
+			Type: api.MessageInteractionWithSource,
+			Data: &api.InteractionResponseData{
+				Content:         option.NewNullableString("This channel does not belong to a room, choose one with the room option."),
+				Flags:           discord.EphemeralMessage,
+				AllowedMentions: &api.AllowedMentions{},
+			},
+		}
+	}
+	return r.handler.HandleInteraction(ev)
+}
+
+// find returns the room of an interaction, or nil if it is unknown.
+func (rr *roomRouter) find(ev *discord.InteractionEvent) *room {
+	if len(rr.rooms) == 1 {
+		return rr.rooms[0]
+	}
+
+	var name string
+	switch data := ev.Data.(type) {
+	case *discord.CommandInteraction:
+		name = data.Options.Find("room").String()
+	case discord.ComponentInteraction:
+		// Custom IDs of the form kind:value:time:room name their room.
+		if parts := strings.Split(string(data.ID()), ":"); len(parts) == 4 {
+			name = parts[3]
+		}
+	}
+
+	i := slices.IndexFunc(rr.rooms, func(r *room) bool {
+		if name != "" {
+			return r.name == name
+		}
+		return r.channelID == ev.ChannelID
+	})
+	if i < 0 {
+		return nil
+	}
+	return rr.rooms[i]
+}
+
+// roomCommands returns the commands with an option choosing the room
+// when there are several.
+func roomCommands(names []string) []api.CreateCommandData {
+	if len(names) < 2 {
+		return commands
+	}
+	choices := make([]discord.StringChoice, 0, len(names))
+	for _, name := range names {
+		choices = append(choices, discord.StringChoice{Name: name, Value: name})
+	}
+
+	withRooms := make([]api.CreateCommandData, 0, len(commands))
+	for _, command := range commands {
+		command.Options = append(slices.Clone(command.Options), &discord.StringOption{
+			OptionName:  "room",
+			Description: "The room, if not the one of this channel.",
+			Choices:     choices,
+		})
+		withRooms = append(withRooms, command)
+	}
+	return withRooms
+}
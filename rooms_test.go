@@ -0,0 +1,110 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/diamondburned/arikawa/v3/api"
+	"github.com/diamondburned/arikawa/v3/discord"
+)
+
+type fakeInteractionHandler struct {
+	handled int
+}
+
+func (h *fakeInteractionHandler) HandleInteraction(ev *discord.InteractionEvent) *api.InteractionResponse {
+	h.handled++
+	return nil
+}
+
+func TestRoomRouterFindsRoom(t *testing.T) {
+	rooms := []*room{
+		{name: "lobby", channelID: 1, handler: &fakeInteractionHandler{}},
+		{name: "stage", channelID: 2, handler: &fakeInteractionHandler{}},
+	}
+	router := &roomRouter{rooms: rooms}
+
+	tests := []struct {
+		name     string
+		ev       *discord.InteractionEvent
+		expected *room
+	}{
+		{
+			name:     "channel",
+			ev:       &discord.InteractionEvent{ChannelID: 2, Data: &discord.CommandInteraction{Name: "list"}},
+			expected: rooms[1],
+		},
+		{
+			name: "option",
+			ev: &discord.InteractionEvent{ChannelID: 2, Data: &discord.CommandInteraction{
+				Name:    "list",
+				Options: discord.CommandInteractionOptions{{Name: "room", Type: discord.StringOptionType, Value: []byte(`"lobby"`)}},
+			}},
+			expected: rooms[0],
+		},
+		{
+			name:     "component",
+			ev:       &discord.InteractionEvent{ChannelID: 3, Data: &discord.ButtonInteraction{CustomID: "list_page:1:0:stage"}},
+			expected: rooms[1],
+		},
+		{
+			name:     "component in channel",
+			ev:       &discord.InteractionEvent{ChannelID: 1, Data: &discord.ButtonInteraction{CustomID: "ready:4:0"}},
+			expected: rooms[0],
+		},
+		{
+			name: "unknown channel",
+			ev:   &discord.InteractionEvent{ChannelID: 3, Data: &discord.CommandInteraction{Name: "list"}},
+		},
+	}
+	for _, test := range tests {
+		if r := router.find(test.ev); r != test.expected {
+			t.Errorf("%s: expected room %v, got %v", test.name, test.expected, r)
+		}
+	}
+
+	resp := router.HandleInteraction(tests[len(tests)-1].ev)
+	if resp == nil || resp.Data.Flags&discord.EphemeralMessage == 0 {
+		t.Errorf("expected ephemeral response outside of rooms, got %+v", resp)
+	}
+	router.HandleInteraction(tests[0].ev)
+	if handled := rooms[1].handler.(*fakeInteractionHandler).handled; handled != 1 {
+		t.Errorf("expected room to handle 1 interaction, got %d", handled)
+	}
+
+	single := &roomRouter{rooms: rooms[:1]}
+	if r := single.find(tests[len(tests)-1].ev); r != rooms[0] {
+		t.Errorf("expected only room to be found, got %v", r)
+	}
+}
+
+func TestConfigRooms(t *testing.T) {
+	cfg := config{
+		QueuePath: "queue",
+		Discord:   discordConfig{Channel: 1, AdminRoles: []discord.Snowflake{5}},
+		MPV:       mpvConfig{Args: []string{"--fs"}, DialTimeout: 3},
+	}
+	cfg.applyDefaults()
+	if rooms := cfg.rooms(); len(rooms) != 1 || rooms[0].name != "" || rooms[0].Channel != 1 || rooms[0].QueuePath != "queue" {
+		t.Errorf("expected single room from top level settings, got %+v", rooms)
+	}
+
+	cfg.Rooms = map[string]roomConfig{
+		"stage": {Channel: 3, AdminRoles: []discord.Snowflake{}, MPV: mpvConfig{Args: []string{"--screen=1"}}},
+		"lobby": {Channel: 2},
+	}
+	cfg.applyDefaults()
+	rooms := cfg.rooms()
+	if len(rooms) != 2 || rooms[0].name != "lobby" || rooms[1].name != "stage" {
+		t.Fatalf("expected rooms ordered by name, got %+v", rooms)
+	}
+	lobby, stage := rooms[0], rooms[1]
+	if lobby.QueuePath != "queue/lobby" || lobby.MPV.SocketPath != "/tmp/mpvsocket-lobby" {
+		t.Errorf("expected default queue and socket paths, got %q and %q", lobby.QueuePath, lobby.MPV.SocketPath)
+	}
+	if len(lobby.AdminRoles) != 1 || len(stage.AdminRoles) != 0 {
+		t.Errorf("expected admin roles to be inherited unless set, got %v and %v", lobby.AdminRoles, stage.AdminRoles)
+	}
+	if lobby.MPV.Args[0] != "--fs" || stage.MPV.Args[0] != "--screen=1" || stage.MPV.DialTimeout != 3 {
+		t.Errorf("expected mpv settings to be inherited unless set, got %+v and %+v", lobby.MPV, stage.MPV)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var errNoSubtitles = errors.New("no subtitles found")
+
+// subtitleFinder finds subtitles for songs, either by downloading them
+// with yt-dlp or by matching LRC files in a lyrics directory.
+type subtitleFinder struct {
+	dir             string
+	lyricsDir       string
+	defaultLanguage string
+	download        func(ctx context.Context, url, language, dir, key string) error
+}
+
+func newSubtitleFinder(dir, lyricsDir, defaultLanguage string) *subtitleFinder {
+	return &subtitleFinder{
+		dir:             dir,
+		lyricsDir:       lyricsDir,
+		defaultLanguage: defaultLanguage,
+		download:        downloadSubtitles,
+	}
+}
+
+// find returns the subtitle file for a video in the given language, or
+// the default language if none is given, along with the language of the
+// file if it is known. Subtitles published with the video are preferred
+// over local lyrics. Returns errNoSubtitles if there are none.
+func (f *subtitleFinder) find(ctx context.Context, video *VideoInfo, language string) (path, fileLanguage string, err error) {
+	if language == "" {
+		language = f.defaultLanguage
+	}
+
+	err = errNoSubtitles
+	if language != "" {
+		key := cacheKey(video.URL)
+		err = f.download(ctx, video.URL, language, f.dir, key)
+		if err == nil {
+			matches, _ := filepath.Glob(filepath.Join(f.dir, key+"."+language+".*"))
+			if len(matches) > 0 {
+				return matches[0], language, nil
+			}
+			err = errNoSubtitles
+		}
+	}
+
+	if f.lyricsDir != "" {
+		if path, lyricsErr := findLyrics(f.lyricsDir, video); lyricsErr == nil {
+			return path, "", nil
+		}
+	}
+	return "", "", err
+}
+
+// languagePattern matches language codes such as en, ja or pt-BR.
+var languagePattern = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+func validLanguage(language string) bool {
+	return languagePattern.MatchString(language)
+}
+
+// findLyrics returns the LRC file in dir named after the ID or title of a
+// video.
+func findLyrics(dir string, video *VideoInfo) (string, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	for _, file := range files {
+		name := file.Name()
+		ext := filepath.Ext(name)
+		if file.IsDir() || !strings.EqualFold(ext, ".lrc") {
+			continue
+		}
+		stem := strings.TrimSuffix(name, ext)
+		if stem == video.ID || strings.EqualFold(stem, video.Title) {
+			return filepath.Join(dir, name), nil
+		}
+	}
+	return "", errNoSubtitles
+}
+
+// downloadSubtitles writes the subtitles of a URL in a language to dir
+// using yt-dlp, naming the file <key>.<language>.<ext>. Nothing is
+// written if the video has no subtitles in that language.
+func downloadSubtitles(ctx context.Context, url, language, dir, key string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	// The format does not matter as nothing else is downloaded.
+	args := append(ytdlpOptions.args(),
+		"--skip-download",
+		"--no-playlist",
+		"--quiet",
+		"--write-subs",
+		"--sub-langs", language,
+		"--sub-format", "srt/vtt/ass/best",
+		"--output", filepath.Join(dir, key+".%(ext)s"),
+		url,
+	)
+	cmd := exec.CommandContext(ctx, ytdlpPath, args...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if message := lastLine(out); message != "" {
+			return fmt.Errorf("unable to download subtitles: %s", message)
+		}
+		return fmt.Errorf("unable to download subtitles: %w", err)
+	}
+	return nil
+}
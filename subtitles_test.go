@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestSubtitleFinder(t *testing.T, available map[string]string) *subtitleFinder {
+	t.Helper()
+	f := newSubtitleFinder(t.TempDir(), t.TempDir(), "")
+	f.download = func(_ context.Context, url, language, dir, key string) error {
+		if available[url] != language {
+			return nil
+		}
+		return os.WriteFile(filepath.Join(dir, key+"."+language+".vtt"), []byte("WEBVTT"), 0o644)
+	}
+	return f
+}
+
+func TestSubtitleFinderDownloadsSubtitles(t *testing.T) {
+	f := newTestSubtitleFinder(t, map[string]string{"https://example.com/song": "ja"})
+	f.defaultLanguage = "ja"
+	video := &VideoInfo{ID: "song", Title: "Song", URL: "https://example.com/song"}
+
+	path, language, err := f.find(context.Background(), video, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(path) != cacheKey(video.URL)+".ja.vtt" || language != "ja" {
+		t.Errorf("expected downloaded subtitles, got %q (%q)", path, language)
+	}
+
+	if _, _, err := f.find(context.Background(), video, "en"); !errors.Is(err, errNoSubtitles) {
+		t.Errorf("expected no subtitles in another language, got %v", err)
+	}
+}
+
+func TestSubtitleFinderFallsBackToLyrics(t *testing.T) {
+	f := newTestSubtitleFinder(t, nil)
+	for _, name := range []string{"abc123.lrc", "Other Song.LRC", "Song.txt"} {
+		if err := os.WriteFile(filepath.Join(f.lyricsDir, name), []byte("[00:01.00]"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		video    VideoInfo
+		expected string
+	}{
+		{VideoInfo{ID: "abc123", Title: "Song"}, "abc123.lrc"},
+		{VideoInfo{ID: "def456", Title: "other song"}, "Other Song.LRC"},
+		{VideoInfo{ID: "ghi789", Title: "Song"}, ""},
+	}
+	for _, test := range tests {
+		path, language, err := f.find(context.Background(), &test.video, "en")
+		if test.expected == "" {
+			if !errors.Is(err, errNoSubtitles) {
+				t.Errorf("%s: expected no lyrics, got %q (%v)", test.video.ID, path, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.video.ID, err)
+			continue
+		}
+		if filepath.Base(path) != test.expected || language != "" {
+			t.Errorf("%s: expected %s, got %q (%q)", test.video.ID, test.expected, path, language)
+		}
+	}
+}
+
+func TestValidLanguage(t *testing.T) {
+	for _, language := range []string{"en", "ja", "pt-BR", "zh-Hans"} {
+		if !validLanguage(language) {
+			t.Errorf("expected %q to be valid", language)
+		}
+	}
+	for _, language := range []string{"", "e", "english", "en,ja", "--exec", "en/ja"} {
+		if validLanguage(language) {
+			t.Errorf("expected %q to be invalid", language)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/xoltia/mpv"
+)
+
+// errMPVNotRunning is returned by the supervisor while mpv is restarting.
+var errMPVNotRunning = errors.New("mpv is not running")
+
+// restartHandler is told when the supervised mpv process exits and once
+// it has been started again. The exit is requested if it was caused by
+// Restart.
+type restartHandler interface {
+	MediaExited(ctx context.Context, requested bool)
+	MediaRestarted(ctx context.Context)
+}
+
+// mpvSupervisor keeps an mpv process running, restarting it with an
+// exponential backoff whenever it exits. It forwards the mediaBackend
+// methods to the client of the current process.
+type mpvSupervisor struct {
+	newProcess func() *mpv.Process
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	mu            sync.RWMutex
+	process       *mpv.Process
+	client        *mpv.Client
+	startedAt     time.Time
+	closed        bool
+	restarting    bool
+	handlers      map[int]func(map[string]any)
+	nextHandlerID int
+}
+
+var _ mediaBackend = (*mpvSupervisor)(nil)
+
+func newMPVSupervisor(newProcess func() *mpv.Process) *mpvSupervisor {
+	return &mpvSupervisor{
+		newProcess: newProcess,
+		minBackoff: time.Second,
+		maxBackoff: time.Minute,
+		handlers:   make(map[int]func(map[string]any)),
+	}
+}
+
+// Start starts the first mpv process and connects to it.
+func (s *mpvSupervisor) Start() error {
+	return s.start()
+}
+
+func (s *mpvSupervisor) start() error {
+	process := s.newProcess()
+	client, err := process.OpenClient()
+	if err != nil {
+		process.Close()
+		return err
+	}
+	client.AddEventHandlerSync(s.dispatchEvent)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		process.Close()
+		return mpv.ErrProcessClosed
+	}
+	s.process = process
+	s.client = client
+	s.startedAt = time.Now()
+	return nil
+}
+
+// Run waits for mpv to exit and restarts it until the context is
+// cancelled or the supervisor is closed.
+func (s *mpvSupervisor) Run(ctx context.Context, handler restartHandler) {
+	backoff := s.minBackoff
+	for {
+		var requested bool
+		s.mu.RLock()
+		process := s.process
+		s.mu.RUnlock()
+		if process == nil {
+			return
+		}
+
+		exited := make(chan error, 1)
+		go func() { exited <- process.Wait() }()
+
+		select {
+		case <-ctx.Done():
+			return
+		case err := <-exited:
+			s.mu.Lock()
+			closed := s.closed
+			requested = s.restarting
+			uptime := time.Since(s.startedAt)
+			s.process = nil
+			s.client = nil
+			s.restarting = false
+			s.mu.Unlock()
+			if closed {
+				return
+			}
+			if requested {
+				slog.InfoContext(ctx, "MPV stopped for restart")
+				break
+			}
+
+			attrs := []any{slog.Duration("uptime", uptime)}
+			if err != nil {
+				attrs = append(attrs, slog.String("err", err.Error()))
+			}
+			slog.ErrorContext(ctx, "MPV process exited", attrs...)
+
+			// Only keep backing off if mpv keeps crashing shortly after
+			// being started.
+			if uptime > s.maxBackoff {
+				backoff = s.minBackoff
+			}
+		}
+
+		handler.MediaExited(ctx, requested)
+		delay := backoff
+		if requested {
+			delay = 0
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			if !requested {
+				backoff = min(backoff*2, s.maxBackoff)
+			}
+			requested = false
+			delay = backoff
+
+			slog.InfoContext(ctx, "Restarting MPV")
+			err := s.start()
+			if errors.Is(err, mpv.ErrProcessClosed) {
+				return
+			}
+			if err == nil {
+				break
+			}
+			slog.ErrorContext(ctx, "Unable to restart MPV", slog.String("err", err.Error()), slog.Duration("retry_in", backoff))
+		}
+		slog.InfoContext(ctx, "Reconnected to MPV")
+		handler.MediaRestarted(ctx)
+	}
+}
+
+// Restart stops the current mpv process so that Run starts a new one
+// straight away, for example to apply different arguments. mpv is asked
+// to quit so that it has released its socket before the new one starts.
+func (s *mpvSupervisor) Restart(ctx context.Context) error {
+	s.mu.Lock()
+	process, client := s.process, s.client
+	if process == nil {
+		s.mu.Unlock()
+		return errMPVNotRunning
+	}
+	s.restarting = true
+	s.mu.Unlock()
+
+	if _, err := client.Command(ctx, "quit"); err != nil {
+		slog.WarnContext(ctx, "Unable to quit mpv, closing it", slog.String("err", err.Error()))
+		return process.Close()
+	}
+	return nil
+}
+
+// Close stops the current mpv process without restarting it.
+func (s *mpvSupervisor) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.process == nil {
+		return nil
+	}
+	return s.process.Close()
+}
+
+func (s *mpvSupervisor) currentClient() (*mpv.Client, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.client == nil {
+		return nil, errMPVNotRunning
+	}
+	return s.client, nil
+}
+
+func (s *mpvSupervisor) Command(ctx context.Context, command string, args ...any) (any, error) {
+	client, err := s.currentClient()
+	if err != nil {
+		return nil, err
+	}
+	return client.Command(ctx, command, args...)
+}
+
+func (s *mpvSupervisor) LoadFile(ctx context.Context, file string, mode mpv.LoadFileMode) error {
+	client, err := s.currentClient()
+	if err != nil {
+		return err
+	}
+	return client.LoadFile(ctx, file, mode)
+}
+
+func (s *mpvSupervisor) SetProperty(ctx context.Context, property string, value any) error {
+	client, err := s.currentClient()
+	if err != nil {
+		return err
+	}
+	return client.SetProperty(ctx, property, value)
+}
+
+func (s *mpvSupervisor) GetProperty(ctx context.Context, property string) (any, error) {
+	client, err := s.currentClient()
+	if err != nil {
+		return nil, err
+	}
+	return client.GetProperty(ctx, property)
+}
+
+// AddEventHandlerSync adds a handler for the events of every process the
+// supervisor starts. Handlers are called in the order events arrive and
+// must not block.
+func (s *mpvSupervisor) AddEventHandlerSync(fn func(event map[string]any)) (rm func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := s.nextHandlerID
+	s.nextHandlerID++
+	s.handlers[id] = fn
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.handlers, id)
+	}
+}
+
+func (s *mpvSupervisor) dispatchEvent(event map[string]any) {
+	s.mu.RLock()
+	handlers := slices.Collect(maps.Values(s.handlers))
+	s.mu.RUnlock()
+	for _, fn := range handlers {
+		fn(event)
+	}
+}
+
+// ObserveProperty observes a property of the current process. The
+// observer is not carried over when mpv is restarted.
+func (s *mpvSupervisor) ObserveProperty(ctx context.Context, property string, fn func(any)) (func() error, error) {
+	client, err := s.currentClient()
+	if err != nil {
+		return nil, err
+	}
+	return client.ObserveProperty(ctx, property, fn)
+}
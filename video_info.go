@@ -9,11 +9,14 @@ import (
 	"log/slog"
 	"net/url"
 	"os/exec"
+	"regexp"
 	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/kkdai/youtube/v2"
+	"github.com/xoltia/mdk3/queue"
 )
 
 var errAgeRestricted = errors.New("video is age restricted")
@@ -35,7 +38,8 @@ func init() {
 }
 
 func getGenericVideoInfo(ctx context.Context, videoURL *url.URL) (v *VideoInfo, err error) {
-	cmd := exec.CommandContext(ctx, ytdlpPath, "--dump-single-json", videoURL.String())
+	args := append(ytdlpOptions.formatArgs(), "--dump-single-json", videoURL.String())
+	cmd := exec.CommandContext(ctx, ytdlpPath, args...)
 	out, err := cmd.Output()
 
 	if err != nil {
@@ -131,10 +135,12 @@ func isYouTubeLink(videoURL *url.URL) bool {
 	return slices.Contains(youtubeHosts, videoURL.Host)
 }
 
+// getVideoInfo resolves a video URL. YouTube videos are resolved without
+// yt-dlp unless options are set for it, as they would not be applied.
 func getVideoInfo(ctx context.Context, videoURL *url.URL) (v *VideoInfo, err error) {
 	isYouTubeLink := isYouTubeLink(videoURL)
 
-	if isYouTubeLink {
+	if isYouTubeLink && ytdlpOptions.isZero() {
 		v, err = getInfoFromYouTubeBuiltin(ctx, videoURL)
 		if err != nil {
 			slog.WarnContext(ctx, "Failed to get video info from youtube builtin", slog.String("err", err.Error()))
@@ -145,3 +151,78 @@ func getVideoInfo(ctx context.Context, videoURL *url.URL) (v *VideoInfo, err err
 
 	return getGenericVideoInfo(ctx, videoURL)
 }
+
+// unitTimestampPattern matches timestamps such as 90, 90s or 1h2m3s.
+var unitTimestampPattern = regexp.MustCompile(`^(?:(\d+)h)?(?:(\d+)m)?(?:(\d+(?:\.\d+)?)s?)?$`)
+
+// parseURLTimestamp parses a timestamp as found in video URLs, either
+// with units or in the form of [[h:]m:]s.
+func parseURLTimestamp(s string) (time.Duration, error) {
+	if strings.Contains(s, ":") {
+		return parseTimestamp(s)
+	}
+	m := unitTimestampPattern.FindStringSubmatch(s)
+	if s == "" || m == nil {
+		return 0, fmt.Errorf("invalid timestamp: %q", s)
+	}
+	var d time.Duration
+	for i, unit := range []time.Duration{time.Hour, time.Minute, time.Second} {
+		if m[i+1] == "" {
+			continue
+		}
+		n, err := strconv.ParseFloat(m[i+1], 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid timestamp: %q", s)
+		}
+		d += time.Duration(n * float64(unit))
+	}
+	return d, nil
+}
+
+// urlTimestamps returns the start and end offsets given in a video URL,
+// such as ?t=1m30s, ?start=90&end=200 or #t=90,200. Offsets that are
+// missing or invalid are zero.
+func urlTimestamps(videoURL *url.URL) (start, end time.Duration) {
+	query := videoURL.Query()
+	for _, key := range []string{"t", "start"} {
+		if value := query.Get(key); value != "" {
+			start, _ = parseURLTimestamp(value)
+			break
+		}
+	}
+	if value := query.Get("end"); value != "" {
+		end, _ = parseURLTimestamp(value)
+	}
+
+	// Media fragments give both offsets, as in #t=start,end.
+	if fragment, ok := strings.CutPrefix(videoURL.Fragment, "t="); ok {
+		from, to, _ := strings.Cut(fragment, ",")
+		if d, err := parseURLTimestamp(from); err == nil {
+			start = d
+		}
+		if d, err := parseURLTimestamp(to); err == nil && to != "" {
+			end = d
+		}
+	}
+
+	if end <= start {
+		end = 0
+	}
+	return start, end
+}
+
+// formatTrim describes the part of a song that is played, such as
+// "0:45 – 3:00 (2:15)".
+func formatTrim(s queue.NewSong) string {
+	end := "end"
+	if s.End > 0 {
+		end = formatPlaybackTime(s.End.Seconds())
+	} else if s.Duration > 0 {
+		end = formatPlaybackTime(s.Duration.Seconds())
+	}
+	trim := fmt.Sprintf("%s – %s", formatPlaybackTime(s.Start.Seconds()), end)
+	if s.Duration > 0 {
+		trim += fmt.Sprintf(" (%s)", formatPlaybackTime(s.Length().Seconds()))
+	}
+	return trim
+}
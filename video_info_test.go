@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestURLTimestamps(t *testing.T) {
+	tests := []struct {
+		url   string
+		start time.Duration
+		end   time.Duration
+	}{
+		{"https://www.youtube.com/watch?v=abc", 0, 0},
+		{"https://www.youtube.com/watch?v=abc&t=45", 45 * time.Second, 0},
+		{"https://youtu.be/abc?t=45s", 45 * time.Second, 0},
+		{"https://www.youtube.com/watch?v=abc&t=1h2m3s", time.Hour + 2*time.Minute + 3*time.Second, 0},
+		{"https://www.youtube.com/embed/abc?start=30&end=90", 30 * time.Second, 90 * time.Second},
+		{"https://vimeo.com/123#t=1m30s", 90 * time.Second, 0},
+		{"https://example.com/song.mp4#t=10,1:20", 10 * time.Second, 80 * time.Second},
+		{"https://www.youtube.com/watch?v=abc&t=90&end=30", 90 * time.Second, 0},
+		{"https://www.youtube.com/watch?v=abc&t=soon", 0, 0},
+	}
+	for _, test := range tests {
+		u, err := url.Parse(test.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		start, end := urlTimestamps(u)
+		if start != test.start || end != test.end {
+			t.Errorf("%s: expected %v-%v, got %v-%v", test.url, test.start, test.end, start, end)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"maps"
+	"slices"
+)
+
+// ytdlOptions are the yt-dlp options applied wherever yt-dlp is used, both
+// when it is run to resolve and download songs and when mpv runs it to
+// play them, so that songs are played the way they were validated.
+type ytdlOptions struct {
+	// format is the format selection. yt-dlp's default is used when it
+	// is empty.
+	format string
+	// raw are options given by their long name without dashes, in order.
+	// Options that take no value have an empty one.
+	raw []ytdlOption
+}
+
+type ytdlOption struct {
+	name  string
+	value string
+}
+
+// ytdlpOptions are the options used for every run of yt-dlp.
+var ytdlpOptions ytdlOptions
+
+func newYTDLOptions(cfg ytdlConfig) ytdlOptions {
+	o := ytdlOptions{format: cfg.Format}
+	if cfg.Cookies != "" {
+		o.raw = append(o.raw, ytdlOption{"cookies", cfg.Cookies})
+	}
+	if cfg.Proxy != "" {
+		o.raw = append(o.raw, ytdlOption{"proxy", cfg.Proxy})
+	}
+	if cfg.RateLimit != "" {
+		o.raw = append(o.raw, ytdlOption{"limit-rate", cfg.RateLimit})
+	}
+	for _, name := range slices.Sorted(maps.Keys(cfg.Options)) {
+		o.raw = append(o.raw, ytdlOption{name, cfg.Options[name]})
+	}
+	return o
+}
+
+// isZero reports whether no options are set.
+func (o ytdlOptions) isZero() bool {
+	return o.format == "" && len(o.raw) == 0
+}
+
+// args returns the raw options as command line arguments of yt-dlp.
+func (o ytdlOptions) args() []string {
+	var args []string
+	for _, opt := range o.raw {
+		args = append(args, "--"+opt.name)
+		if opt.value != "" {
+			args = append(args, opt.value)
+		}
+	}
+	return args
+}
+
+// formatArgs returns the arguments of yt-dlp selecting the format, along
+// with the raw options.
+func (o ytdlOptions) formatArgs() []string {
+	args := o.args()
+	if o.format != "" {
+		args = append(args, "--format", o.format)
+	}
+	return args
+}
+
+// mpvArgs returns the arguments making mpv pass the options to yt-dlp.
+// Each raw option is appended on its own so that values may contain
+// commas.
+func (o ytdlOptions) mpvArgs() []string {
+	var args []string
+	if o.format != "" {
+		args = append(args, "--ytdl-format="+o.format)
+	}
+	for _, opt := range o.raw {
+		args = append(args, "--ytdl-raw-options-append="+opt.name+"="+opt.value)
+	}
+	return args
+}
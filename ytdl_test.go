@@ -0,0 +1,44 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestYTDLOptions(t *testing.T) {
+	o := newYTDLOptions(ytdlConfig{
+		Format:    "bestvideo[height<=720]+bestaudio/best",
+		Cookies:   "/etc/mdk3/cookies.txt",
+		RateLimit: "2M",
+		Options: map[string]string{
+			"no-check-certificates": "",
+			"extractor-args":        "youtube:player_client=web,ios",
+		},
+	})
+
+	expected := []string{
+		"--cookies", "/etc/mdk3/cookies.txt",
+		"--limit-rate", "2M",
+		"--extractor-args", "youtube:player_client=web,ios",
+		"--no-check-certificates",
+		"--format", "bestvideo[height<=720]+bestaudio/best",
+	}
+	if args := o.formatArgs(); !slices.Equal(args, expected) {
+		t.Errorf("expected yt-dlp arguments %q, got %q", expected, args)
+	}
+
+	expected = []string{
+		"--ytdl-format=bestvideo[height<=720]+bestaudio/best",
+		"--ytdl-raw-options-append=cookies=/etc/mdk3/cookies.txt",
+		"--ytdl-raw-options-append=limit-rate=2M",
+		"--ytdl-raw-options-append=extractor-args=youtube:player_client=web,ios",
+		"--ytdl-raw-options-append=no-check-certificates=",
+	}
+	if args := o.mpvArgs(); !slices.Equal(args, expected) {
+		t.Errorf("expected mpv arguments %q, got %q", expected, args)
+	}
+
+	if o.isZero() || !newYTDLOptions(ytdlConfig{}).isZero() {
+		t.Error("expected only options without settings to be zero")
+	}
+}